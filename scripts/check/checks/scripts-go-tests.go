@@ -8,7 +8,7 @@ import (
 	"strings"
 )
 
-// RunGoTests runs Go tests.
+// RunGoTests runs Go tests with the race detector.
 func RunGoTests(ctx *CheckContext) (CheckResult, error) {
 	allModules, err := FindAllGoModules(ctx.RootDir)
 	if err != nil {
@@ -24,7 +24,7 @@ func RunGoTests(ctx *CheckContext) (CheckResult, error) {
 			modDir := filepath.Join(baseDir, mod)
 			modLabel := filepath.Join(goDir, mod)
 
-			cmd := exec.Command("go", "test", "./...")
+			cmd := exec.Command("go", "test", "-race", "./...")
 			cmd.Dir = modDir
 			output, err := RunCommand(cmd, true)
 			if err != nil {
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchEntry is one repo in a batch list file.
+type batchEntry struct {
+	repo string // Path or URL
+	ref  string // Empty for the default branch
+	name string // Unique within the batch, used to name its output file
+}
+
+// batchResult is the outcome of analyzing one batch entry.
+type batchResult struct {
+	entry    batchEntry
+	analysis *analysis // Nil if it failed
+	err      error
+}
+
+// readBatchFile reads a list of repos to analyze, one per line as "PATH_OR_URL [REF]". Blank lines and lines
+// starting with # are skipped.
+func readBatchFile(path string) ([]batchEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open batch file: %w", err)
+	}
+	defer file.Close()
+
+	var entries []batchEntry
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected a repo and an optional ref, got %q", path, lineNo, line)
+		}
+		entry := batchEntry{repo: fields[0]}
+		if len(fields) == 2 {
+			entry.ref = fields[1]
+		}
+
+		// Repos listed more than once, say with different refs, get numbered
+		base := batchName(entry.repo)
+		entry.name = base
+		for n := 2; names[entry.name]; n++ {
+			entry.name = base + "-" + strconv.Itoa(n)
+		}
+		names[entry.name] = true
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read batch file: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no repos listed in %s", path)
+	}
+	return entries, nil
+}
+
+// batchName returns a file name for a repo: owner-name for forge URLs, else the repo's directory name.
+func batchName(repo string) string {
+	if cloned, _ := isRepoURL(repo); cloned {
+		if remote, err := parseRepoURL(repo); err == nil {
+			if remote.owner != "" {
+				return remote.owner + "-" + remote.name
+			}
+			return remote.name
+		}
+	}
+	if abs, err := filepath.Abs(repo); err == nil {
+		repo = abs
+	}
+	return strings.TrimSuffix(filepath.Base(repo), ".git")
+}
+
+// runBatch analyzes each repo listed in flags.batchFile, writes each one's report to flags.outDir, and a
+// portfolio of all of them to flags.output or stdout. Repos run concurrently, but with at most flags.workers
+// workers counting lines at once across all of them. A repo that fails is reported in the portfolio, and
+// doesn't stop the others.
+func runBatch(flags *cliFlags) error {
+	if err := checkFormat(flags); err != nil {
+		return err
+	}
+	if flags.maxBlob > 0 {
+		maxBufferedBlob = flags.maxBlob
+	}
+	entries, err := readBatchFile(flags.batchFile)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(flags.outDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output dir: %w", err)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+
+	// Each repo can use the whole budget once the others are done. Every open repo needs at least one worker,
+	// so there are never more of them open than workers. Their cat-file processes stay up while they're open,
+	// so the budget is split between them up front.
+	slots := make(chan struct{}, flags.workers)
+	open := make(chan struct{}, flags.workers)
+	readers := batchReaders(flags.workers, len(entries))
+	results := make([]batchResult, len(entries))
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = batchResult{entry: entry}
+			select {
+			case open <- struct{}{}:
+				defer func() { <-open }()
+			case <-ctx.Done():
+				results[i].err = ctx.Err()
+				return
+			}
+
+			log := newLogger("["+entry.name+"] ", false, flags.verbose)
+			repoFlags := *flags
+			repoFlags.repoPath, repoFlags.ref = entry.repo, entry.ref
+			// An interrupted repo's partial report is written, but it still counts as failed
+			result, err := analyze(ctx, &repoFlags, pipelineOptions{workers: flags.workers, readers: readers, slots: slots, log: log})
+			if result != nil {
+				writeErr := writeAnalysisFile(filepath.Join(flags.outDir, entry.name+"."+flags.format), flags.format, result)
+				if err == nil {
+					err = writeErr
+				}
+			}
+			if err != nil {
+				log.printf("Failed: %v", err)
+				results[i].err = err
+				return
+			}
+			log.printf("Done, %d lines", result.days[len(result.days)-1].total)
+			results[i].analysis = result
+		}()
+	}
+	wg.Wait()
+
+	var out io.Writer = os.Stdout
+	if flags.output != "" {
+		file, err := os.Create(flags.output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	if flags.format == "json" {
+		err = writePortfolioJSON(out, results, time.Now())
+	} else {
+		err = writePortfolioCSV(out, results)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d repos failed", failed, len(results))
+	}
+	return nil
+}
+
+// batchReaders returns how many objects each repo's exec backend reads in parallel, so the cat-file processes of
+// all open repos fit in the workers budget together.
+func batchReaders(workers, repos int) int {
+	return max(workers/min(repos, workers), 1)
+}
+
+func writeAnalysisFile(path, format string, result *analysis) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if err := writeAnalysis(file, format, result); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// lastDay returns a repo's line counts on its last day.
+func (r batchResult) lastDay() *fileStats {
+	return r.analysis.days[len(r.analysis.days)-1]
+}
+
+// portfolioTotal sums the line counts of all successful repos on their last days.
+func portfolioTotal(results []batchResult) *fileStats {
+	total := newFileStats(nil)
+	for _, r := range results {
+		if r.err != nil {
+			continue
+		}
+		last := r.lastDay()
+		total.total += last.total
+		for id, count := range last.languages {
+			sum, ok := total.languages[id]
+			if !ok {
+				sum = &languageCount{}
+				total.languages[id] = sum
+			}
+			sum.total += count.total
+			sum.prod += count.prod
+			sum.test += count.test
+			sum.code += count.code
+			sum.comment += count.comment
+			sum.blank += count.blank
+		}
+	}
+	return total
+}
+
+// writePortfolioCSV writes one row per repo with its line counts per language on its last day, and a row
+// with their totals.
+func writePortfolioCSV(w io.Writer, results []batchResult) error {
+	total := portfolioTotal(results)
+	columns := buildCSVColumns(detectLanguages([]*fileStats{total}), false)
+
+	cw := csv.NewWriter(w)
+	header := []string{"repo", "ref", "head", "last date", "total"}
+	for _, col := range columns {
+		header = append(header, col.header)
+	}
+	header = append(header, "error")
+	if err := cw.Write(header); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	row := func(first []string, stats *fileStats, errText string) []string {
+		row := append(first, strconv.Itoa(stats.total))
+		for _, col := range columns {
+			row = append(row, strconv.Itoa(col.value(stats)))
+		}
+		return append(row, errText)
+	}
+	for _, r := range results {
+		var record []string
+		if r.err != nil {
+			record = row([]string{r.entry.repo, r.entry.ref, "", ""}, newFileStats(nil), r.err.Error())
+		} else {
+			meta := r.analysis.meta
+			record = row([]string{r.entry.repo, meta.defaultBranch, meta.headCommit, r.analysis.dates[len(r.analysis.dates)-1]}, r.lastDay(), "")
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV row for %s: %w", r.entry.repo, err)
+		}
+	}
+	if err := cw.Write(row([]string{"total", "", "", ""}, total, "")); err != nil {
+		return fmt.Errorf("failed to write CSV total row: %w", err)
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// jsonPortfolio is batch mode's combined report: each repo's line counts on its last day, and their totals.
+type jsonPortfolio struct {
+	AnalyzedAt string                       `json:"analyzedAt"`
+	Repos      []jsonPortfolioRepo          `json:"repos"`
+	Total      int                          `json:"total"`
+	Languages  map[string]jsonLanguageCount `json:"languages"`
+	Failed     int                          `json:"failed"`
+}
+
+// jsonPortfolioRepo is one repo in a jsonPortfolio. Only repo, ref, and error are set if it failed.
+type jsonPortfolioRepo struct {
+	Repo       string                       `json:"repo"` // As listed in the batch file
+	RepoURL    string                       `json:"repoUrl,omitempty"`
+	Ref        string                       `json:"ref,omitempty"`
+	HeadCommit string                       `json:"headCommit,omitempty"`
+	LastDate   string                       `json:"lastDate,omitempty"`
+	Total      int                          `json:"total"`
+	Languages  map[string]jsonLanguageCount `json:"languages"`
+	Error      string                       `json:"error,omitempty"`
+}
+
+func writePortfolioJSON(w io.Writer, results []batchResult, analyzedAt time.Time) error {
+	total := portfolioTotal(results)
+	portfolio := jsonPortfolio{
+		AnalyzedAt: analyzedAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Repos:      make([]jsonPortfolioRepo, len(results)),
+		Total:      total.total,
+		Languages:  jsonLanguages(total.languages),
+	}
+	for i, r := range results {
+		repo := jsonPortfolioRepo{Repo: r.entry.repo, Ref: r.entry.ref, Languages: map[string]jsonLanguageCount{}}
+		if r.err != nil {
+			repo.Error = r.err.Error()
+			portfolio.Failed++
+		} else {
+			meta, last := r.analysis.meta, r.lastDay()
+			repo.RepoURL, repo.Ref, repo.HeadCommit = meta.repoURL, meta.defaultBranch, meta.headCommit
+			repo.LastDate = r.analysis.dates[len(r.analysis.dates)-1]
+			repo.Total, repo.Languages = last.total, jsonLanguages(last.languages)
+		}
+		portfolio.Repos[i] = repo
+	}
+
+	if err := json.NewEncoder(w).Encode(portfolio); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	return nil
+}
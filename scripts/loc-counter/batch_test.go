@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadBatchFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "repos.txt")
+	content := "# Team repos\n\n/srv/git/api.git\nhttps://github.com/Owner/API v1..main\n  /srv/git/api.git  develop\nowner/web\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readBatchFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []batchEntry{
+		{repo: "/srv/git/api.git", name: "api"},
+		{repo: "https://github.com/Owner/API", ref: "v1..main", name: "owner-api"},
+		{repo: "/srv/git/api.git", ref: "develop", name: "api-2"},
+		{repo: "owner/web", name: "owner-web"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("readBatchFile() = %+v, want %+v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"# Nothing here\n", "repo ref extra\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readBatchFile(path); err == nil {
+			t.Errorf("readBatchFile(%q) should fail", bad)
+		}
+	}
+}
+
+func TestBatchReaders(t *testing.T) {
+	tests := []struct {
+		workers, repos, want int
+	}{
+		{8, 1, 8},
+		{8, 2, 4},
+		{8, 3, 2},
+		{8, 20, 1},
+		{1, 5, 1},
+	}
+	for _, tt := range tests {
+		got := batchReaders(tt.workers, tt.repos)
+		if got != tt.want {
+			t.Errorf("batchReaders(%d, %d) = %d, want %d", tt.workers, tt.repos, got, tt.want)
+		}
+		if open := min(tt.repos, tt.workers); got*open > tt.workers {
+			t.Errorf("batchReaders(%d, %d) = %d, so %d open repos read %d objects at once", tt.workers, tt.repos, got,
+				open, got*open)
+		}
+	}
+}
+
+func TestRunBatch_FailingRepoDoesNotStopOthers(t *testing.T) {
+	first := newTestRepo(t)
+	first.write("main.go", "package main\n\nfunc main() {}\n")
+	first.commit("2024-03-01T10:00:00Z", "Add main")
+	second := newTestRepo(t)
+	second.write("lib.go", "package lib\n")
+	second.write("README.md", "# Lib\n")
+	second.commit("2024-04-01T10:00:00Z", "Add lib")
+
+	dir := t.TempDir()
+	list := filepath.Join(dir, "repos.txt")
+	missing := filepath.Join(dir, "missing")
+	content := first.dir + "\n" + missing + "\n" + second.dir + " main\n"
+	if err := os.WriteFile(list, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	outDir := filepath.Join(dir, "out")
+	portfolio := filepath.Join(dir, "portfolio.csv")
+	err := runBatch(&cliFlags{batchFile: list, outDir: outDir, output: portfolio, format: "csv", workers: 2, noCache: true})
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 repos failed") {
+		t.Errorf("runBatch() error = %v, want 1 of 3 failed", err)
+	}
+
+	for _, name := range []string{filepath.Base(first.dir), filepath.Base(second.dir)} {
+		if _, err := os.Stat(filepath.Join(outDir, name+".csv")); err != nil {
+			t.Errorf("missing per-repo output: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(portfolio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("portfolio has %d lines, want header, 3 repos, and total:\n%s", len(lines), data)
+	}
+	if want := "repo,ref,head,last date,total,go,go prod,go test,go code,go comment,go blank,docs,docs code,docs comment,docs blank,error"; lines[0] != want {
+		t.Errorf("header = %q, want %q", lines[0], want)
+	}
+	if !strings.HasPrefix(lines[1], first.dir+",main,") || !strings.HasSuffix(lines[1], ",2024-03-01,3,3,3,0,2,0,1,0,0,0,0,") {
+		t.Errorf("first repo row = %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], missing+",,,,0,") || !strings.Contains(lines[2], "not a git repository") {
+		t.Errorf("failed repo row = %q", lines[2])
+	}
+	if !strings.HasSuffix(lines[3], ",2024-04-01,2,1,1,0,1,0,0,1,1,0,0,") {
+		t.Errorf("second repo row = %q", lines[3])
+	}
+	if want := "total,,,,5,4,4,0,3,0,1,1,1,0,0,"; lines[4] != want {
+		t.Errorf("total row = %q, want %q", lines[4], want)
+	}
+}
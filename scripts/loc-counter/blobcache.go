@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// blobCacheVersion is bumped whenever the way blobs are counted changes, so stale cache files are ignored.
+const blobCacheVersion = 6
+
+// blobCacheMagic starts every cache file, followed by a version byte.
+const blobCacheMagic = "GSBLOBC"
+
+// blobInfo holds the facts about a blob that only depend on its content.
+type blobInfo struct {
+	lines           int
+	comments        int // Lines with only comments, by lang's syntax
+	blanks          int
+	inlineTestLines int    // Result of lang's inline test counter, if it has one
+	lang            string // Language id the blob was counted as, from blobLanguageID
+	binary          bool
+	generated       generatedReason // Why the content looks generated, if it does
+	// tooLarge is the blob's size if it needed inline test counting but was larger than maxBufferedBlob, so
+	// the counter didn't run. The entry stands in for one with inline counts while the blob still doesn't fit.
+	tooLarge int
+}
+
+// blobPair is an old and a new version of a file.
+type blobPair struct {
+	from string
+	to   string
+}
+
+// blobCache maps blob SHAs to their counts, and pairs of blob SHAs to their line diffs. The same blob
+// (and the same change) shows up in many commits and many runs, so each one only needs to be read and
+// counted once. Safe for concurrent use.
+type blobCache struct {
+	mu      sync.RWMutex
+	entries map[string]blobInfo
+	diffs   map[blobPair]lineDiff
+	path    string // Where to persist the cache; empty to keep it in memory only
+	dirty   bool
+}
+
+func newBlobCache(path string) *blobCache {
+	return &blobCache{entries: make(map[string]blobInfo), diffs: make(map[blobPair]lineDiff), path: path}
+}
+
+// get returns the cached info for a blob. The entry only counts as a hit if the blob was counted as lang, from
+// blobLanguageID, or is binary. Entries for blobs too large for inline test counting only count while they still
+// don't fit.
+func (c *blobCache) get(blob, lang string) (blobInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	info, ok := c.entries[blob]
+	if !ok || (!info.binary && info.lang != lang) || (info.tooLarge > 0 && info.tooLarge <= maxBufferedBlob) {
+		return blobInfo{}, false
+	}
+	return info, true
+}
+
+// getAnyLanguage returns the cached info for a blob, whichever language it was counted as. Only its line
+// count and whether it's binary can be relied on.
+func (c *blobCache) getAnyLanguage(blob string) (blobInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	info, ok := c.entries[blob]
+	return info, ok
+}
+
+func (c *blobCache) put(blob string, info blobInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Don't replace language-specific counts with an entry that has none
+	if existing, ok := c.entries[blob]; ok && info.lang == "" && existing.lang != "" {
+		return
+	}
+	c.entries[blob] = info
+	c.dirty = true
+}
+
+func (c *blobCache) getDiff(pair blobPair) (lineDiff, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	diff, ok := c.diffs[pair]
+	return diff, ok
+}
+
+func (c *blobCache) putDiff(pair blobPair, diff lineDiff) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.diffs[pair] = diff
+	c.dirty = true
+}
+
+// gitstrataDir returns where gitstrata keeps its files for repo: inside its git dir, so they're shared by all
+// worktrees and removed with the repo.
+func gitstrataDir(repo repository) string {
+	return filepath.Join(repo.gitDir(), "gitstrata")
+}
+
+// defaultBlobCachePath returns where the cache for repo lives.
+func defaultBlobCachePath(repo repository) string {
+	return filepath.Join(gitstrataDir(repo), "blob-cache")
+}
+
+// load reads the cache file if there is one. Missing files and files from other cache versions are
+// silently ignored.
+func (c *blobCache) load() error {
+	if c.path == "" {
+		return nil
+	}
+	file, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open blob cache: %w", err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	header := make([]byte, len(blobCacheMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(blobCacheMagic)]) != blobCacheMagic ||
+		header[len(blobCacheMagic)] != blobCacheVersion {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		kind, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		switch {
+		case err != nil:
+		case kind == blobRecord:
+			var blob string
+			var info blobInfo
+			if blob, info, err = readBlobCacheEntry(r); err == nil {
+				c.entries[blob] = info
+			}
+		case kind == diffRecord:
+			var pair blobPair
+			var diff lineDiff
+			if pair, diff, err = readBlobCacheDiff(r); err == nil {
+				c.diffs[pair] = diff
+			}
+		default:
+			err = fmt.Errorf("unknown record type %d", kind)
+		}
+		if err != nil {
+			return fmt.Errorf("corrupt blob cache %s: %w", c.path, err)
+		}
+	}
+}
+
+// save writes the cache to disk if anything changed since it was loaded.
+// Writes to a temp file first so an interrupted save never leaves a truncated cache behind.
+func (c *blobCache) save() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.path == "" || !c.dirty {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create blob cache dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), "blob-cache-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create blob cache: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	w := bufio.NewWriter(tmp)
+	w.WriteString(blobCacheMagic)
+	w.WriteByte(blobCacheVersion)
+	for blob, info := range c.entries {
+		w.WriteByte(blobRecord)
+		if err := writeBlobCacheEntry(w, blob, info); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write blob cache: %w", err)
+		}
+	}
+	for pair, diff := range c.diffs {
+		w.WriteByte(diffRecord)
+		if err := writeBlobCacheDiff(w, pair, diff); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write blob cache: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to replace blob cache: %w", err)
+	}
+	return nil
+}
+
+// Each record starts with its type.
+const (
+	blobRecord byte = 'b'
+	diffRecord byte = 'd'
+)
+
+// Blob entry format: 20-byte binary SHA, flags byte (bit 0: binary, bits 1-3: generated reason), uvarint lines,
+// uvarint comment lines, uvarint blank lines, uvarint inline test lines, uvarint size if too large to buffer,
+// uvarint language length, language id.
+func writeBlobCacheEntry(w *bufio.Writer, blob string, info blobInfo) error {
+	sha, err := hex.DecodeString(blob)
+	if err != nil || len(sha) != 20 {
+		return fmt.Errorf("invalid blob SHA %q", blob)
+	}
+	w.Write(sha)
+	var flags byte
+	if info.binary {
+		flags |= 1
+	}
+	flags |= byte(info.generated) << 1
+	w.WriteByte(flags)
+	buf := make([]byte, 0, 6*binary.MaxVarintLen64)
+	buf = binary.AppendUvarint(buf, uint64(info.lines))
+	buf = binary.AppendUvarint(buf, uint64(info.comments))
+	buf = binary.AppendUvarint(buf, uint64(info.blanks))
+	buf = binary.AppendUvarint(buf, uint64(info.inlineTestLines))
+	buf = binary.AppendUvarint(buf, uint64(info.tooLarge))
+	buf = binary.AppendUvarint(buf, uint64(len(info.lang)))
+	w.Write(buf)
+	_, err = w.WriteString(info.lang)
+	return err
+}
+
+func readBlobCacheEntry(r *bufio.Reader) (string, blobInfo, error) {
+	sha := make([]byte, 20)
+	if _, err := io.ReadFull(r, sha); err != nil {
+		return "", blobInfo{}, io.ErrUnexpectedEOF
+	}
+	flags, err := r.ReadByte()
+	if err != nil {
+		return "", blobInfo{}, io.ErrUnexpectedEOF
+	}
+	var nums [6]uint64
+	for i := range nums {
+		if nums[i], err = binary.ReadUvarint(r); err != nil {
+			return "", blobInfo{}, io.ErrUnexpectedEOF
+		}
+	}
+	generated := generatedReason(flags >> 1 & 7)
+	if generated >= generatedReasons {
+		return "", blobInfo{}, fmt.Errorf("unknown generated reason %d", generated)
+	}
+	if nums[5] > 64 {
+		return "", blobInfo{}, fmt.Errorf("language id too long (%d bytes)", nums[5])
+	}
+	lang := make([]byte, nums[5])
+	if _, err := io.ReadFull(r, lang); err != nil {
+		return "", blobInfo{}, io.ErrUnexpectedEOF
+	}
+	return hex.EncodeToString(sha), blobInfo{
+		lines:           int(nums[0]),
+		comments:        int(nums[1]),
+		blanks:          int(nums[2]),
+		inlineTestLines: int(nums[3]),
+		tooLarge:        int(nums[4]),
+		lang:            string(lang),
+		binary:          flags&1 != 0,
+		generated:       generated,
+	}, nil
+}
+
+// Diff entry format: 20-byte binary SHA of the old blob, 20-byte binary SHA of the new blob, uvarint lines
+// added, uvarint lines removed.
+func writeBlobCacheDiff(w *bufio.Writer, pair blobPair, diff lineDiff) error {
+	for _, blob := range []string{pair.from, pair.to} {
+		sha, err := hex.DecodeString(blob)
+		if err != nil || len(sha) != 20 {
+			return fmt.Errorf("invalid blob SHA %q", blob)
+		}
+		w.Write(sha)
+	}
+	buf := make([]byte, 0, 2*binary.MaxVarintLen64)
+	buf = binary.AppendUvarint(buf, uint64(diff.added))
+	buf = binary.AppendUvarint(buf, uint64(diff.removed))
+	_, err := w.Write(buf)
+	return err
+}
+
+func readBlobCacheDiff(r *bufio.Reader) (blobPair, lineDiff, error) {
+	shas := make([]byte, 40)
+	if _, err := io.ReadFull(r, shas); err != nil {
+		return blobPair{}, lineDiff{}, io.ErrUnexpectedEOF
+	}
+	added, err := binary.ReadUvarint(r)
+	if err != nil {
+		return blobPair{}, lineDiff{}, io.ErrUnexpectedEOF
+	}
+	removed, err := binary.ReadUvarint(r)
+	if err != nil {
+		return blobPair{}, lineDiff{}, io.ErrUnexpectedEOF
+	}
+	pair := blobPair{from: hex.EncodeToString(shas[:20]), to: hex.EncodeToString(shas[20:])}
+	return pair, lineDiff{added: int(added), removed: int(removed)}, nil
+}
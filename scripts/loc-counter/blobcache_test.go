@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testBlobA = "0123456789abcdef0123456789abcdef01234567"
+	testBlobB = "fedcba9876543210fedcba9876543210fedcba98"
+)
+
+func TestBlobCache_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gitstrata", "blob-cache")
+
+	cache := newBlobCache(path)
+	cache.put(testBlobA, blobInfo{lines: 120, comments: 30, blanks: 12, inlineTestLines: 40, lang: "rust", generated: generatedMarker})
+	cache.put(testBlobB, blobInfo{binary: true})
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newBlobCache(path)
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := loaded.get(testBlobA, "rust"); !ok || info != (blobInfo{lines: 120, comments: 30, blanks: 12, inlineTestLines: 40, lang: "rust", generated: generatedMarker}) {
+		t.Errorf("get(A) = %+v, %v", info, ok)
+	}
+	if info, ok := loaded.get(testBlobB, "rust"); !ok || !info.binary {
+		t.Errorf("get(B) = %+v, %v, want binary hit", info, ok)
+	}
+}
+
+func TestBlobCache_LanguageMustMatch(t *testing.T) {
+	cache := newBlobCache("")
+	cache.put(testBlobA, blobInfo{lines: 10})
+
+	if _, ok := cache.get(testBlobA, ""); !ok {
+		t.Error("entry without language-specific counts should hit when none are needed")
+	}
+	if _, ok := cache.get(testBlobA, "rust"); ok {
+		t.Error("entry without language-specific counts should miss when rust counts are needed")
+	}
+
+	cache.put(testBlobA, blobInfo{lines: 10, inlineTestLines: 3, lang: "rust"})
+	cache.put(testBlobA, blobInfo{lines: 10})
+	if info, ok := cache.get(testBlobA, "rust"); !ok || info.inlineTestLines != 3 {
+		t.Errorf("rust counts were overwritten: %+v, %v", info, ok)
+	}
+}
+
+func TestBlobCache_TooLargeEntriesNeedTheSameBuffer(t *testing.T) {
+	defer func(size int) { maxBufferedBlob = size }(maxBufferedBlob)
+	maxBufferedBlob = 1000
+	path := filepath.Join(t.TempDir(), "blob-cache")
+	cache := newBlobCache(path)
+	cache.put(testBlobA, blobInfo{lines: 50, lang: "rust", tooLarge: 2000})
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newBlobCache(path)
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := loaded.get(testBlobA, "rust"); !ok || info.tooLarge != 2000 {
+		t.Errorf("get() = %+v, %v, want a hit while the blob doesn't fit", info, ok)
+	}
+	maxBufferedBlob = 2000
+	if _, ok := loaded.get(testBlobA, "rust"); ok {
+		t.Error("get() hit once the blob fits, want a miss so its inline tests get counted")
+	}
+	if _, ok := loaded.getAnyLanguage(testBlobA); !ok {
+		t.Error("getAnyLanguage() missed, but the line count still holds")
+	}
+}
+
+func TestBlobCache_IgnoresOtherVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blob-cache")
+	if err := os.WriteFile(path, []byte(blobCacheMagic+"\x00garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := newBlobCache(path)
+	if err := cache.load(); err != nil {
+		t.Fatalf("load() should ignore old versions, got %v", err)
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("loaded %d entries from an old version", len(cache.entries))
+	}
+}
+
+func TestBlobCache_SavesDiffs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blob-cache")
+	cache := newBlobCache(path)
+	pair := blobPair{from: testBlobA, to: testBlobB}
+	cache.putDiff(pair, lineDiff{added: 7, removed: 3})
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newBlobCache(path)
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if diff, ok := loaded.getDiff(pair); !ok || diff != (lineDiff{added: 7, removed: 3}) {
+		t.Errorf("getDiff() = %+v, %v", diff, ok)
+	}
+	if _, ok := loaded.getDiff(blobPair{from: testBlobB, to: testBlobA}); ok {
+		t.Error("getDiff() should not match the reversed pair")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"io"
+	"sync"
+)
+
+// binarySniffLen is how far into a blob we look for a NUL byte to decide it's binary.
+const binarySniffLen = 8000
+
+// defaultMaxBufferedBlob is the default for maxBufferedBlob, in bytes.
+const defaultMaxBufferedBlob = 16 << 20
+
+// maxBufferedBlob is the largest blob we keep in memory for inline test counting. Larger blobs still get
+// their lines counted, but their inline tests aren't. Each cat-file worker holds at most one buffered blob
+// at a time, so this bounds peak memory to about workers × maxBufferedBlob. Set from --max-blob-buffer.
+var maxBufferedBlob = defaultMaxBufferedBlob
+
+// blobScanner counts and classifies the lines of a blob while streaming it through a reused chunk buffer.
+// Content is only kept when asked for. Reused through blobScanners so each worker doesn't allocate per blob.
+type blobScanner struct {
+	chunk      []byte
+	content    bytes.Buffer
+	header     []byte // The start of the blob, to look for generated markers in
+	classifier lineClassifier
+}
+
+var blobScanners = sync.Pool{
+	New: func() any { return &blobScanner{chunk: make([]byte, 64*1024)} },
+}
+
+// blobScan is the result of scanning one blob.
+type blobScan struct {
+	lines     int
+	comments  int
+	blanks    int
+	binary    bool
+	generated generatedReason // Why the blob looks generated, if it does
+	// buffered is set if content holds the full blob: it was asked for, it's not binary, and it fits in
+	// maxBuffered. content is only valid until the scanner is reused.
+	buffered bool
+	content  []byte
+	// tooLarge is set if content was asked for but the blob didn't fit.
+	tooLarge bool
+}
+
+// scan reads r to the end, counting lines, classifying them with syntax, and looking for NUL bytes and
+// generated markers near the start. If keep is set and the blob is at most maxBuffered bytes, its content is
+// kept too. Stops early once the blob looks binary.
+func (s *blobScanner) scan(r io.Reader, size, maxBuffered int, keep bool, syntax *commentSyntax) (blobScan, error) {
+	var result blobScan
+	s.content.Reset()
+	s.header = s.header[:0]
+	s.classifier.reset(syntax)
+	if keep && size > maxBuffered {
+		keep = false
+		result.tooLarge = true
+	}
+	if keep {
+		s.content.Grow(size)
+	}
+
+	read := 0
+	var last byte
+	for {
+		n, err := r.Read(s.chunk)
+		if n > 0 {
+			data := s.chunk[:n]
+			if read < binarySniffLen && bytes.IndexByte(data[:min(n, binarySniffLen-read)], 0) >= 0 {
+				s.content.Reset()
+				return blobScan{binary: true}, nil
+			}
+			result.lines += bytes.Count(data, []byte("\n"))
+			s.classifier.write(data)
+			if len(s.header) < generatedHeaderLen {
+				s.header = append(s.header, data[:min(n, generatedHeaderLen-len(s.header))]...)
+			}
+			if keep {
+				s.content.Write(data)
+			}
+			read += n
+			last = data[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return blobScan{}, err
+		}
+	}
+
+	// A last line without a trailing newline still counts
+	if read > 0 && last != '\n' {
+		result.lines++
+	}
+	result.comments, result.blanks = s.classifier.finish()
+	result.generated = detectGenerated(s.header, read, result.lines)
+	if keep {
+		result.buffered = true
+		result.content = s.content.Bytes()
+	}
+	return result, nil
+}
+
+// blobRequest asks for the counts of one blob, as a file in lang. If lang has an inline test counter, it runs
+// on the content. A nil lang counts it like a file of an unknown language.
+type blobRequest struct {
+	blob string
+	lang *languageDefinition
+}
+
+// blobKey identifies the counts of a blob as a language, by blobLanguageID. Files of different languages can
+// have the same content, and its comments and inline tests count differently in each.
+type blobKey struct {
+	blob string
+	lang string
+}
+
+// readBlobs streams the requested blobs from repo and counts them, without holding more than one blob per
+// worker in memory. A blob requested as more than one language is read once for each. Returns results keyed
+// by blob and language. Missing blobs are left out.
+func readBlobs(repo repository, requests []blobRequest) (map[blobKey]blobInfo, error) {
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	var langs []*languageDefinition
+	var names []string
+	seen := make(map[blobKey]bool, len(requests))
+	for _, req := range requests {
+		lang := cmp.Or(req.lang, otherLanguage)
+		if key := (blobKey{req.blob, blobLanguageID(lang)}); !seen[key] {
+			seen[key] = true
+			langs = append(langs, lang)
+			names = append(names, req.blob)
+		}
+	}
+
+	results := make(map[blobKey]blobInfo, len(names))
+	scanner := blobScanners.Get().(*blobScanner)
+	defer blobScanners.Put(scanner)
+
+	// The handler runs on a single worker goroutine, one object at a time
+	_, err := repo.stream(names, func(i int, obj gitObject, content io.Reader) {
+		if obj.typ != "blob" {
+			return
+		}
+		lang := langs[i]
+		inline := lang.countInlineTestLines != nil
+		scan, err := scanner.scan(content, obj.size, maxBufferedBlob, inline, lang.comments)
+		if err != nil {
+			return // The pool sees the same read error, and retries or fails the request
+		}
+
+		info := blobInfo{
+			lines:     scan.lines,
+			comments:  scan.comments,
+			blanks:    scan.blanks,
+			lang:      blobLanguageID(lang),
+			binary:    scan.binary,
+			generated: scan.generated,
+		}
+		if scan.buffered {
+			info.inlineTestLines = lang.countInlineTestLines(scan.content)
+		}
+		if scan.tooLarge {
+			info.tooLarge = obj.size
+		}
+		results[blobKey{obj.hash, info.lang}] = info
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
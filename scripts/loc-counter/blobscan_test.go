@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlobScanner(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		maxBuffered int
+		keep        bool
+		want        blobScan
+	}{
+		{"empty", "", 100, false, blobScan{}},
+		{"trailing newline", "a\nb\n", 100, false, blobScan{lines: 2}},
+		{"no trailing newline", "a\nb", 100, false, blobScan{lines: 2}},
+		{"spans chunks", strings.Repeat("line\n", 10), 100, false, blobScan{lines: 10}},
+		{"binary", "text\x00more\n", 100, false, blobScan{binary: true}},
+		{"NUL after sniff window", strings.Repeat("x", binarySniffLen) + "\x00\n", 100, false, blobScan{lines: 1}},
+		{"kept", "a\nb\n", 100, true, blobScan{lines: 2, buffered: true}},
+		{"kept empty", "", 100, true, blobScan{buffered: true}},
+		{"too large to keep", "a\nb\n", 3, true, blobScan{lines: 2, tooLarge: true}},
+	}
+
+	// A tiny chunk makes every case cross chunk boundaries
+	scanner := &blobScanner{chunk: make([]byte, 3)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := scanner.scan(strings.NewReader(tt.content), len(tt.content), tt.maxBuffered, tt.keep, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.buffered && string(got.content) != tt.content {
+				t.Errorf("content = %q, want %q", got.content, tt.content)
+			}
+			if got.lines != tt.want.lines || got.binary != tt.want.binary || got.buffered != tt.want.buffered ||
+				got.tooLarge != tt.want.tooLarge {
+				t.Errorf("scan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
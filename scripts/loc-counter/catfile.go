@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gitObject is one object read through git cat-file.
+type gitObject struct {
+	hash    string
+	typ     string // "blob", "tree", "commit", or "tag"
+	size    int
+	data    []byte // Nil for --batch-check lookups
+	missing bool   // The name didn't resolve to an object
+}
+
+// catFileProcess is one running `git cat-file --batch` or `--batch-check` process.
+type catFileProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	mode   string
+}
+
+func startCatFile(root, mode string) (*catFileProcess, error) {
+	cmd := exec.Command("git", "-C", root, "cat-file", mode)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start cat-file: %w", err)
+	}
+	return &catFileProcess{cmd: cmd, stdin: stdin, stdout: bufio.NewReaderSize(stdout, 256*1024), mode: mode}, nil
+}
+
+// query sends all names and reads one response per name, in order. If handle is set, object content is
+// streamed to it instead of being buffered in gitObject.data. handle must not keep the reader.
+func (p *catFileProcess) query(names []string, handle streamHandler) ([]gitObject, error) {
+	// Write from a goroutine so a large request can't deadlock against a full stdout pipe
+	writeErr := make(chan error, 1)
+	go func() {
+		w := bufio.NewWriter(p.stdin)
+		for _, name := range names {
+			if _, err := w.WriteString(name + "\n"); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- w.Flush()
+	}()
+
+	objects := make([]gitObject, len(names))
+	for i := range names {
+		obj, err := p.readResponse(i, handle)
+		if err != nil {
+			return nil, err
+		}
+		objects[i] = obj
+	}
+	if err := <-writeErr; err != nil {
+		return nil, fmt.Errorf("failed to write to cat-file: %w", err)
+	}
+	return objects, nil
+}
+
+// readResponse reads "<hash> <type> <size>\n" (followed by "<content>\n" in --batch mode),
+// or "<name> missing\n".
+func (p *catFileProcess) readResponse(i int, handle streamHandler) (gitObject, error) {
+	header, err := p.stdout.ReadString('\n')
+	if err != nil {
+		return gitObject{}, fmt.Errorf("failed to read from cat-file: %w", err)
+	}
+	header = strings.TrimSuffix(header, "\n")
+
+	if strings.HasSuffix(header, " missing") || strings.HasSuffix(header, " ambiguous") {
+		return gitObject{hash: header[:strings.LastIndexByte(header, ' ')], missing: true}, nil
+	}
+
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return gitObject{}, fmt.Errorf("unexpected cat-file header: %q", header)
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return gitObject{}, fmt.Errorf("unexpected cat-file header: %q", header)
+	}
+	obj := gitObject{hash: fields[0], typ: fields[1], size: size}
+
+	if p.mode != "--batch" {
+		return obj, nil
+	}
+
+	if handle == nil {
+		buf := make([]byte, size+1) // +1 for the trailing LF
+		if _, err := io.ReadFull(p.stdout, buf); err != nil {
+			return gitObject{}, fmt.Errorf("failed to read object %s: %w", obj.hash, err)
+		}
+		obj.data = buf[:size]
+		return obj, nil
+	}
+
+	content := &io.LimitedReader{R: p.stdout, N: int64(size)}
+	handle(i, obj, content)
+	// Skip whatever the handler didn't read, plus the trailing LF
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		return gitObject{}, fmt.Errorf("failed to read object %s: %w", obj.hash, err)
+	}
+	if content.N > 0 {
+		return gitObject{}, fmt.Errorf("failed to read object %s: %w", obj.hash, io.ErrUnexpectedEOF)
+	}
+	if _, err := p.stdout.Discard(1); err != nil {
+		return gitObject{}, fmt.Errorf("failed to read object %s: %w", obj.hash, err)
+	}
+	return obj, nil
+}
+
+// close asks the process to exit by closing its stdin, and waits for it.
+func (p *catFileProcess) close() {
+	p.stdin.Close()
+	_ = p.cmd.Wait()
+}
+
+// kill stops a process that crashed or got out of sync.
+func (p *catFileProcess) kill() {
+	_ = p.cmd.Process.Kill()
+	p.stdin.Close()
+	_ = p.cmd.Wait()
+}
+
+// streamHandler receives the content of the i-th requested object as it's read. It isn't called for
+// missing objects. The reader is only valid during the call.
+type streamHandler func(i int, obj gitObject, content io.Reader)
+
+type catFileRequest struct {
+	names  []string
+	handle streamHandler
+	reply  chan catFileReply
+}
+
+type catFileReply struct {
+	objects []gitObject
+	err     error
+}
+
+// catFilePool keeps long-lived cat-file processes around, so reading objects doesn't pay for a process
+// start each time. Requests go over channels to worker goroutines that each own one process. Workers
+// start their process on first use, and replace it if it crashes. Safe for concurrent use.
+type catFilePool struct {
+	root      string
+	batch     chan catFileRequest
+	check     chan catFileRequest
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// newCatFilePool starts size --batch workers and one --batch-check worker for the repo at root.
+func newCatFilePool(root string, size int) *catFilePool {
+	p := &catFilePool{
+		root:  root,
+		batch: make(chan catFileRequest),
+		check: make(chan catFileRequest),
+	}
+	for range max(size, 1) {
+		p.wg.Add(1)
+		go p.worker("--batch", p.batch)
+	}
+	p.wg.Add(1)
+	go p.worker("--batch-check", p.check)
+	return p
+}
+
+// read returns the type, size, and content of each named object, in order.
+func (p *catFilePool) read(names []string) ([]gitObject, error) {
+	return p.do(p.batch, names, nil)
+}
+
+// stream reads each named object and passes its content to handle without buffering it. Returns the
+// objects (without data), in order. handle runs on a worker goroutine, and may be called more than once
+// for the same object if the request has to be retried.
+func (p *catFilePool) stream(names []string, handle streamHandler) ([]gitObject, error) {
+	return p.do(p.batch, names, handle)
+}
+
+// stat returns the type and size of each named object, in order, without reading content.
+// Names can be any rev expression, like "v1.0^{commit}".
+func (p *catFilePool) stat(names []string) ([]gitObject, error) {
+	return p.do(p.check, names, nil)
+}
+
+func (p *catFilePool) do(requests chan<- catFileRequest, names []string, handle streamHandler) ([]gitObject, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	for _, name := range names {
+		if strings.ContainsAny(name, "\n") {
+			return nil, errors.New("object name must not contain newlines")
+		}
+	}
+	reply := make(chan catFileReply, 1)
+	requests <- catFileRequest{names: names, handle: handle, reply: reply}
+	r := <-reply
+	return r.objects, r.err
+}
+
+func (p *catFilePool) worker(mode string, requests <-chan catFileRequest) {
+	defer p.wg.Done()
+	var proc *catFileProcess
+	defer func() {
+		if proc != nil {
+			proc.close()
+		}
+	}()
+
+	for req := range requests {
+		var objects []gitObject
+		var err error
+		// If the process died or got out of sync, start a fresh one and retry once
+		for range 2 {
+			if proc == nil {
+				if proc, err = startCatFile(p.root, mode); err != nil {
+					break
+				}
+			}
+			if objects, err = proc.query(req.names, req.handle); err == nil {
+				break
+			}
+			proc.kill()
+			proc = nil
+		}
+		req.reply <- catFileReply{objects: objects, err: err}
+	}
+}
+
+// close stops all workers and their processes. The pool must not be used afterwards.
+func (p *catFilePool) close() {
+	p.closeOnce.Do(func() {
+		close(p.batch)
+		close(p.check)
+		p.wg.Wait()
+	})
+}
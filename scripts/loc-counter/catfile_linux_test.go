@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestCatFilePool_RestartsCrashedProcess(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("a.txt", "hello\n")
+	repo.commit("2024-01-01T10:00:00Z", "First")
+	blob := strings.TrimSpace(repo.git("rev-parse", "HEAD:a.txt"))
+
+	pool := newCatFilePool(repo.dir, 1)
+	defer pool.close()
+
+	if _, err := pool.read([]string{blob}); err != nil {
+		t.Fatal(err)
+	}
+	if killed := killChildCatFiles(t); killed == 0 {
+		t.Fatal("found no cat-file process to kill")
+	}
+
+	objs, err := pool.read([]string{blob})
+	if err != nil {
+		t.Fatalf("read() after crash: %v", err)
+	}
+	if string(objs[0].data) != "hello\n" {
+		t.Errorf("read() after crash = %q", objs[0].data)
+	}
+}
+
+// killChildCatFiles kills all git cat-file processes started by this test process.
+func killChildCatFiles(t *testing.T) int {
+	t.Helper()
+	statFiles, _ := filepath.Glob("/proc/[0-9]*/stat")
+	killed := 0
+	for _, statFile := range statFiles {
+		stat, err := os.ReadFile(statFile)
+		if err != nil {
+			continue
+		}
+		// Format: "<pid> (<comm>) <state> <ppid> ..."; comm may contain spaces, so split after the last ')'
+		rest := string(stat[strings.LastIndexByte(string(stat), ')')+2:])
+		fields := strings.Fields(rest)
+		if len(fields) < 2 || fields[1] != strconv.Itoa(os.Getpid()) {
+			continue
+		}
+		cmdline, _ := os.ReadFile(filepath.Join(filepath.Dir(statFile), "cmdline"))
+		if !strings.Contains(string(cmdline), "cat-file") {
+			continue
+		}
+		pid, _ := strconv.Atoi(filepath.Base(filepath.Dir(statFile)))
+		if syscall.Kill(pid, syscall.SIGKILL) == nil {
+			killed++
+		}
+	}
+	return killed
+}
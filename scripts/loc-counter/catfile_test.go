@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCatFilePool(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("a.txt", "hello\n")
+	repo.commit("2024-01-01T10:00:00Z", "First")
+	blob := strings.TrimSpace(repo.git("rev-parse", "HEAD:a.txt"))
+
+	pool := newCatFilePool(repo.dir, 2)
+	defer pool.close()
+
+	objs, err := pool.read([]string{blob, "0000000000000000000000000000000000000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objs[0].typ != "blob" || string(objs[0].data) != "hello\n" {
+		t.Errorf("read(blob) = %+v", objs[0])
+	}
+	if !objs[1].missing {
+		t.Errorf("read(unknown) = %+v, want missing", objs[1])
+	}
+
+	objs, err = pool.stat([]string{"HEAD^{tree}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objs[0].typ != "tree" || objs[0].data != nil {
+		t.Errorf("stat(HEAD^{tree}) = %+v, want tree without data", objs[0])
+	}
+}
+
+func TestCatFilePool_Stream(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("a.txt", "hello\n")
+	repo.write("b.txt", "world\n")
+	repo.commit("2024-01-01T10:00:00Z", "First")
+	blobA := strings.TrimSpace(repo.git("rev-parse", "HEAD:a.txt"))
+	blobB := strings.TrimSpace(repo.git("rev-parse", "HEAD:b.txt"))
+
+	pool := newCatFilePool(repo.dir, 1)
+	defer pool.close()
+
+	// Read only part of the first blob, so the pool has to skip the rest to stay in sync
+	got := make(map[string]string)
+	_, err := pool.stream([]string{blobA, blobB}, func(_ int, obj gitObject, content io.Reader) {
+		buf := make([]byte, 3)
+		n, _ := io.ReadFull(content, buf)
+		got[obj.hash] = string(buf[:n])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[blobA] != "hel" || got[blobB] != "wor" {
+		t.Errorf("stream() read %q", got)
+	}
+
+	objs, err := pool.read([]string{blobB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(objs[0].data) != "world\n" {
+		t.Errorf("read() after stream = %q", objs[0].data)
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkpointVersion is bumped whenever the checkpoint format changes, so old checkpoints are ignored.
+const checkpointVersion = 7
+
+// checkpointInterval is how often progress is written to disk during a run.
+const checkpointInterval = 30 * time.Second
+
+// checkpoint holds the progress of one analysis: the stats of each counted day, and snapshots of the
+// workers' file maps. An interrupted run can resume from it, skipping the days it already counted and
+// diffing from a snapshot instead of reading a full tree. Safe for concurrent use.
+type checkpoint struct {
+	path string // Where to persist the checkpoint; empty to keep it in memory only
+	key  string // What was analyzed, and with which settings. Checkpoints with another key are ignored.
+
+	mu        sync.Mutex
+	days      map[string]savedDay
+	loaded    []*savedState       // File maps from the previous run
+	snapshots map[int]*savedState // Latest file map per worker of this run
+	workers   int
+
+	// generation is bumped to ask workers for fresh snapshots
+	generation atomic.Int64
+}
+
+// savedDay is the stats of a day, and the commit they were counted at.
+type savedDay struct {
+	commit string
+	stats  *fileStats
+}
+
+// savedState is a copy of a worker's file map at a commit.
+type savedState struct {
+	date       string
+	commit     string
+	files      map[string]fileState
+	attributes map[string]string // Blob of each .gitattributes file, keyed by its directory
+}
+
+func newCheckpoint(path, key string) *checkpoint {
+	return &checkpoint{path: path, key: key, days: make(map[string]savedDay), snapshots: make(map[int]*savedState)}
+}
+
+// checkpointKey describes what's being analyzed and every setting that changes the counts, so a checkpoint
+// is only resumed by a run that would produce the same results.
+func checkpointKey(spec refSpec, scope *pathScope, groups *breakdown, config *repoConfig, g granularity,
+	history historyOptions) string {
+	return fmt.Sprintf("rev=%s\nblob-cache=%d\nmax-blob-buffer=%d\nscope=%s\nbreakdown=%s\nconfig=%s\ngranularity=%s\nhistory=%s",
+		spec.rev, blobCacheVersion, maxBufferedBlob, scope, groups, config, g, history)
+}
+
+// defaultCheckpointPath returns where the checkpoint for key lives in repo.
+func defaultCheckpointPath(repo repository, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(gitstrataDir(repo), "checkpoints", hex.EncodeToString(sum[:8]))
+}
+
+// doneDay returns the saved stats for date if it was counted at commit.
+func (c *checkpoint) doneDay(date, commit string) (*fileStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	day, ok := c.days[date]
+	if !ok || day.commit != commit {
+		return nil, false
+	}
+	return day.stats, true
+}
+
+// doneDays returns the number of saved days.
+func (c *checkpoint) doneDays() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.days)
+}
+
+func (c *checkpoint) recordDay(date, commit string, stats *fileStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.days[date] = savedDay{commit: commit, stats: stats}
+}
+
+// base fills the empty state with a saved file map to diff from when counting date: the latest one from
+// before it, or the earliest one after it. Returns the commit of that file map, or empty if there are none.
+func (c *checkpoint) base(date string, state *treeState) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var best *savedState
+	better := func(s *savedState) bool {
+		switch {
+		case best == nil:
+			return true
+		case s.date <= date:
+			return best.date > date || s.date > best.date
+		default:
+			return best.date > date && s.date < best.date
+		}
+	}
+	for _, s := range c.loaded {
+		if better(s) {
+			best = s
+		}
+	}
+	for _, s := range c.snapshots {
+		if better(s) {
+			best = s
+		}
+	}
+	if best == nil {
+		return ""
+	}
+
+	for path, f := range best.files {
+		state.set(path, f)
+	}
+	maps.Copy(state.attributeBlobs, best.attributes)
+	return best.commit
+}
+
+// requestSnapshots asks all workers to snapshot their file maps after their current day.
+func (c *checkpoint) requestSnapshots() {
+	c.generation.Add(1)
+}
+
+// maybeSnapshot saves a copy of a worker's file map if a snapshot was requested since the worker's last
+// one. seen tracks the last generation the worker snapshotted.
+func (c *checkpoint) maybeSnapshot(worker int, seen *int64, date, commit string, state *treeState) {
+	gen := c.generation.Load()
+	if gen == *seen {
+		return
+	}
+	*seen = gen
+	c.snapshot(worker, date, commit, state)
+}
+
+func (c *checkpoint) snapshot(worker int, date, commit string, state *treeState) {
+	files := make(map[string]fileState, len(state.files))
+	for path, f := range state.files {
+		files[path] = f
+	}
+	attributes := maps.Clone(state.attributeBlobs)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.snapshots[worker] = &savedState{date: date, commit: commit, files: files, attributes: attributes}
+}
+
+// The on-disk format, encoded with gob. Languages are stored by id.
+type checkpointData struct {
+	Version int
+	Key     string
+	Days    []checkpointDay
+	States  []checkpointState
+}
+
+type checkpointDay struct {
+	Date      string
+	Commit    string
+	Total     int
+	Languages map[string][6]int // Total, prod, test, code, comment, blank
+	Comments  []string
+	Authors   []string
+	Added     map[string]int
+	Removed   map[string]int
+
+	ContributorAdded   map[string]int
+	ContributorRemoved map[string]int
+
+	Groups map[string]map[string][6]int // Languages per group, with a breakdown
+}
+
+type checkpointState struct {
+	Date       string
+	Commit     string
+	Files      []checkpointFile
+	Attributes map[string]string
+}
+
+type checkpointFile struct {
+	Path      string
+	Blob      string
+	Lang      string
+	Lines     int
+	TestLines int
+	Comments  int
+	Blanks    int
+}
+
+// load reads the checkpoint file if there is one. Missing files, and files from other versions or for
+// other keys, are silently ignored.
+func (c *checkpoint) load() error {
+	if c.path == "" {
+		return nil
+	}
+	file, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+	defer file.Close()
+
+	// Check the version first, since older formats may not decode into this one
+	var header struct {
+		Version int
+		Key     string
+	}
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&header); err != nil {
+		return fmt.Errorf("corrupt checkpoint %s: %w", c.path, err)
+	}
+	if header.Version != checkpointVersion || header.Key != c.key {
+		return nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var data checkpointData
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&data); err != nil {
+		return fmt.Errorf("corrupt checkpoint %s: %w", c.path, err)
+	}
+
+	days := make(map[string]savedDay, len(data.Days))
+	for _, d := range data.Days {
+		stats := newFileStats(d.Comments)
+		stats.total = d.Total
+		stats.authors = d.Authors
+		stats.added, stats.removed = d.Added, d.Removed
+		stats.contributorAdded, stats.contributorRemoved = d.ContributorAdded, d.ContributorRemoved
+		stats.languages = decodeLanguages(d.Languages)
+		if d.Groups != nil {
+			stats.groups = make(map[string]*fileStats, len(d.Groups))
+			for name, languages := range d.Groups {
+				group := newFileStats(nil)
+				group.languages = decodeLanguages(languages)
+				for _, count := range group.languages {
+					group.total += count.total
+				}
+				stats.groups[name] = group
+			}
+		}
+		days[d.Date] = savedDay{commit: d.Commit, stats: stats}
+	}
+	var loaded []*savedState
+	for _, s := range data.States {
+		files := make(map[string]fileState, len(s.Files))
+		for _, f := range s.Files {
+			lang := languageByID(f.Lang)
+			if lang == nil {
+				return fmt.Errorf("corrupt checkpoint %s: unknown language %q", c.path, f.Lang)
+			}
+			files[f.Path] = fileState{
+				blob:      f.Blob,
+				lang:      lang,
+				lines:     f.Lines,
+				testLines: f.TestLines,
+				comments:  f.Comments,
+				blanks:    f.Blanks,
+			}
+		}
+		loaded = append(loaded, &savedState{date: s.Date, commit: s.Commit, files: files, attributes: s.Attributes})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.days = days
+	c.loaded = loaded
+	return nil
+}
+
+// save writes the checkpoint to disk. Writes to a temp file first so an interrupted save never leaves a
+// truncated checkpoint behind.
+func (c *checkpoint) save() error {
+	if c.path == "" {
+		return nil
+	}
+	data := c.encode()
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create checkpoint dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), "checkpoint-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	w := bufio.NewWriter(tmp)
+	if err := gob.NewEncoder(w).Encode(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to replace checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (c *checkpoint) encode() checkpointData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data := checkpointData{Version: checkpointVersion, Key: c.key}
+	for date, day := range c.days {
+		var groups map[string]map[string][6]int
+		if day.stats.groups != nil {
+			groups = make(map[string]map[string][6]int, len(day.stats.groups))
+			for name, group := range day.stats.groups {
+				groups[name] = encodeLanguages(group.languages)
+			}
+		}
+		data.Days = append(data.Days, checkpointDay{
+			Date:      date,
+			Commit:    day.commit,
+			Total:     day.stats.total,
+			Languages: encodeLanguages(day.stats.languages),
+			Groups:    groups,
+			Comments:  day.stats.comments,
+			Authors:   day.stats.authors,
+			Added:     day.stats.added,
+			Removed:   day.stats.removed,
+
+			ContributorAdded:   day.stats.contributorAdded,
+			ContributorRemoved: day.stats.contributorRemoved,
+		})
+	}
+
+	// Keep the previous run's file maps until every worker of this run has one to replace them
+	states := make([]*savedState, 0, len(c.snapshots)+len(c.loaded))
+	for _, s := range c.snapshots {
+		states = append(states, s)
+	}
+	if len(c.snapshots) < c.workers {
+		states = append(states, c.loaded...)
+	}
+	for _, s := range states {
+		files := make([]checkpointFile, 0, len(s.files))
+		for path, f := range s.files {
+			files = append(files, checkpointFile{
+				Path:      path,
+				Blob:      f.blob,
+				Lang:      f.lang.id,
+				Lines:     f.lines,
+				TestLines: f.testLines,
+				Comments:  f.comments,
+				Blanks:    f.blanks,
+			})
+		}
+		data.States = append(data.States, checkpointState{Date: s.date, Commit: s.commit, Files: files, Attributes: s.attributes})
+	}
+	return data
+}
+
+func encodeLanguages(languages map[string]*languageCount) map[string][6]int {
+	encoded := make(map[string][6]int, len(languages))
+	for id, n := range languages {
+		encoded[id] = [6]int{n.total, n.prod, n.test, n.code, n.comment, n.blank}
+	}
+	return encoded
+}
+
+func decodeLanguages(encoded map[string][6]int) map[string]*languageCount {
+	languages := make(map[string]*languageCount, len(encoded))
+	for id, n := range encoded {
+		languages[id] = &languageCount{total: n[0], prod: n[1], test: n[2], code: n[3], comment: n[4], blank: n[5]}
+	}
+	return languages
+}
+
+// remove deletes the checkpoint file, once the analysis it was for has finished.
+func (c *checkpoint) remove() error {
+	if c.path == "" {
+		return nil
+	}
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint: %w", err)
+	}
+	return nil
+}
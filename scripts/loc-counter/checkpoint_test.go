@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProcessDays_ResumesFromCheckpoint(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("main.go", "package main\n")
+	repo.commit("2024-01-01T10:00:00Z", "Add main")
+	repo.write("lib.go", "package main\n\nfunc lib() {}\n")
+	repo.commit("2024-01-02T10:00:00Z", "Add lib")
+	repo.write("lib_test.go", "package main\n")
+	repo.commit("2024-01-03T10:00:00Z", "Add test")
+	repo.git("rm", "-q", "main.go")
+	repo.commit("2024-01-04T10:00:00Z", "Remove main")
+
+	r := repo.open(backendExec)
+	commits, err := getCommits(r, "HEAD", nil, historyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	daily, dates := groupCommits(commits, granularityDay)
+
+	ctx := context.Background()
+	want, err := processDays(ctx, r, dates, daily, pipelineOptions{workers: 1}, newBlobCache(""), newCheckpoint("", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Count the first two days, as if the run was interrupted there
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	cp := newCheckpoint(path, "test")
+	if _, err := processDays(ctx, r, dates[:2], daily, pipelineOptions{workers: 1}, newBlobCache(""), cp); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := newCheckpoint(path, "test")
+	if err := resumed.load(); err != nil {
+		t.Fatal(err)
+	}
+	if resumed.doneDays() != 2 || len(resumed.loaded) != 1 {
+		t.Fatalf("loaded %d days and %d file maps, want 2 and 1", resumed.doneDays(), len(resumed.loaded))
+	}
+	got, err := processDays(ctx, r, dates, daily, pipelineOptions{workers: 1}, newBlobCache(""), resumed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, date := range dates {
+		if got[date].total != want[date].total || !reflect.DeepEqual(dump(got[date]), dump(want[date])) ||
+			!reflect.DeepEqual(got[date].comments, want[date].comments) {
+			t.Errorf("%s: resumed = %v %v, fresh = %v %v", date, dump(got[date]), got[date].comments, dump(want[date]), want[date].comments)
+		}
+	}
+}
+
+func TestCheckpoint_IgnoresOtherKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	cp := newCheckpoint(path, "rev=main")
+	cp.recordDay("2024-01-01", testBlobA, newFileStats([]string{"First"}))
+	if err := cp.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	other := newCheckpoint(path, "rev=dev")
+	if err := other.load(); err != nil {
+		t.Fatal(err)
+	}
+	if other.doneDays() != 0 {
+		t.Errorf("loaded %d days from a checkpoint for another ref", other.doneDays())
+	}
+}
+
+func TestCheckpoint_IgnoresOlderFormats(t *testing.T) {
+	// Version 5 stored three counts per language, which doesn't decode into the current format
+	type oldDay struct {
+		Date      string
+		Languages map[string][3]int
+	}
+	old := struct {
+		Version int
+		Key     string
+		Days    []oldDay
+	}{5, "rev=main", []oldDay{{"2024-01-01", map[string][3]int{"go": {3, 3, 0}}}}}
+
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewEncoder(file).Encode(old); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	cp := newCheckpoint(path, "rev=main")
+	if err := cp.load(); err != nil {
+		t.Fatalf("load() error: %v", err)
+	}
+	if cp.doneDays() != 0 {
+		t.Errorf("loaded %d days from an older checkpoint", cp.doneDays())
+	}
+}
+
+func TestProcessDays_StopsWhenCanceled(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("main.go", "package main\n")
+	repo.commit("2024-01-01T10:00:00Z", "Add main")
+	r := repo.open(backendExec)
+	commits, err := getCommits(r, "HEAD", nil, historyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cp := newCheckpoint("", "test")
+	daily, dates := groupCommits(commits, granularityDay)
+	if _, err := processDays(ctx, r, dates, daily, pipelineOptions{workers: 1}, newBlobCache(""), cp); err == nil {
+		t.Error("processDays() should fail when canceled")
+	}
+	if cp.doneDays() != 0 {
+		t.Errorf("counted %d days after being canceled", cp.doneDays())
+	}
+}
+
+// cancelAfter is a context that reads as canceled once Err has been called n times, to stop a run partway.
+type cancelAfter struct {
+	context.Context
+	n atomic.Int64
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n.Add(-1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestProcessDays_ReturnsDaysCountedBeforeInterrupt(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("main.go", "package main\n")
+	repo.commit("2024-01-01T10:00:00Z", "Add main")
+	repo.write("lib.go", "package main\n\nfunc lib() {}\n")
+	repo.commit("2024-01-02T10:00:00Z", "Add lib")
+	repo.write("README.md", "# Hi\n")
+	repo.commit("2024-01-03T10:00:00Z", "Add readme")
+	r := repo.open(backendExec)
+	commits, err := getCommits(r, "HEAD", nil, historyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	daily, dates := groupCommits(commits, granularityDay)
+
+	// The worker checks before each day, so the third check stops it after two days
+	ctx := &cancelAfter{Context: context.Background()}
+	ctx.n.Store(2)
+	got, err := processDays(ctx, r, dates, daily, pipelineOptions{workers: 1}, newBlobCache(""), newCheckpoint("", "test"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("processDays() error = %v, want it canceled", err)
+	}
+	if len(got) != 2 || got["2024-01-01"] == nil || got["2024-01-02"] == nil {
+		t.Errorf("processDays() returned %v, want the first two days", got)
+	}
+}
+
+func TestAnalyze_KeepsDaysCountedBeforeInterrupt(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("main.go", "package main\n")
+	repo.commit("2024-01-01T10:00:00Z", "Add main")
+	repo.write("lib.go", "package main\n\nfunc lib() {}\n")
+	repo.commit("2024-01-03T10:00:00Z", "Add lib")
+	second := strings.TrimSpace(repo.git("rev-parse", "HEAD"))
+	repo.write("README.md", "# Hi\n")
+	repo.commit("2024-01-04T10:00:00Z", "Add readme")
+
+	ctx := &cancelAfter{Context: context.Background()}
+	ctx.n.Store(2)
+	result, err := analyze(ctx, &cliFlags{repoPath: repo.dir, ref: "main", workers: 1, noResume: true}, pipelineOptions{workers: 1})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("analyze() error = %v, want it canceled", err)
+	}
+	if result == nil {
+		t.Fatal("analyze() returned no result, want the days counted before the interrupt")
+	}
+	if want := []string{"2024-01-01", "2024-01-02", "2024-01-03"}; !reflect.DeepEqual(result.dates, want) {
+		t.Errorf("dates = %v, want %v", result.dates, want)
+	}
+	if got := result.days[len(result.days)-1].total; got != 4 {
+		t.Errorf("last day's total = %d, want 4", got)
+	}
+	if result.meta.headCommit != second {
+		t.Errorf("headCommit = %s, want the last counted commit %s", result.meta.headCommit, second)
+	}
+}
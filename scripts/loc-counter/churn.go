@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// countChurn counts the lines added and removed per language between two commits, from a line diff of
+// each changed file, so a day that rewrites 5k lines doesn't look like a quiet one. An empty fromHash
+// means an empty tree. rules are the rules of the tree at toHash. Binary files, content that looks generated,
+// and files that don't count by the rules, count as empty. Each side is checked on its own, so a file that
+// becomes generated counts as removed, and one that stops being generated counts as added.
+func countChurn(repo repository, rules fileRules, fromHash, toHash string, cache *blobCache) (added, removed map[string]int, err error) {
+	changes, err := getChangedFiles(repo, rules.scope, fromHash, toHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type churnFile struct {
+		lang     *languageDefinition
+		path     string
+		from, to string // The blobs on each side that count, or empty
+	}
+	var files []churnFile
+	var blobs []string // Every blob on either side, to count added and deleted files, and check for generated ones
+	for _, c := range changes {
+		lang, skip := rules.classify(c.path)
+		if skip != "" {
+			continue
+		}
+		f := churnFile{lang: lang, path: c.path, from: c.oldBlob}
+		if !c.deleted {
+			f.to = c.blob
+		}
+		files = append(files, f)
+		for _, blob := range []string{f.from, f.to} {
+			if blob != "" {
+				blobs = append(blobs, blob)
+			}
+		}
+	}
+	infos, err := blobInfos(repo, blobs, cache)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var pairs []blobPair
+	counted := files[:0]
+	for _, f := range files {
+		if rules.skipsGenerated(f.path) {
+			if infos[f.from].generated.skips(f.lang) {
+				f.from = ""
+			}
+			if infos[f.to].generated.skips(f.lang) {
+				f.to = ""
+			}
+		}
+		if f.from == "" && f.to == "" {
+			continue
+		}
+		counted = append(counted, f)
+		if f.from != "" && f.to != "" {
+			pairs = append(pairs, blobPair{from: f.from, to: f.to})
+		}
+	}
+	files = counted
+	diffs, err := diffBlobs(repo, pairs, cache)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	added = make(map[string]int)
+	removed = make(map[string]int)
+	for _, f := range files {
+		var diff lineDiff
+		switch {
+		case f.from == "":
+			diff.added = textLines(infos[f.to])
+		case f.to == "":
+			diff.removed = textLines(infos[f.from])
+		default:
+			diff = diffs[blobPair{from: f.from, to: f.to}]
+		}
+		if diff.added > 0 {
+			added[f.lang.id] += diff.added
+		}
+		if diff.removed > 0 {
+			removed[f.lang.id] += diff.removed
+		}
+	}
+	return added, removed, nil
+}
+
+// attributeLines counts the lines each author added and removed with the day's commits, from a line diff
+// of every commit against its first parent. Merge commits are skipped, since their changes belong to the
+// commits they merge. Authors with commits that changed nothing are included with zero.
+func attributeLines(repo repository, rules fileRules, day commit, cache *blobCache) (added, removed map[string]int, err error) {
+	added = make(map[string]int)
+	removed = make(map[string]int)
+	for _, c := range day.commits {
+		if len(c.parents) > 1 {
+			continue
+		}
+		commitAdded, commitRemoved, err := countChurn(repo, rules, c.firstParent(), c.hash, cache)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to diff commit %s: %w", c.hash, err)
+		}
+		author := c.authors[0]
+		added[author] += sumValues(commitAdded)
+		removed[author] += sumValues(commitRemoved)
+	}
+	return added, removed, nil
+}
+
+// blobInfos returns the info of each blob, from the cache where possible. Missing blobs are left out.
+func blobInfos(repo repository, blobs []string, cache *blobCache) (map[string]blobInfo, error) {
+	infos := make(map[string]blobInfo, len(blobs))
+	var requests []blobRequest
+	for _, blob := range blobs {
+		if info, ok := cache.getAnyLanguage(blob); ok {
+			infos[blob] = info
+			continue
+		}
+		requests = append(requests, blobRequest{blob: blob})
+	}
+
+	results, err := readBlobs(repo, requests)
+	if err != nil {
+		return nil, err
+	}
+	for key, info := range results {
+		cache.put(key.blob, info)
+		infos[key.blob] = info
+	}
+	return infos, nil
+}
+
+// textLines returns the line count of a blob, or 0 if it's binary.
+func textLines(info blobInfo) int {
+	if info.binary {
+		return 0
+	}
+	return info.lines
+}
+
+// diffBlobs returns the line diff of each pair, from the cache where possible. Both sides of a pair are
+// streamed one after the other, so at most two blobs per worker are held in memory. Pairs with a side
+// larger than maxBufferedBlob fall back to the difference in line counts. A missing blob is an error, since
+// its pair's churn would otherwise go uncounted.
+func diffBlobs(repo repository, pairs []blobPair, cache *blobCache) (map[blobPair]lineDiff, error) {
+	diffs := make(map[blobPair]lineDiff, len(pairs))
+	var toRead []blobPair
+	var names []string
+	for _, pair := range pairs {
+		if diff, ok := cache.getDiff(pair); ok {
+			diffs[pair] = diff
+			continue
+		}
+		toRead = append(toRead, pair)
+		names = append(names, pair.from, pair.to)
+	}
+	if len(toRead) == 0 {
+		return diffs, nil
+	}
+
+	scanner := blobScanners.Get().(*blobScanner)
+	defer blobScanners.Put(scanner)
+
+	// The old side of the current pair. Binary blobs count as empty.
+	var (
+		oldIndex    = -1
+		oldContent  []byte
+		oldLines    int
+		oldBuffered bool
+	)
+	// The handler runs on a single worker goroutine, one object at a time, old side first
+	objs, err := repo.stream(names, func(i int, obj gitObject, content io.Reader) {
+		scan, err := scanner.scan(content, obj.size, maxBufferedBlob, true, nil)
+		if err != nil {
+			return // The pool sees the same read error, and retries or fails the request
+		}
+		scanned := scan.buffered || scan.binary
+		if i%2 == 0 {
+			oldIndex, oldContent, oldLines, oldBuffered = i, append(oldContent[:0], scan.content...), scan.lines, scanned
+			return
+		}
+		if oldIndex != i-1 {
+			return // The old side is missing, which fails the request below
+		}
+
+		pair := toRead[i/2]
+		if oldBuffered && scanned {
+			diff := diffLines(oldContent, scan.content)
+			diffs[pair] = diff
+			cache.putDiff(pair, diff)
+			return
+		}
+		// Too large to diff. Don't cache this, since a larger buffer would give a real diff.
+		delta := scan.lines - oldLines
+		diffs[pair] = lineDiff{added: max(delta, 0), removed: max(-delta, 0)}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, obj := range objs {
+		if obj.missing {
+			pair := toRead[i/2]
+			return nil, fmt.Errorf("failed to diff blob %s against %s: %s is missing", pair.from, pair.to, names[i])
+		}
+	}
+	return diffs, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import "unicode/utf8"
+
+// commentSyntax describes a language's comments and string literals, so its lines can be told apart into code
+// and comments. Strings only matter so that comment markers inside them are ignored.
+type commentSyntax struct {
+	line    []string       // Line comment prefixes
+	block   []blockComment // Checked before line comments, so Lua's --[[ wins over --
+	nested  bool           // Whether block comments nest
+	strings []stringSyntax // Checked in order, so longer delimiters go first
+	// charQuotes is set for languages where ' starts a char literal like 'x' or '\n', but is also used on its own
+	// for lifetimes, type variables, or primes. Other uses of ' are just code.
+	charQuotes bool
+}
+
+type blockComment struct {
+	start string
+	end   string
+}
+
+type stringSyntax struct {
+	start     string
+	end       string
+	multiline bool // If not set, an unterminated string ends at the end of its line
+	raw       bool // If set, backslashes don't escape
+}
+
+// Syntax shared by several languages.
+var (
+	cLineComments  = []string{"//"}
+	cBlockComments = []blockComment{{"/*", "*/"}}
+	cStrings       = []stringSyntax{{start: `"`, end: `"`}, {start: "'", end: "'"}}
+	htmlComments   = []blockComment{{"<!--", "-->"}}
+	cSyntax        = &commentSyntax{line: cLineComments, block: cBlockComments, strings: cStrings}
+	jsSyntax       = &commentSyntax{line: cLineComments, block: cBlockComments,
+		strings: append([]stringSyntax{{start: "`", end: "`", multiline: true}}, cStrings...)}
+	// Svelte, Vue, and Astro files mix markup, scripts, and styles. Quotes aren't treated as strings since
+	// apostrophes in text would open them.
+	componentSyntax = &commentSyntax{line: cLineComments, block: []blockComment{{"<!--", "-->"}, {"/*", "*/"}}}
+)
+
+// classifierLookahead is how many bytes past the current one the classifier may need to look at: enough for
+// any delimiter and char literal.
+const classifierLookahead = 16
+
+// lineClassifier sorts the lines of a blob into code, comment, and blank lines as it's fed in chunks. A line
+// is blank if it's only whitespace, a comment line if everything else on it is in comments, and code
+// otherwise. Without a syntax, every line that isn't blank is code.
+type lineClassifier struct {
+	syntax  *commentSyntax
+	pending []byte // Unprocessed bytes from the end of the last chunk, too close to its end to look ahead from
+
+	state   classifierState
+	depth   int // Nesting depth of the block comment we're in
+	current int // Index of the block comment or string we're in
+
+	code, comment bool // Whether the current line has code or comments so far
+	midLine       bool // Whether anything follows the last newline
+
+	comments, blanks int
+}
+
+type classifierState int
+
+const (
+	inCode classifierState = iota
+	inLineComment
+	inBlockComment
+	inString
+)
+
+func (c *lineClassifier) reset(syntax *commentSyntax) {
+	*c = lineClassifier{syntax: syntax, pending: c.pending[:0]}
+}
+
+// write classifies the lines in the next chunk of the blob.
+func (c *lineClassifier) write(data []byte) {
+	if len(c.pending) > 0 {
+		c.pending = append(c.pending, data...)
+		data = c.pending
+	}
+	n := c.process(data, false)
+	c.pending = append(c.pending[:0], data[n:]...)
+}
+
+// finish classifies what's left, and returns the number of comment and blank lines. The rest are code.
+func (c *lineClassifier) finish() (comments, blanks int) {
+	c.process(c.pending, true)
+	c.pending = c.pending[:0]
+	if c.midLine {
+		c.endLine()
+	}
+	return c.comments, c.blanks
+}
+
+// process classifies data up to where there's no longer enough of it left to look ahead, or all of it if
+// final is set. Returns how many bytes were processed.
+func (c *lineClassifier) process(data []byte, final bool) int {
+	end := len(data)
+	if !final {
+		end -= classifierLookahead
+	}
+	i := 0
+	for i < end {
+		b := data[i]
+		if b == '\n' {
+			c.endLine()
+			i++
+			continue
+		}
+		c.midLine = true
+		if b == ' ' || b == '\t' || b == '\r' || b == '\f' || b == '\v' {
+			i++
+			continue
+		}
+		if c.syntax == nil {
+			c.code = true
+			i++
+			continue
+		}
+		i = c.step(data, i)
+	}
+	return max(i, 0)
+}
+
+// step handles the non-whitespace byte at data[i], and returns the index of the next one to look at.
+func (c *lineClassifier) step(data []byte, i int) int {
+	syntax := c.syntax
+	rest := data[i:]
+	switch c.state {
+	case inLineComment:
+		c.comment = true
+		return i + 1
+
+	case inBlockComment:
+		c.comment = true
+		block := syntax.block[c.current]
+		if syntax.nested && hasPrefix(rest, block.start) {
+			c.depth++
+			return i + len(block.start)
+		}
+		if hasPrefix(rest, block.end) {
+			c.depth--
+			if c.depth == 0 {
+				c.state = inCode
+			}
+			return i + len(block.end)
+		}
+		return i + 1
+
+	case inString:
+		c.code = true
+		str := syntax.strings[c.current]
+		if !str.raw && rest[0] == '\\' && len(rest) > 1 && rest[1] != '\n' {
+			return i + 2
+		}
+		if hasPrefix(rest, str.end) {
+			c.state = inCode
+			return i + len(str.end)
+		}
+		return i + 1
+	}
+
+	for n, block := range syntax.block {
+		if hasPrefix(rest, block.start) {
+			c.state, c.current, c.depth = inBlockComment, n, 1
+			c.comment = true
+			return i + len(block.start)
+		}
+	}
+	for _, prefix := range syntax.line {
+		if hasPrefix(rest, prefix) {
+			c.state = inLineComment
+			c.comment = true
+			return i + len(prefix)
+		}
+	}
+	c.code = true
+	for n, str := range syntax.strings {
+		if hasPrefix(rest, str.start) {
+			c.state, c.current = inString, n
+			return i + len(str.start)
+		}
+	}
+	if syntax.charQuotes && rest[0] == '\'' {
+		return i + charLiteralLen(rest)
+	}
+	return i + 1
+}
+
+// charLiteralLen returns the length of the char literal at the start of data, or 1 if the ' there doesn't
+// start one.
+func charLiteralLen(data []byte) int {
+	if len(data) > 2 && data[1] == '\\' {
+		for n := 3; n < min(len(data), classifierLookahead); n++ {
+			if data[n] == '\'' {
+				return n + 1
+			}
+		}
+		return 1
+	}
+	_, size := utf8.DecodeRune(data[1:])
+	if size > 0 && data[1] != '\n' && 1+size < len(data) && data[1+size] == '\'' {
+		return 2 + size
+	}
+	return 1
+}
+
+func (c *lineClassifier) endLine() {
+	switch {
+	case c.code:
+	case c.comment:
+		c.comments++
+	default:
+		c.blanks++
+	}
+	c.code, c.comment, c.midLine = false, false, false
+	if c.state == inLineComment || (c.state == inString && !c.syntax.strings[c.current].multiline) {
+		c.state = inCode
+	}
+}
+
+func hasPrefix(data []byte, prefix string) bool {
+	return len(data) >= len(prefix) && string(data[:len(prefix)]) == prefix
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLineClassifier(t *testing.T) {
+	tests := []struct {
+		name             string
+		lang             string
+		content          string
+		comments, blanks int
+	}{
+		{"no syntax", "docs", "# Title\n\n  \ntext\n", 0, 2},
+		{"line comments", "go", "// Package x\npackage x\n\nvar a = 1 // trailing\n", 1, 1},
+		{"block comment", "c", "/*\n * License\n\n */\nint x;\n", 3, 1},
+		{"code after block comment", "c", "/* a */ int x;\n/* b */\n", 1, 0},
+		{"marker in string", "javascript", "const url = \"http://x\"\nconst s = '/*'\nlet a\n", 0, 0},
+		{"escaped quote", "c", "char *s = \"\\\"/*\";\nint x;\n", 0, 0},
+		{"multiline string", "javascript", "const s = `\n// not a comment\n`\n", 0, 0},
+		{"single-line string ends at newline", "c", "x = 'it's\n// comment\n", 1, 0},
+		{"nested block comments", "rust", "/* a /* b */\nstill comment */\nfn main() {}\n", 2, 0},
+		{"unnested block comments", "c", "/* a /* b */\nint x;\n", 1, 0},
+		{"lifetimes", "rust", "fn f<'a>(x: &'a str) {}\n/* c */\n", 1, 0},
+		{"char literals", "rust", "let q = '\"';\n// c\nlet e = '\\'';\n// d\n", 2, 0},
+		{"python docstring", "python", "\"\"\"\n# not a comment\n\"\"\"\n# comment\n", 1, 0},
+		{"lua block before line comment", "lua", "--[[\nblock\n]]\n-- line\nx = 1\n", 4, 0},
+		{"html comment", "html", "<!--\n  note\n-->\n<p>don't</p>\n", 3, 0},
+		{"no trailing newline", "go", "x := 1\n// end", 1, 0},
+		{"trailing whitespace line", "go", "x := 1\n  ", 0, 1},
+	}
+
+	scanner := &blobScanner{chunk: make([]byte, 64*1024)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syntax := languageByID(tt.lang).comments
+			scan, err := scanner.scan(strings.NewReader(tt.content), len(tt.content), 0, false, syntax)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if scan.comments != tt.comments || scan.blanks != tt.blanks {
+				t.Errorf("comments, blanks = %d, %d, want %d, %d", scan.comments, scan.blanks, tt.comments, tt.blanks)
+			}
+
+			// Feeding it a byte at a time gives the same result
+			var c lineClassifier
+			c.reset(syntax)
+			for i := range len(tt.content) {
+				c.write([]byte{tt.content[i]})
+			}
+			if comments, blanks := c.finish(); comments != tt.comments || blanks != tt.blanks {
+				t.Errorf("byte by byte: comments, blanks = %d, %d, want %d, %d", comments, blanks, tt.comments, tt.blanks)
+			}
+		})
+	}
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// configFileNames are the names a repo's config can have at the root of its tree, in the order they're looked for.
+var configFileNames = []string{".gitstrata.yml", ".gitstrata.yaml", ".gitstrata.toml"}
+
+// repoConfig is a repo's own counting rules, from a .gitstrata.yml or .gitstrata.toml at the root of the analyzed
+// tip, or from --config.
+type repoConfig struct {
+	skip      []string          // Filename globs to skip, on top of skipPatterns
+	skipDirs  []string          // Directory names to skip, on top of skipDirs
+	unskip    []string          // Entries of skipPatterns or skipDirs to count after all
+	testDirs  []string          // Directory names that hold tests, on top of each language's
+	languages map[string]string // Language ids by lowercase extension, overriding the registry
+}
+
+func (c *repoConfig) String() string {
+	if c == nil {
+		return "none"
+	}
+	return fmt.Sprintf("skip=%q skip-dirs=%q unskip=%q test-dirs=%q languages=%q",
+		c.skip, c.skipDirs, c.unskip, c.testDirs, c.languages)
+}
+
+// configField is a top-level setting as parsed from a config file, before it's checked.
+type configField struct {
+	name  string
+	line  int
+	isMap bool
+	items []configItem
+}
+
+// configItem is a list item, or a map entry if key is set.
+type configItem struct {
+	key   string
+	value string
+	line  int
+}
+
+// loadConfig reads the config at path, or if path is empty, the repo's own config at commitHash. Returns nil if
+// there's none.
+func loadConfig(repo repository, commitHash, path string, log *logger) (*repoConfig, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		return parseConfig(path, string(data))
+	}
+
+	tree, err := commitTree(repo, commitHash)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readTrees(repo, []string{tree})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range configFileNames {
+		i := slices.IndexFunc(entries[0], func(e treeEntry) bool { return e.name == name })
+		if i < 0 || entries[0][i].isTree() || entries[0][i].isSubmodule() {
+			continue
+		}
+		objs, err := repo.read([]string{entries[0][i].hash})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if objs[0].missing {
+			return nil, fmt.Errorf("failed to read %s: blob %s is missing", name, entries[0][i].hash)
+		}
+		log.printf("Using settings from %s", name)
+		return parseConfig(name, string(objs[0].data))
+	}
+	return nil, nil
+}
+
+// parseConfig parses a config file, as TOML if its name ends in .toml and as YAML otherwise. Errors point at
+// the line they're about, as "name:line: message".
+func parseConfig(name, text string) (*repoConfig, error) {
+	var fields []*configField
+	var err error
+	switch ext := filepath.Ext(name); ext {
+	case ".toml":
+		fields, err = parseTOMLConfig(name, text)
+	case ".yml", ".yaml":
+		fields, err = parseYAMLConfig(name, text)
+	default:
+		return nil, fmt.Errorf("unknown config format %q (expected .yml, .yaml, or .toml)", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buildRepoConfig(name, fields)
+}
+
+// buildRepoConfig checks the parsed settings and turns them into a repoConfig.
+func buildRepoConfig(name string, fields []*configField) (*repoConfig, error) {
+	config := &repoConfig{}
+	for _, f := range fields {
+		var list *[]string
+		switch f.name {
+		case "skip":
+			list = &config.skip
+		case "skip-dirs":
+			list = &config.skipDirs
+		case "unskip":
+			list = &config.unskip
+		case "test-dirs":
+			list = &config.testDirs
+		case "languages":
+			if !f.isMap && len(f.items) > 0 {
+				return nil, configError(name, f.line, "languages should map extensions to language ids, like .tpl: html")
+			}
+			config.languages = make(map[string]string, len(f.items))
+			lines := make(map[string]int, len(f.items))
+			for _, item := range f.items {
+				ext := strings.ToLower(item.key)
+				if !strings.HasPrefix(ext, ".") {
+					ext = "." + ext
+				}
+				if ext == "." || strings.ContainsAny(ext, "/*?[") {
+					return nil, configError(name, item.line, "invalid extension %q", item.key)
+				}
+				if languageByID(item.value) == nil {
+					return nil, configError(name, item.line, "unknown language %q", item.value)
+				}
+				if first, dup := lines[ext]; dup {
+					return nil, configError(name, item.line, "%s is already mapped on line %d", ext, first)
+				}
+				lines[ext] = item.line
+				config.languages[ext] = item.value
+			}
+			continue
+		default:
+			return nil, configError(name, f.line, "unknown setting %q (expected skip, skip-dirs, unskip, test-dirs, or languages)", f.name)
+		}
+
+		if f.isMap {
+			return nil, configError(name, f.line, "%s should be a list", f.name)
+		}
+		for _, item := range f.items {
+			value := item.value
+			switch f.name {
+			case "skip":
+				if _, err := filepath.Match(value, ""); err != nil || strings.Contains(value, "/") {
+					return nil, configError(name, item.line, "invalid filename glob %q", value)
+				}
+			case "skip-dirs", "test-dirs":
+				if strings.Contains(value, "/") {
+					return nil, configError(name, item.line, "%q should be a directory name, not a path", value)
+				}
+			case "unskip":
+				if !slices.Contains(skipPatterns, value) && !skipDirs[value] {
+					return nil, configError(name, item.line, "%q isn't skipped by default", value)
+				}
+			}
+			*list = append(*list, value)
+		}
+	}
+	return config, nil
+}
+
+// configError returns an error pointing at a line of a config file.
+func configError(name string, line int, format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", name, line, fmt.Sprintf(format, args...))
+}
+
+// parseYAMLConfig parses the subset of YAML a config needs: top-level keys holding a list (as "- item" lines or
+// [a, b]), or a map of "key: value" lines. Comments start with #.
+func parseYAMLConfig(name string, text string) ([]*configField, error) {
+	var fields []*configField
+	seen := make(map[string]int)
+	var current *configField
+	inline := false // Whether current had its value on the same line
+	for i, raw := range strings.Split(text, "\n") {
+		line := i + 1
+		content, err := stripConfigComment(raw, false)
+		if err != nil {
+			return nil, configError(name, line, "%v", err)
+		}
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+
+		indent := content[:len(content)-len(strings.TrimLeft(content, " \t"))]
+		if strings.Contains(indent, "\t") {
+			return nil, configError(name, line, "tabs can't be used for indentation")
+		}
+		content = strings.TrimSpace(content)
+		if content == "---" && indent == "" && len(fields) == 0 {
+			continue
+		}
+
+		// List items can be indented or not, map entries must be
+		item, isItem := strings.CutPrefix(content, "-")
+		isItem = isItem && (item == "" || item[0] == ' ')
+		if indent != "" || isItem {
+			if current == nil || inline {
+				return nil, configError(name, line, "unexpected indented line")
+			}
+			if isItem {
+				if current.isMap {
+					return nil, configError(name, line, "expected \"key: value\" under %s, like the lines above", current.name)
+				}
+				value, err := parseConfigScalar(strings.TrimSpace(item))
+				if err != nil {
+					return nil, configError(name, line, "%v", err)
+				}
+				if value == "" {
+					return nil, configError(name, line, "empty list item")
+				}
+				current.items = append(current.items, configItem{value: value, line: line})
+				continue
+			}
+			key, value, ok, err := cutYAMLMapping(content)
+			if err != nil {
+				return nil, configError(name, line, "%v", err)
+			}
+			if !ok {
+				return nil, configError(name, line, "expected \"- item\" or \"key: value\"")
+			}
+			if !current.isMap && len(current.items) > 0 {
+				return nil, configError(name, line, "expected \"- item\" under %s, like the lines above", current.name)
+			}
+			if value == "" {
+				return nil, configError(name, line, "missing value for %s", key)
+			}
+			current.isMap = true
+			current.items = append(current.items, configItem{key: key, value: value, line: line})
+			continue
+		}
+
+		key, value, ok, err := cutYAMLMapping(content)
+		if err != nil {
+			return nil, configError(name, line, "%v", err)
+		}
+		if !ok {
+			return nil, configError(name, line, "expected \"key:\"")
+		}
+		if first, dup := seen[key]; dup {
+			return nil, configError(name, line, "%s is already set on line %d", key, first)
+		}
+		seen[key] = line
+		current = &configField{name: key, line: line}
+		fields = append(fields, current)
+		inline = value != ""
+		if !inline {
+			continue
+		}
+		if !strings.HasPrefix(value, "[") {
+			return nil, configError(name, line, "expected a list like [a, b], or entries on the lines below %s", key)
+		}
+		if !strings.HasSuffix(value, "]") {
+			return nil, configError(name, line, "unterminated list")
+		}
+		for _, part := range splitConfigList(value[1 : len(value)-1]) {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			item, err := parseConfigScalar(part)
+			if err != nil {
+				return nil, configError(name, line, "%v", err)
+			}
+			current.items = append(current.items, configItem{value: item, line: line})
+		}
+	}
+	return fields, nil
+}
+
+// cutYAMLMapping splits "key: value" at the first colon outside quotes that's followed by a space or the end of
+// the line. The value is returned unquoted, unless it's a list.
+func cutYAMLMapping(s string) (key, value string, ok bool, err error) {
+	quote := byte(0)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ':' && (i+1 == len(s) || s[i+1] == ' '):
+			if key, err = parseConfigScalar(strings.TrimSpace(s[:i])); err != nil {
+				return "", "", false, err
+			}
+			value = strings.TrimSpace(s[i+1:])
+			if !strings.HasPrefix(value, "[") {
+				if value, err = parseConfigScalar(value); err != nil {
+					return "", "", false, err
+				}
+			}
+			return key, value, key != "", nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// parseTOMLConfig parses the subset of TOML a config needs: top-level keys holding arrays of strings, which
+// can span lines, and a [languages] table (or inline table) of strings. Comments start with #.
+func parseTOMLConfig(name string, text string) ([]*configField, error) {
+	var fields []*configField
+	seen := make(map[string]int)
+	add := func(key string, line int) (*configField, error) {
+		if first, dup := seen[key]; dup {
+			return nil, configError(name, line, "%s is already set on line %d", key, first)
+		}
+		seen[key] = line
+		f := &configField{name: key, line: line}
+		fields = append(fields, f)
+		return f, nil
+	}
+
+	var table *configField // The [table] that keys go into, if any
+	var array *configField // The array that's still open, if any
+	for i, raw := range strings.Split(text, "\n") {
+		line := i + 1
+		content, err := stripConfigComment(raw, true)
+		if err != nil {
+			return nil, configError(name, line, "%v", err)
+		}
+		content = strings.TrimSpace(content)
+		if content == "" {
+			continue
+		}
+
+		if array != nil {
+			closed, err := parseTOMLArray(content, line, array)
+			if err != nil {
+				return nil, configError(name, line, "%v", err)
+			}
+			if closed {
+				array = nil
+			}
+			continue
+		}
+
+		if strings.HasPrefix(content, "[") {
+			if strings.HasPrefix(content, "[[") {
+				return nil, configError(name, line, "arrays of tables aren't supported")
+			}
+			if !strings.HasSuffix(content, "]") {
+				return nil, configError(name, line, "unterminated table header")
+			}
+			key, err := parseConfigScalar(strings.TrimSpace(content[1 : len(content)-1]))
+			if err != nil {
+				return nil, configError(name, line, "%v", err)
+			}
+			if table, err = add(key, line); err != nil {
+				return nil, err
+			}
+			table.isMap = true
+			continue
+		}
+
+		eq := indexOutsideQuotes(content, '=')
+		if eq < 0 {
+			return nil, configError(name, line, "expected \"key = value\"")
+		}
+		key, err := parseConfigScalar(strings.TrimSpace(content[:eq]))
+		if err != nil {
+			return nil, configError(name, line, "%v", err)
+		}
+		value := strings.TrimSpace(content[eq+1:])
+		if key == "" || value == "" {
+			return nil, configError(name, line, "expected \"key = value\"")
+		}
+
+		if table != nil {
+			if !isTOMLString(value) {
+				return nil, configError(name, line, "expected a quoted string for %s", key)
+			}
+			s, err := parseConfigScalar(value)
+			if err != nil {
+				return nil, configError(name, line, "%v", err)
+			}
+			table.items = append(table.items, configItem{key: key, value: s, line: line})
+			continue
+		}
+
+		f, err := add(key, line)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.HasPrefix(value, "["):
+			closed, err := parseTOMLArray(value[1:], line, f)
+			if err != nil {
+				return nil, configError(name, line, "%v", err)
+			}
+			if !closed {
+				array = f
+			}
+		case strings.HasPrefix(value, "{"):
+			if !strings.HasSuffix(value, "}") {
+				return nil, configError(name, line, "unterminated inline table")
+			}
+			f.isMap = true
+			for _, part := range splitConfigList(value[1 : len(value)-1]) {
+				if part = strings.TrimSpace(part); part == "" {
+					continue
+				}
+				eq := indexOutsideQuotes(part, '=')
+				if eq < 0 {
+					return nil, configError(name, line, "expected \"key = value\" in inline table")
+				}
+				k, err := parseConfigScalar(strings.TrimSpace(part[:eq]))
+				if err != nil {
+					return nil, configError(name, line, "%v", err)
+				}
+				v := strings.TrimSpace(part[eq+1:])
+				if !isTOMLString(v) {
+					return nil, configError(name, line, "expected a quoted string for %s", k)
+				}
+				if v, err = parseConfigScalar(v); err != nil {
+					return nil, configError(name, line, "%v", err)
+				}
+				f.items = append(f.items, configItem{key: k, value: v, line: line})
+			}
+		default:
+			return nil, configError(name, line, "%s should be an array, like [\"a\", \"b\"]", key)
+		}
+	}
+	if array != nil {
+		return nil, configError(name, array.line, "unterminated array")
+	}
+	return fields, nil
+}
+
+// parseTOMLArray adds the strings in s, part of an array, to f. Returns true if s closes the array.
+func parseTOMLArray(s string, line int, f *configField) (bool, error) {
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		switch {
+		case s == "":
+			return false, nil
+		case s[0] == ']':
+			if rest := strings.TrimSpace(s[1:]); rest != "" {
+				return false, fmt.Errorf("unexpected %q after array", rest)
+			}
+			return true, nil
+		case s[0] != '"' && s[0] != '\'':
+			return false, fmt.Errorf("expected a quoted string, got %q", s)
+		}
+		end := closingQuote(s)
+		if end < 0 {
+			return false, fmt.Errorf("unterminated string")
+		}
+		value, err := parseConfigScalar(s[:end+1])
+		if err != nil {
+			return false, err
+		}
+		f.items = append(f.items, configItem{value: value, line: line})
+		s = s[end+1:]
+		if rest := strings.TrimLeft(s, " \t"); rest != "" && rest[0] != ',' && rest[0] != ']' {
+			return false, fmt.Errorf("expected , or ] after %q", value)
+		}
+	}
+}
+
+// stripConfigComment removes a # comment from a line. In YAML, a # only starts a comment at the start of the
+// line or after whitespace.
+func stripConfigComment(line string, toml bool) (string, error) {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (toml || i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return strings.TrimRight(line[:i], " \t\r"), nil
+		}
+	}
+	if quote != 0 {
+		return "", fmt.Errorf("unterminated string")
+	}
+	return strings.TrimRight(line, " \t\r"), nil
+}
+
+// parseConfigScalar unquotes a double- or single-quoted string, or returns s as is if it's not quoted.
+func parseConfigScalar(s string) (string, error) {
+	if s == "" || (s[0] != '"' && s[0] != '\'') {
+		return s, nil
+	}
+	if closingQuote(s) != len(s)-1 {
+		return "", fmt.Errorf("invalid quoted string %s", s)
+	}
+	if s[0] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	unquoted, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid quoted string %s", s)
+	}
+	return unquoted, nil
+}
+
+// closingQuote returns the index of the quote that closes the string s starts with, or -1 if it isn't closed.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[0] == '"' && s[i] == '\\':
+			i++
+		case s[i] == s[0] && s[0] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++ // '' is an escaped quote in YAML
+		case s[i] == s[0]:
+			return i
+		}
+	}
+	return -1
+}
+
+func isTOMLString(s string) bool {
+	return s != "" && (s[0] == '"' || s[0] == '\'')
+}
+
+// splitConfigList splits s at commas outside quotes.
+func splitConfigList(s string) []string {
+	var parts []string
+	for {
+		i := indexOutsideQuotes(s, ',')
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// indexOutsideQuotes returns the index of the first c in s that's not inside a quoted string, or -1.
+func indexOutsideQuotes(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"' || s[i] == '\'':
+			end := closingQuote(s[i:])
+			if end < 0 {
+				return -1
+			}
+			i += end
+		case s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
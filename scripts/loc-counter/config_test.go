@@ -0,0 +1,139 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	want := &repoConfig{
+		skip:      []string{"*.gen.ts", "schema#1.sql"},
+		skipDirs:  []string{"generated", "third_party"},
+		unskip:    []string{"vendor", "*.pb.go"},
+		testDirs:  []string{"integration"},
+		languages: map[string]string{".tpl": "html", ".inc": "php"},
+	}
+	tests := []struct {
+		name string
+		text string
+	}{
+		{".gitstrata.yml", `# Counting rules
+skip:
+  - "*.gen.ts"
+  - schema#1.sql   # A # inside a word isn't a comment
+skip-dirs: [generated, 'third_party']
+unskip:
+- vendor
+- '*.pb.go'
+test-dirs: [integration]
+languages:
+  .tpl: html
+  "inc": php
+`},
+		{".gitstrata.toml", `# Counting rules
+skip = ["*.gen.ts", 'schema#1.sql']
+skip-dirs = [
+  "generated",  # Codegen output
+  "third_party",
+]
+unskip = ["vendor", "*.pb.go"]
+test-dirs = ["integration"]
+
+[languages]
+".tpl" = "html"
+inc = "php"
+`},
+		{"inline.toml", `skip = ["*.gen.ts", "schema#1.sql"]
+skip-dirs = ["generated", "third_party"]
+unskip = ["vendor", "*.pb.go"]
+test-dirs = ["integration"]
+languages = { ".tpl" = "html", ".inc" = "php" }
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConfig(tt.name, tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("parseConfig() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"c.yml", "skip:\n  - a\nskip-dir: [b]\n", "c.yml:3: unknown setting \"skip-dir\""},
+		{"c.yml", "skip:\n  - a\n\nskip:\n  - b\n", "c.yml:4: skip is already set on line 1"},
+		{"c.yml", "languages:\n  .tpl: html\n  .x: cobol\n", "c.yml:3: unknown language \"cobol\""},
+		{"c.yml", "languages:\n  - html\n", "c.yml:1: languages should map extensions"},
+		{"c.yml", "skip:\n  .tpl: html\n", "c.yml:1: skip should be a list"},
+		{"c.yml", "skip: a\n", "c.yml:1: expected a list like [a, b]"},
+		{"c.yml", "skip:\n  - \"a\n", "c.yml:2: unterminated string"},
+		{"c.yml", "skip:\n\t- a\n", "c.yml:2: tabs can't be used for indentation"},
+		{"c.yml", "skip:\n  - a\n  b: c\n", "c.yml:3: expected \"- item\" under skip"},
+		{"c.yml", "unskip: [vendor, build]\n", "c.yml:1: \"build\" isn't skipped by default"},
+		{"c.yml", "skip-dirs:\n  - a/b\n", "c.yml:2: \"a/b\" should be a directory name"},
+		{"c.yml", "skip:\n  - '[a'\n", "c.yml:2: invalid filename glob"},
+		{"c.toml", "skip = [\"a\",\n  b,\n]\n", "c.toml:2: expected a quoted string"},
+		{"c.toml", "skip = [\"a\",\n  \"b\",\n", "c.toml:1: unterminated array"},
+		{"c.toml", "[languages]\n\".tpl\" = \"html\"\n\".TPL\" = \"php\"\n", "c.toml:3: .tpl is already mapped on line 2"},
+		{"c.toml", "skip = \"a\"\n", "c.toml:1: skip should be an array"},
+		{"c.toml", "skip\n", "c.toml:1: expected \"key = value\""},
+		{"c.json", "{}", "unknown config format \".json\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			_, err := parseConfig(tt.name, tt.text)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("parseConfig() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigRules(t *testing.T) {
+	config := &repoConfig{
+		skip:      []string{"*.gen.ts"},
+		skipDirs:  []string{"generated"},
+		unskip:    []string{"vendor", "*.pb.go"},
+		testDirs:  []string{"integration"},
+		languages: map[string]string{".h": "objc", ".tpl": "html"},
+	}
+	scope, err := newPathScope(nil, nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{"src/api.gen.ts": true, "api.pb.go": false, "go.sum": true, "main.go": false} {
+		if got := scope.skipsFile(path); got != want {
+			t.Errorf("skipsFile(%q) = %v, want %v", path, got, want)
+		}
+	}
+	for dir, want := range map[string]bool{"generated": true, "vendor": false, "node_modules": true} {
+		if got := scope.skipsDir(dir); got != want {
+			t.Errorf("skipsDir(%q) = %v, want %v", dir, got, want)
+		}
+	}
+
+	langs := newLanguageMap(config)
+	extMap := langs.forTree(map[string]bool{".cpp": true, ".h": true})
+	if lang := languageForFile("views/index.tpl", extMap); lang.id != "html" {
+		t.Errorf(".tpl counts as %s, want html", lang.id)
+	}
+	if lang := languageForFile("include/api.h", extMap); lang.id != "objc" {
+		t.Errorf(".h counts as %s, want objc even with C++ sources", lang.id)
+	}
+	if extensionToLanguage[".tpl"] != nil {
+		t.Error("newLanguageMap must not modify the shared extension map")
+	}
+	if got := countTestLines("integration/api.go", 10, 0, languageByID("go"), langs.extraTestDirs()); got != 10 {
+		t.Errorf("countTestLines() in a configured test dir = %d, want 10", got)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// csvColumn is one language column in the CSV output.
+type csvColumn struct {
+	header string
+	value  func(s *fileStats) int
+}
+
+// buildCSVColumns returns the per-language columns: one total column per language, prod and test columns
+// for languages with a prod/test split, code, comment, and blank columns, and if churn is set, added and
+// removed columns.
+func buildCSVColumns(languageIDs []string, churn bool) []csvColumn {
+	var columns []csvColumn
+	for _, id := range languageIDs {
+		count := func(field func(c *languageCount) int) func(s *fileStats) int {
+			return func(s *fileStats) int {
+				if c, ok := s.languages[id]; ok {
+					return field(c)
+				}
+				return 0
+			}
+		}
+		columns = append(columns, csvColumn{header: id, value: count(func(c *languageCount) int { return c.total })})
+		if lang := languageByID(id); lang != nil && !lang.noTestSplit {
+			columns = append(columns,
+				csvColumn{header: id + " prod", value: count(func(c *languageCount) int { return c.prod })},
+				csvColumn{header: id + " test", value: count(func(c *languageCount) int { return c.test })},
+			)
+		}
+		columns = append(columns,
+			csvColumn{header: id + " code", value: count(func(c *languageCount) int { return c.code })},
+			csvColumn{header: id + " comment", value: count(func(c *languageCount) int { return c.comment })},
+			csvColumn{header: id + " blank", value: count(func(c *languageCount) int { return c.blank })},
+		)
+		if !churn {
+			continue
+		}
+		columns = append(columns,
+			csvColumn{header: id + " added", value: func(s *fileStats) int { return s.added[id] }},
+			csvColumn{header: id + " removed", value: func(s *fileStats) int { return s.removed[id] }},
+		)
+	}
+	return columns
+}
+
+// writeCSV writes one row per day. days must be parallel to dates, with gaps already carried forward.
+func writeCSV(w io.Writer, dates []string, days []*fileStats) error {
+	columns := buildCSVColumns(detectLanguages(days), true)
+
+	cw := csv.NewWriter(w)
+	header := []string{"date", "total", "added", "removed"}
+	for _, col := range columns {
+		header = append(header, col.header)
+	}
+	header = append(header, "comments", "authors")
+	if err := cw.Write(header); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	for i, date := range dates {
+		stats := days[i]
+		row := []string{date, strconv.Itoa(stats.total), strconv.Itoa(sumValues(stats.added)), strconv.Itoa(sumValues(stats.removed))}
+		for _, col := range columns {
+			row = append(row, strconv.Itoa(col.value(stats)))
+		}
+		row = append(row, strings.Join(stats.comments, ";"), strings.Join(stats.authors, ";"))
+
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("failed to write CSV row for %s: %w", date, err)
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeContributorsCSV writes one row per author per day: their net lines since the start of the history,
+// and the lines they added and removed that day. Authors are sorted by net lines, descending.
+func writeContributorsCSV(w io.Writer, dates []string, days []*fileStats) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"date", "author", "lines", "added", "removed"}); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	for i, date := range dates {
+		stats := days[i]
+		authors := slices.Collect(maps.Keys(stats.contributors))
+		sort.Slice(authors, func(a, b int) bool {
+			la, lb := stats.contributors[authors[a]], stats.contributors[authors[b]]
+			if la != lb {
+				return la > lb
+			}
+			return authors[a] < authors[b]
+		})
+		for _, author := range authors {
+			row := []string{
+				date,
+				author,
+				strconv.Itoa(stats.contributors[author]),
+				strconv.Itoa(stats.contributorAdded[author]),
+				strconv.Itoa(stats.contributorRemoved[author]),
+			}
+			if err := cw.Write(row); err != nil {
+				return fmt.Errorf("failed to write CSV row for %s: %w", date, err)
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeBreakdownCSV writes one row per directory or component per day, with its lines per language. Every
+// group gets a row on every day, with zeros while it has no files, so each one is a complete series.
+func writeBreakdownCSV(w io.Writer, dates []string, days []*fileStats) error {
+	columns := buildCSVColumns(detectLanguages(days), false)
+	seen := make(map[string]bool)
+	for _, stats := range days {
+		for name := range stats.groups {
+			seen[name] = true
+		}
+	}
+	groups := slices.Sorted(maps.Keys(seen))
+
+	cw := csv.NewWriter(w)
+	header := []string{"date", "group", "total"}
+	for _, col := range columns {
+		header = append(header, col.header)
+	}
+	if err := cw.Write(header); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	empty := newFileStats(nil)
+	for i, date := range dates {
+		for _, name := range groups {
+			stats, ok := days[i].groups[name]
+			if !ok {
+				stats = empty
+			}
+			row := []string{date, name, strconv.Itoa(stats.total)}
+			for _, col := range columns {
+				row = append(row, strconv.Itoa(col.value(stats)))
+			}
+			if err := cw.Write(row); err != nil {
+				return fmt.Errorf("failed to write CSV row for %s: %w", date, err)
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func sumValues(m map[string]int) int {
+	total := 0
+	for _, n := range m {
+		total += n
+	}
+	return total
+}
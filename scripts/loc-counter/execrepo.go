@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// execRepo reads a repo by running the git binary: git log for history, and a pool of long-lived
+// cat-file processes for objects.
+type execRepo struct {
+	root   string
+	common string
+	pool   *catFilePool
+}
+
+// openExecRepo opens the repo containing path, with workers cat-file --batch processes for reading objects.
+func openExecRepo(path string, workers int) (*execRepo, error) {
+	output, err := exec.Command("git", "-C", path, "rev-parse", "--is-bare-repository", "--path-format=absolute", "--git-common-dir").Output()
+	if err != nil {
+		return nil, fmt.Errorf("not a git repository: %s", path)
+	}
+	bare, common, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	root := common
+	if bare != "true" {
+		output, err := exec.Command("git", "-C", path, "rev-parse", "--show-toplevel").Output()
+		if err != nil {
+			return nil, fmt.Errorf("not a git repository: %s", path)
+		}
+		root = strings.TrimSpace(string(output))
+	}
+	return &execRepo{root: root, common: common, pool: newCatFilePool(root, workers)}, nil
+}
+
+// git returns a git command that runs in the repo.
+func (r *execRepo) git(args ...string) *exec.Cmd {
+	return exec.Command("git", append([]string{"-C", r.root}, args...)...)
+}
+
+func (r *execRepo) log(rev string) ([]logEntry, error) {
+	// NUL-separated, since names and subjects can contain anything else
+	cmd := r.git("log", "--format=%H%x00%aI%x00%cI%x00%an%x00%ae%x00%P%x00%s", rev, "--")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run git log: %w", err)
+	}
+
+	var entries []logEntry
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, "\x00", 7)
+		if len(parts) != 7 {
+			continue
+		}
+
+		entries = append(entries, logEntry{
+			hash:          parts[0],
+			authorTime:    parseLogTime(parts[1]),
+			committerTime: parseLogTime(parts[2]),
+			authorName:    parts[3],
+			authorEmail:   parts[4],
+			parents:       strings.Fields(parts[5]),
+			subject:       parts[6],
+		})
+	}
+
+	return entries, scanner.Err()
+}
+
+// parseLogTime parses a strict ISO 8601 date from git log, in a time zone with its offset, the way parseIdent
+// does. Like there, a date git couldn't make sense of reads as the Unix epoch.
+func parseLogTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Unix(0, 0).UTC()
+	}
+	_, offset := t.Zone()
+	return t.In(time.FixedZone("", offset))
+}
+
+func (r *execRepo) resolveCommit(rev string) (string, bool, error) {
+	objs, err := r.pool.stat([]string{rev + "^{commit}"})
+	if err != nil {
+		return "", false, err
+	}
+	if objs[0].missing {
+		return "", false, nil
+	}
+	return objs[0].hash, true, nil
+}
+
+func (r *execRepo) symbolicRef(name string) (string, bool, error) {
+	output, err := r.git("symbolic-ref", "--quiet", name).Output()
+	if err != nil {
+		return "", false, nil
+	}
+	return strings.TrimSpace(string(output)), true, nil
+}
+
+func (r *execRepo) remoteURL(name string) (string, bool, error) {
+	output, err := r.git("remote", "get-url", name).Output()
+	if err != nil {
+		return "", false, nil
+	}
+	url := strings.TrimSpace(string(output))
+	return url, url != "", nil
+}
+
+func (r *execRepo) read(hashes []string) ([]gitObject, error) {
+	return r.pool.read(hashes)
+}
+
+func (r *execRepo) stream(hashes []string, handle streamHandler) ([]gitObject, error) {
+	return r.pool.stream(hashes, handle)
+}
+
+func (r *execRepo) dir() string    { return r.root }
+func (r *execRepo) gitDir() string { return r.common }
+
+func (r *execRepo) close() {
+	r.pool.close()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// generatedReason is why a blob's content looks generated, or notGenerated.
+type generatedReason uint8
+
+const (
+	notGenerated       generatedReason = iota
+	generatedDoNotEdit                 // A header like "Code generated by mockgen. DO NOT EDIT."
+	generatedMarker                    // @generated, as used by Meta's tools, Relay, and Cargo
+	generatedHeader                    // <auto-generated>, or a header like "This file is auto-generated by ..."
+	generatedBundle                    // A webpack bundle
+	generatedMinified                  // Very long lines, like minified JS or CSS
+
+	generatedReasons = iota // Number of reasons, for validating cached ones
+)
+
+func (r generatedReason) String() string {
+	switch r {
+	case generatedDoNotEdit:
+		return `"generated, DO NOT EDIT" header`
+	case generatedMarker:
+		return "@generated marker"
+	case generatedHeader:
+		return "auto-generated header"
+	case generatedBundle:
+		return "webpack bundle"
+	case generatedMinified:
+		return "minified"
+	}
+	return "not generated"
+}
+
+// skips reports whether a file in lang with this reason is skipped. Minified content is only skipped in
+// languages that get minified, so prose with a paragraph per line and one-line JSON fixtures still count.
+func (r generatedReason) skips(lang *languageDefinition) bool {
+	if r == generatedMinified {
+		return minifiedLanguages[lang.id]
+	}
+	return r != notGenerated
+}
+
+// minifiedLanguages are the languages whose files get minified for the web.
+var minifiedLanguages = map[string]bool{"javascript": true, "css": true, "html": true}
+
+const (
+	// generatedHeaderLen and generatedHeaderLines bound the start of a blob that's checked for markers.
+	generatedHeaderLen   = 4096
+	generatedHeaderLines = 10
+
+	// A blob counts as minified if it's at least minifiedMinSize bytes, and its lines average more than
+	// minifiedLineLength bytes. Hand-written code with a few long lines, and prose with a paragraph per line,
+	// stay well below that.
+	minifiedMinSize    = 4096
+	minifiedLineLength = 1000
+)
+
+// autoGeneratedRe matches the ways headers tend to say a file is generated, other than "DO NOT EDIT".
+var autoGeneratedRe = regexp.MustCompile(`(?i)<auto-?generated` +
+	`|\b(auto-?generated|auto generated|automatically generated|generated automatically) (by|from|using|with)\b` +
+	`|\b(this|the) (file|code|class) (is|was) (auto-?|automatically )?generated\b`)
+
+// detectGenerated checks the start of a blob for markers that say it's generated, and its size and line count
+// for minified code.
+func detectGenerated(header []byte, size, lines int) generatedReason {
+	header = header[:min(len(header), generatedHeaderLen)]
+	n := 0
+	for line := range bytes.Lines(header) {
+		if n++; n > generatedHeaderLines {
+			break
+		}
+		lower := bytes.ToLower(line)
+		switch {
+		case bytes.Contains(lower, []byte("generated")) && bytes.Contains(lower, []byte("do not edit")):
+			return generatedDoNotEdit
+		case bytes.Contains(line, []byte("@generated")):
+			return generatedMarker
+		case autoGeneratedRe.Match(line):
+			return generatedHeader
+		case bytes.Contains(line, []byte("webpackBootstrap")):
+			return generatedBundle
+		}
+	}
+	if size >= minifiedMinSize && size > lines*minifiedLineLength {
+		return generatedMinified
+	}
+	return notGenerated
+}
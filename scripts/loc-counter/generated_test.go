@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectGenerated(t *testing.T) {
+	minified := "!function(e){" + strings.Repeat("var a=1;", 600) + "}();\n"
+	tests := []struct {
+		name    string
+		content string
+		want    generatedReason
+	}{
+		{"sqlc", "// Code generated by sqlc. DO NOT EDIT.\n// versions:\n//   sqlc v1.25.0\n\npackage db\n", generatedDoNotEdit},
+		{"mockgen", "// Code generated by MockGen. DO NOT EDIT.\n// Source: api.go\n", generatedDoNotEdit},
+		{"stringer", "// Code generated by \"stringer -type=Pill\"; DO NOT EDIT.\n\npackage painkiller\n", generatedDoNotEdit},
+		{"python", "#!/usr/bin/env python\n# -*- coding: utf-8 -*-\n# Generated by the protocol buffer compiler.  DO NOT EDIT!\n", generatedDoNotEdit},
+		{"openapi", "/* tslint:disable */\n/**\n * Petstore\n *\n * NOTE: This class is auto generated by OpenAPI Generator (https://openapi-generator.tech).\n * Do not edit the class manually.\n */\n", generatedHeader},
+		{".NET", "//------------------------------------------------------------------------------\n// <auto-generated>\n//     This code was generated by a tool.\n", generatedHeader},
+		{"@generated", "/**\n * @generated SignedSource<<abc>>\n */\n", generatedMarker},
+		{"webpack", "/******/ (() => { // webpackBootstrap\n/******/ \tvar __webpack_modules__ = ({\n", generatedBundle},
+		{"minified", minified, generatedMinified},
+		{"hand-written", "package ids\n\n// New returns an ID, like the ones generated by the server.\n", notGenerated},
+		{"marker too far down", strings.Repeat("x := 1\n", 10) + "// Code generated by hand. DO NOT EDIT.\n", notGenerated},
+		{"long prose", strings.Repeat(strings.Repeat("A paragraph that goes on for a while. ", 25)+"\n\n", 5), notGenerated},
+		{"short one-liner", strings.Repeat("a", 1000), notGenerated},
+	}
+
+	scanner := &blobScanner{chunk: make([]byte, 256)} // Smaller than the header, so it spans chunks
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scan, err := scanner.scan(strings.NewReader(tt.content), len(tt.content), 0, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if scan.generated != tt.want {
+				t.Errorf("generated = %v, want %v", scan.generated, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratedReasonSkips(t *testing.T) {
+	tests := []struct {
+		reason generatedReason
+		lang   string
+		want   bool
+	}{
+		{generatedDoNotEdit, "go", true},
+		{generatedDoNotEdit, "docs", true},
+		{generatedMinified, "javascript", true},
+		{generatedMinified, "css", true},
+		{generatedMinified, "docs", false},
+		{generatedMinified, "config", false},
+		{notGenerated, "javascript", false},
+	}
+	for _, tt := range tests {
+		if got := tt.reason.skips(languageByID(tt.lang)); got != tt.want {
+			t.Errorf("%v.skips(%s) = %v, want %v", tt.reason, tt.lang, got, tt.want)
+		}
+	}
+}
@@ -1,231 +1,348 @@
 package main
 
 import (
-	"bufio"
+	"container/heap"
 	"fmt"
-	"io"
-	"os/exec"
-	"strconv"
-	"strings"
+	"slices"
+	"sort"
+	"time"
 )
 
-// hasSkippedDirComponent checks if any path component is a vendored/generated directory.
-func hasSkippedDirComponent(path string) bool {
-	for part := range strings.SplitSeq(path, "/") {
-		if shouldSkipDir(part) {
-			return true
-		}
-	}
-	return false
-}
-
 type commit struct {
-	hash     string
-	date     string
-	messages []string
+	hash string
+	// date is the day the commit is bucketed by, as YYYY-MM-DD. It's never before its parents' dates, see
+	// getCommits.
+	date          string
+	authorTime    time.Time
+	committerTime time.Time
+	messages      []string
+	authors       []string // Normalized "Name <email>", without duplicates
+	parents       []string
+	// base is the commit a bucket's changes are measured from: the previous bucket's commit, or for the
+	// first bucket, the parent of its oldest commit. Empty if the history starts in that bucket.
+	base string
+	// commits lists every commit of the bucket, oldest first. Only set by groupCommits.
+	commits []commit
+	// merged lists the commits a merge brought into the first-parent history, newest first. Only set with
+	// historyOptions.firstParent.
+	merged []commit
 }
 
-// repoRoot caches the git top-level directory so all commands run from the repo root.
-var repoRoot string
-
-func findRepoRoot() (string, error) {
-	if repoRoot != "" {
-		return repoRoot, nil
+// firstParent returns the commit's first parent, or empty for a root commit.
+func (c commit) firstParent() string {
+	if len(c.parents) == 0 {
+		return ""
 	}
-	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to find git repo root: %w", err)
-	}
-	repoRoot = strings.TrimSpace(string(output))
-	return repoRoot, nil
+	return c.parents[0]
 }
 
-func gitCommand(args ...string) *exec.Cmd {
-	root, err := findRepoRoot()
-	if err != nil {
-		// Fall back to running without -C
-		return exec.Command("git", args...)
-	}
-	fullArgs := append([]string{"-C", root}, args...)
-	return exec.Command("git", fullArgs...)
+// historyOptions says which commits getCommits lists, and how.
+type historyOptions struct {
+	dates dateOptions
+	// firstParent lists only the first-parent history from the tip, like git log --first-parent, so each
+	// bucket is counted at the mainline as it was, rather than at a commit on a branch merged later.
+	firstParent bool
+	markMerges  bool // Start the message of merge commits with mergeMarker
 }
 
-func getCommits() ([]commit, error) {
-	cmd := gitCommand("log", "--format=%H|%cd|%s", "--date=short", "main")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to run git log: %w", err)
-	}
+// mergeMarker starts the message of merge commits with historyOptions.markMerges.
+const mergeMarker = "[merge] "
 
-	var commits []commit
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
+// String describes the options for checkpoint keys.
+func (o historyOptions) String() string {
+	return fmt.Sprintf("dates=%s first-parent=%t mark-merges=%t", o.dates, o.firstParent, o.markMerges)
+}
 
-		parts := strings.SplitN(line, "|", 3)
-		if len(parts) != 3 {
-			continue
-		}
+// dateOptions says which of a commit's dates to bucket it by, and in which time zone.
+type dateOptions struct {
+	author   bool           // Use the author date instead of the committer date
+	location *time.Location // Take dates in this time zone, or in each commit's own if nil
+}
 
-		commits = append(commits, commit{
-			hash:     parts[0],
-			date:     parts[1],
-			messages: []string{parts[2]},
-		})
+// String describes the options for checkpoint keys.
+func (o dateOptions) String() string {
+	source, zone := "committer", "own"
+	if o.author {
+		source = "author"
 	}
-
-	return commits, scanner.Err()
+	if o.location != nil {
+		zone = o.location.String()
+	}
+	return source + " " + zone
 }
 
-// groupCommitsByDate keeps the latest commit hash per day but collects all messages.
-func groupCommitsByDate(commits []commit) map[string]commit {
-	dailyCommits := make(map[string]commit)
-
-	for _, c := range commits {
-		existing, ok := dailyCommits[c.date]
-		if !ok {
-			// First commit for this date (latest chronologically since git log is reverse order)
-			dailyCommits[c.date] = c
-		} else {
-			existing.messages = append(existing.messages, c.messages...)
-			dailyCommits[c.date] = existing
+// parseDateOptions parses --date (author or committer) and --timezone (UTC, an IANA name like Europe/Berlin,
+// or empty for each commit's own time zone).
+func parseDateOptions(source, zone string) (dateOptions, error) {
+	var opts dateOptions
+	switch source {
+	case "author":
+		opts.author = true
+	case "committer":
+	default:
+		return opts, fmt.Errorf("unknown date %q (expected author or committer)", source)
+	}
+	if zone != "" {
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			return opts, fmt.Errorf("unknown time zone %q: %w", zone, err)
 		}
+		opts.location = location
 	}
-
-	return dailyCommits
+	return opts, nil
 }
 
-type fileEntry struct {
-	path string
-	blob string // blob SHA for use with cat-file --batch
+// day returns the date of t as YYYY-MM-DD, in the time zone of opts.
+func (o dateOptions) day(t time.Time) string {
+	if o.location != nil {
+		t = t.In(o.location)
+	}
+	return t.Format(time.DateOnly)
 }
 
-func getFilesAtCommit(commitHash string) ([]fileEntry, error) {
-	cmd := gitCommand("ls-tree", "-r", commitHash)
-	output, err := cmd.Output()
+// getCommits lists commits reachable from rev (a ref or A..B range), newest first, as opts says. Authors are
+// normalized with mm, which may be nil.
+//
+// Author dates, and committer dates with skewed clocks, can go backwards from parent to child. A commit dated
+// before one of its parents is dated like its latest parent instead, so a bucket's last commit is never an
+// ancestor of an earlier bucket's. Commits are ordered like git log --date-order: newest first, but never
+// before any of their children.
+func getCommits(repo repository, rev string, mm *mailmap, opts historyOptions) ([]commit, error) {
+	entries, err := repo.log(rev)
 	if err != nil {
-		return nil, fmt.Errorf("failed to run git ls-tree: %w", err)
+		return nil, err
 	}
-
-	var files []fileEntry
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-		// Format: "<mode> <type> <hash>\t<path>"
-		tabIdx := strings.IndexByte(line, '\t')
-		if tabIdx < 0 {
-			continue
-		}
-		meta := line[:tabIdx]
-		path := line[tabIdx+1:]
-		parts := strings.Fields(meta)
-		if len(parts) < 3 || parts[1] != "blob" {
-			continue // skip trees/submodules
+	commits := make([]commit, len(entries))
+	for i, e := range entries {
+		commits[i] = commit{
+			hash:          e.hash,
+			authorTime:    e.authorTime,
+			committerTime: e.committerTime,
+			messages:      []string{e.subject},
+			authors:       []string{mm.lookup(e.authorName, e.authorEmail)},
+			parents:       e.parents,
 		}
-		if hasSkippedDirComponent(path) {
-			continue
+		if opts.markMerges && len(e.parents) > 1 {
+			commits[i].messages[0] = mergeMarker + e.subject
 		}
-		files = append(files, fileEntry{path: path, blob: parts[2]})
 	}
-
-	return files, scanner.Err()
+	if opts.firstParent && len(commits) > 0 {
+		commits = firstParentHistory(commits)
+	}
+	return dateCommits(commits, opts.dates), nil
 }
 
-// batchGetFileContents fetches all blob contents in a single git cat-file --batch process.
-// Returns a map from blob hash to file content. Blobs that fail to read (binary/missing) are omitted.
-func batchGetFileContents(blobs []string) (map[string]string, error) {
-	if len(blobs) == 0 {
-		return nil, nil
+// firstParentHistory returns the commits on the first-parent chain from the tip, which git log lists first.
+// Every other commit goes in the merged list of the oldest chain commit it's reachable from, and its author
+// in that commit's authors, so lines are still attributed to whoever wrote them.
+func firstParentHistory(commits []commit) []commit {
+	index := make(map[string]int, len(commits))
+	for i, c := range commits {
+		index[c.hash] = i
+	}
+	var chain []int
+	claimed := make([]bool, len(commits))
+	for i, ok := 0, true; ok; i, ok = index[commits[i].firstParent()] {
+		chain = append(chain, i)
+		claimed[i] = true
 	}
 
-	root, err := findRepoRoot()
-	if err != nil {
-		return nil, err
+	// Oldest first, so the commits a merge reaches through the mainline are already claimed
+	history := make([]commit, len(chain))
+	for n, i := range slices.Backward(chain) {
+		c := commits[i]
+		var merged []int
+		stack := slices.Clone(c.parents)
+		for len(stack) > 0 {
+			hash := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if j, ok := index[hash]; ok && !claimed[j] {
+				claimed[j] = true
+				merged = append(merged, j)
+				stack = append(stack, commits[j].parents...)
+			}
+		}
+		slices.Sort(merged) // Back into git log order
+		for _, j := range merged {
+			c.merged = append(c.merged, commits[j])
+			for _, author := range commits[j].authors {
+				if !slices.Contains(c.authors, author) {
+					c.authors = append(c.authors, author)
+				}
+			}
+		}
+		history[n] = c
 	}
+	return history
+}
 
-	cmd := exec.Command("git", "-C", root, "cat-file", "--batch")
-	cmd.Stderr = nil
+// dateCommits sorts commits, given newest first in git log order, into date order, and sets their dates. See
+// getCommits.
+func dateCommits(commits []commit, dates dateOptions) []commit {
+	index := make(map[string]int, len(commits))
+	for i, c := range commits {
+		index[c.hash] = i
+	}
+	when := func(i int) time.Time {
+		if dates.author {
+			return commits[i].authorTime
+		}
+		return commits[i].committerTime
+	}
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	// Children first: a commit is ready once all of its children in the list are out
+	children := make([]int, len(commits))
+	for _, c := range commits {
+		for _, parent := range c.parents {
+			if p, ok := index[parent]; ok {
+				children[p]++
+			}
+		}
+	}
+	queue := &dateQueue{when: when}
+	for i := range commits {
+		if children[i] == 0 {
+			heap.Push(queue, i)
+		}
+	}
+	order := make([]int, 0, len(commits))
+	for queue.Len() > 0 {
+		i := heap.Pop(queue).(int)
+		order = append(order, i)
+		for _, parent := range commits[i].parents {
+			if p, ok := index[parent]; ok {
+				if children[p]--; children[p] == 0 {
+					heap.Push(queue, p)
+				}
+			}
+		}
 	}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	// Parents first, so each commit's parents are dated before it
+	for _, i := range slices.Backward(order) {
+		c := &commits[i]
+		c.date = dates.day(when(i))
+		for _, parent := range c.parents {
+			if p, ok := index[parent]; ok && commits[p].date > c.date {
+				c.date = commits[p].date
+			}
+		}
 	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start cat-file: %w", err)
+	sorted := make([]commit, len(order))
+	for n, i := range order {
+		sorted[n] = commits[i]
 	}
+	return sorted
+}
 
-	// Write all blob hashes to stdin in a goroutine to avoid deadlock
-	go func() {
-		for _, blob := range blobs {
-			fmt.Fprintln(stdin, blob)
-		}
-		stdin.Close()
-	}()
+// dateQueue is a max-heap of indexes into a commit list by date, with ties going to the earlier index.
+type dateQueue struct {
+	items []int
+	when  func(i int) time.Time
+}
 
-	// Read responses: each is "<hash> <type> <size>\n<content of size bytes>\n"
-	// or "<hash> missing\n"
-	reader := bufio.NewReaderSize(stdout, 256*1024)
-	contents := make(map[string]string, len(blobs))
+func (q *dateQueue) Len() int { return len(q.items) }
+func (q *dateQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if ta, tb := q.when(a), q.when(b); !ta.Equal(tb) {
+		return ta.After(tb)
+	}
+	return a < b
+}
+func (q *dateQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+func (q *dateQueue) Push(x any)    { q.items = append(q.items, x.(int)) }
+func (q *dateQueue) Pop() any {
+	x := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return x
+}
 
-	for range blobs {
-		header, err := reader.ReadString('\n')
-		if err != nil {
-			break
-		}
-		header = strings.TrimRight(header, "\n")
+// groupCommits buckets commits by g, keeping the latest commit hash per bucket but collecting all messages,
+// authors, and commits, including the ones merged into a first-parent history. commits must be newest first,
+// as getCommits lists them. Returns the buckets keyed by label, and their labels oldest first.
+func groupCommits(commits []commit, g granularity) (map[string]commit, []string) {
+	buckets := make(map[string]commit)
+	var labels []string
 
-		if strings.HasSuffix(header, " missing") {
-			continue
+	for _, c := range commits {
+		label := g.label(c)
+		existing, ok := buckets[label]
+		if !ok {
+			// First commit for this bucket, so the latest, since commits are in date order newest first
+			bucket := c
+			bucket.commits = append([]commit{c}, c.merged...)
+			buckets[label] = bucket
+			labels = append(labels, label)
+		} else {
+			existing.commits = append(existing.commits, c)
+			existing.commits = append(existing.commits, c.merged...)
+			existing.messages = append(existing.messages, c.messages...)
+			for _, author := range c.authors {
+				if !slices.Contains(existing.authors, author) {
+					existing.authors = append(existing.authors, author)
+				}
+			}
+			buckets[label] = existing
 		}
+	}
 
-		fields := strings.Fields(header)
-		if len(fields) < 3 {
-			continue
+	if g == granularityCommit {
+		slices.Reverse(labels) // Commit labels don't sort by time, so keep git log's order
+	} else {
+		sort.Strings(labels)
+	}
+	for i, label := range labels {
+		c := buckets[label]
+		slices.Reverse(c.commits)
+		if i > 0 {
+			c.base = buckets[labels[i-1]].hash
+		} else {
+			c.base = commits[len(commits)-1].firstParent()
 		}
+		buckets[label] = c
+	}
 
-		hash := fields[0]
-		size, err := strconv.Atoi(fields[2])
-		if err != nil {
-			continue
-		}
+	return buckets, labels
+}
 
-		// Read exactly 'size' bytes of content + trailing newline
-		buf := make([]byte, size+1) // +1 for the trailing LF
-		if _, err := io.ReadFull(reader, buf); err != nil {
-			break
-		}
+type fileEntry struct {
+	path string
+	blob string // blob SHA for use with cat-file --batch
+}
 
-		content := string(buf[:size])
-		// Skip likely-binary content (contains null bytes in the first chunk)
-		if strings.ContainsRune(content[:min(len(content), 8000)], '\x00') {
-			continue
-		}
-		contents[hash] = content
+// getFilesAtCommit lists all files in a commit's tree. Submodules, skipped directories, and files outside
+// scope other than .gitattributes files are left out.
+func getFilesAtCommit(repo repository, scope *pathScope, commitHash string) ([]fileEntry, error) {
+	tree, err := commitTree(repo, commitHash)
+	if err != nil {
+		return nil, err
 	}
+	return listTreeFiles(repo, scope, tree, "")
+}
 
-	// Drain and wait; ignore exit errors (broken pipe if we stop reading early)
-	_ = cmd.Wait()
-
-	return contents, nil
+// fileChange is one file that differs between two commits.
+type fileChange struct {
+	path    string
+	blob    string // New blob SHA, empty if deleted
+	oldBlob string // Old blob SHA, empty if added
+	deleted bool
 }
 
-func countLines(content string) int {
-	lines := strings.Count(content, "\n")
-	if len(content) > 0 && !strings.HasSuffix(content, "\n") {
-		lines++
+// getChangedFiles lists files added, modified, or deleted between two commits by diffing their trees.
+// An empty fromHash means an empty tree. Renames show up as a delete plus an add. Submodules, skipped
+// directories, and files outside scope other than .gitattributes files are left out.
+func getChangedFiles(repo repository, scope *pathScope, fromHash, toHash string) ([]fileChange, error) {
+	fromTree := ""
+	if fromHash != "" {
+		var err error
+		if fromTree, err = commitTree(repo, fromHash); err != nil {
+			return nil, err
+		}
+	}
+	toTree, err := commitTree(repo, toHash)
+	if err != nil {
+		return nil, err
 	}
-	return lines
+	return diffTrees(repo, scope, fromTree, toTree, "", nil)
 }
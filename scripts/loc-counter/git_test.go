@@ -0,0 +1,194 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDateOptions(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	tests := []struct {
+		source, zone string
+		want         dateOptions
+		wantErr      bool
+	}{
+		{"committer", "", dateOptions{}, false},
+		{"author", "UTC", dateOptions{author: true, location: time.UTC}, false},
+		{"committer", "Europe/Berlin", dateOptions{location: berlin}, false},
+		{"commit", "", dateOptions{}, true},
+		{"author", "Mars/Olympus_Mons", dateOptions{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDateOptions(tt.source, tt.zone)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDateOptions(%q, %q) error = %v, wantErr %v", tt.source, tt.zone, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (got.author != tt.want.author || got.String() != tt.want.String()) {
+			t.Errorf("parseDateOptions(%q, %q) = %v, want %v", tt.source, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestDateCommits(t *testing.T) {
+	at := func(value string) time.Time {
+		t.Helper()
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	// newest first, as git log lists them, with the author date first and the committer date second
+	type entry struct {
+		hash, author, committer string
+		parents                 []string
+	}
+	tests := []struct {
+		name      string
+		entries   []entry
+		dates     dateOptions
+		wantOrder []string
+		wantDates []string
+	}{
+		{
+			name: "author date going backwards is clamped",
+			entries: []entry{
+				{"c", "2024-01-01T10:00:00Z", "2024-01-06T10:00:00Z", []string{"b"}}, // Rebased from an old branch
+				{"b", "2024-01-05T10:00:00Z", "2024-01-05T10:00:00Z", []string{"a"}},
+				{"a", "2024-01-03T10:00:00Z", "2024-01-03T10:00:00Z", nil},
+			},
+			dates:     dateOptions{author: true},
+			wantOrder: []string{"c", "b", "a"},
+			wantDates: []string{"2024-01-05", "2024-01-05", "2024-01-03"},
+		},
+		{
+			name: "committer date",
+			entries: []entry{
+				{"c", "2024-01-01T10:00:00Z", "2024-01-06T10:00:00Z", []string{"b"}},
+				{"b", "2024-01-05T10:00:00Z", "2024-01-05T10:00:00Z", []string{"a"}},
+				{"a", "2024-01-03T10:00:00Z", "2024-01-03T10:00:00Z", nil},
+			},
+			wantOrder: []string{"c", "b", "a"},
+			wantDates: []string{"2024-01-06", "2024-01-05", "2024-01-03"},
+		},
+		{
+			name: "branches interleave by date",
+			entries: []entry{
+				{"m", "2024-01-09T10:00:00Z", "2024-01-09T10:00:00Z", []string{"x", "y"}},
+				{"x", "2024-01-04T10:00:00Z", "2024-01-04T10:00:00Z", []string{"x0"}},
+				{"x0", "2024-01-02T10:00:00Z", "2024-01-02T10:00:00Z", []string{"r"}},
+				{"y", "2024-01-05T10:00:00Z", "2024-01-05T10:00:00Z", []string{"y0"}},
+				{"y0", "2024-01-03T10:00:00Z", "2024-01-03T10:00:00Z", []string{"r"}},
+				{"r", "2024-01-01T10:00:00Z", "2024-01-01T10:00:00Z", nil},
+			},
+			wantOrder: []string{"m", "y", "x", "y0", "x0", "r"},
+			wantDates: []string{"2024-01-09", "2024-01-05", "2024-01-04", "2024-01-03", "2024-01-02", "2024-01-01"},
+		},
+		{
+			name: "merge of a branch dated in the future",
+			entries: []entry{
+				{"m", "2024-01-03T10:00:00Z", "2024-01-03T10:00:00Z", []string{"a", "f"}},
+				{"f", "2024-02-01T10:00:00Z", "2024-02-01T10:00:00Z", []string{"r"}}, // Skewed clock
+				{"a", "2024-01-02T10:00:00Z", "2024-01-02T10:00:00Z", []string{"r"}},
+				{"r", "2024-01-01T10:00:00Z", "2024-01-01T10:00:00Z", nil},
+			},
+			wantOrder: []string{"m", "f", "a", "r"},
+			wantDates: []string{"2024-02-01", "2024-02-01", "2024-01-02", "2024-01-01"},
+		},
+		{
+			name: "own time zone",
+			entries: []entry{
+				{"b", "2024-01-01T23:30:00-05:00", "2024-01-01T23:30:00-05:00", []string{"a"}},
+				{"a", "2024-01-01T09:00:00+09:00", "2024-01-01T09:00:00+09:00", nil},
+			},
+			wantOrder: []string{"b", "a"},
+			wantDates: []string{"2024-01-01", "2024-01-01"},
+		},
+		{
+			name: "UTC",
+			entries: []entry{
+				{"b", "2024-01-01T23:30:00-05:00", "2024-01-01T23:30:00-05:00", []string{"a"}},
+				{"a", "2024-01-01T09:00:00+09:00", "2024-01-01T09:00:00+09:00", nil},
+			},
+			dates:     dateOptions{location: time.UTC},
+			wantOrder: []string{"b", "a"},
+			wantDates: []string{"2024-01-02", "2024-01-01"},
+		},
+		{
+			name: "parents outside the range",
+			entries: []entry{
+				{"b", "2024-01-02T10:00:00Z", "2024-01-02T10:00:00Z", []string{"a"}},
+				{"a", "2024-01-01T10:00:00Z", "2024-01-01T10:00:00Z", []string{"excluded"}},
+			},
+			wantOrder: []string{"b", "a"},
+			wantDates: []string{"2024-01-02", "2024-01-01"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commits := make([]commit, len(tt.entries))
+			for i, e := range tt.entries {
+				commits[i] = commit{hash: e.hash, authorTime: at(e.author), committerTime: at(e.committer), parents: e.parents}
+			}
+			var order, dates []string
+			for _, c := range dateCommits(commits, tt.dates) {
+				order = append(order, c.hash)
+				dates = append(dates, c.date)
+			}
+			if !reflect.DeepEqual(order, tt.wantOrder) {
+				t.Errorf("order = %v, want %v", order, tt.wantOrder)
+			}
+			if !reflect.DeepEqual(dates, tt.wantDates) {
+				t.Errorf("dates = %v, want %v", dates, tt.wantDates)
+			}
+		})
+	}
+}
+
+func TestFirstParentHistory(t *testing.T) {
+	// main: r - a - m1 - b - m2, where m1 merges x1 - x2 (branched from r, and merging a back in on the way),
+	// and m2 merges y (branched from m1)
+	c := func(hash, author string, parents ...string) commit {
+		return commit{hash: hash, authors: []string{author}, parents: parents}
+	}
+	commits := []commit{
+		c("m2", "Maintainer", "b", "y"),
+		c("y", "Yan", "m1"),
+		c("b", "Maintainer", "m1"),
+		c("m1", "Maintainer", "a", "x2"),
+		c("x2", "Xi", "x1", "a"),
+		c("a", "Maintainer", "r"),
+		c("x1", "Xi", "r"),
+		c("r", "Maintainer", "outside"),
+	}
+
+	type summary struct {
+		hash    string
+		merged  []string
+		authors []string
+	}
+	var got []summary
+	for _, c := range firstParentHistory(commits) {
+		s := summary{hash: c.hash, authors: c.authors}
+		for _, m := range c.merged {
+			s.merged = append(s.merged, m.hash)
+		}
+		got = append(got, s)
+	}
+	want := []summary{
+		{"m2", []string{"y"}, []string{"Maintainer", "Yan"}},
+		{"b", nil, []string{"Maintainer"}},
+		{"m1", []string{"x2", "x1"}, []string{"Maintainer", "Xi"}},
+		{"a", nil, []string{"Maintainer"}},
+		{"r", nil, []string{"Maintainer"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("firstParentHistory() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// gitattributesFile is the name of the files that set attributes for the files in and below their directory.
+const gitattributesFile = ".gitattributes"
+
+// attrState is what a .gitattributes rule says about one attribute.
+type attrState int8
+
+const (
+	attrNotMentioned attrState = iota // The rule doesn't mention it, so rules with lower precedence decide
+	attrTrue                          // "attr", "attr=true", or any other value
+	attrFalse                         // "-attr" or "attr=false"
+	attrUnspecified                   // "!attr", which resets it to unspecified
+)
+
+// linguistAttributes are the attributes GitHub's linguist uses to override its own detection.
+type linguistAttributes struct {
+	generated     attrState
+	vendored      attrState
+	documentation attrState
+}
+
+// unexcluded reports whether the file is marked as not generated or not vendored, which overrides the built-in
+// skip patterns.
+func (a linguistAttributes) unexcluded() bool {
+	return a.generated == attrFalse || a.vendored == attrFalse
+}
+
+// markedNotGenerated reports whether the file is marked as not generated, which overrides content-based
+// detection.
+func (a linguistAttributes) markedNotGenerated() bool {
+	return a.generated == attrFalse
+}
+
+// attributeRule is a line of a .gitattributes file that sets linguist attributes.
+type attributeRule struct {
+	pattern []string // Path components, relative to the .gitattributes file's directory
+	attrs   linguistAttributes
+}
+
+// parseGitattributes parses a .gitattributes file, keeping only the rules that set linguist attributes.
+// Lines git would reject, like negative patterns, are ignored the way git ignores them.
+func parseGitattributes(text string) []attributeRule {
+	var rules []attributeRule
+	for line := range strings.Lines(text) {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || strings.HasPrefix(line, "[attr]") {
+			continue
+		}
+		pattern, rest, ok := cutAttributePattern(line)
+		if !ok || strings.HasPrefix(pattern, "!") {
+			continue
+		}
+		var attrs linguistAttributes
+		for _, field := range strings.Fields(rest) {
+			state := attrTrue
+			switch field[0] {
+			case '-':
+				state, field = attrFalse, field[1:]
+			case '!':
+				state, field = attrUnspecified, field[1:]
+			}
+			name, value, hasValue := strings.Cut(field, "=")
+			if hasValue && state == attrTrue && value == "false" {
+				state = attrFalse
+			}
+			switch name {
+			case "linguist-generated":
+				attrs.generated = state
+			case "linguist-vendored":
+				attrs.vendored = state
+			case "linguist-documentation":
+				attrs.documentation = state
+			}
+		}
+		if attrs == (linguistAttributes{}) {
+			continue
+		}
+		// Patterns that end in a slash only match directories, and attributes of directories don't apply to
+		// the files in them
+		if strings.HasSuffix(pattern, "/") {
+			continue
+		}
+		if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		rules = append(rules, attributeRule{pattern: strings.Split(strings.TrimPrefix(pattern, "/"), "/"), attrs: attrs})
+	}
+	return rules
+}
+
+// cutAttributePattern splits a line into its pattern, which may be quoted, and the attributes after it.
+func cutAttributePattern(line string) (pattern, rest string, ok bool) {
+	if line[0] != '"' {
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			return line[:i], line[i:], true
+		}
+		return line, "", true
+	}
+	quoted, err := strconv.QuotedPrefix(line)
+	if err != nil {
+		return "", "", false
+	}
+	pattern, err = strconv.Unquote(quoted)
+	return pattern, line[len(quoted):], err == nil && pattern != ""
+}
+
+// matchAttributePattern reports whether pattern matches the path, both split into components. Unlike the
+// --include and --exclude globs, a pattern that matches a directory doesn't match the files in it. ** matches
+// any number of components, but at least one at the end of a pattern.
+func matchAttributePattern(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		if len(pattern) == 1 {
+			return len(parts) > 0
+		}
+		return matchAttributePattern(pattern[1:], parts) || (len(parts) > 0 && matchAttributePattern(pattern, parts[1:]))
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], parts[0])
+	return ok && matchAttributePattern(pattern[1:], parts[1:])
+}
+
+// gitAttributes holds the linguist rules of every .gitattributes file in a tree, keyed by the directory it's
+// in ("" for the root). A nil gitAttributes has no rules.
+type gitAttributes map[string][]attributeRule
+
+// linguist returns the linguist attributes of the file at filePath. Like in git, rules in deeper directories
+// win over those further up, and later rules in a file win over earlier ones.
+func (a gitAttributes) linguist(filePath string) linguistAttributes {
+	var result linguistAttributes
+	if len(a) == 0 {
+		return result
+	}
+	parts := strings.Split(filePath, "/")
+	for depth := len(parts) - 1; depth >= 0; depth-- {
+		rules := a[strings.Join(parts[:depth], "/")]
+		for i := len(rules) - 1; i >= 0; i-- {
+			if !matchAttributePattern(rules[i].pattern, parts[depth:]) {
+				continue
+			}
+			attrs := rules[i].attrs
+			result.generated = firstDecided(result.generated, attrs.generated)
+			result.vendored = firstDecided(result.vendored, attrs.vendored)
+			result.documentation = firstDecided(result.documentation, attrs.documentation)
+		}
+	}
+	return result
+}
+
+// firstDecided returns decided if a rule with higher precedence already decided the attribute, and next otherwise.
+func firstDecided(decided, next attrState) attrState {
+	if decided != attrNotMentioned {
+		return decided
+	}
+	return next
+}
+
+// loadGitattributes reads and parses the .gitattributes files in blobs, keyed by directory. Missing blobs
+// count as empty files.
+func loadGitattributes(repo repository, blobs map[string]string) (gitAttributes, error) {
+	if len(blobs) == 0 {
+		return nil, nil
+	}
+	var dirs, hashes []string
+	for dir, blob := range blobs {
+		dirs = append(dirs, dir)
+		hashes = append(hashes, blob)
+	}
+	objs, err := repo.read(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .gitattributes: %w", err)
+	}
+	attributes := make(gitAttributes, len(objs))
+	for i, obj := range objs {
+		if obj.missing {
+			continue
+		}
+		if rules := parseGitattributes(string(obj.data)); len(rules) > 0 {
+			attributes[dirs[i]] = rules
+		}
+	}
+	return attributes, nil
+}
+
+// attributesDir returns the directory of a .gitattributes file, and false if filePath isn't one.
+func attributesDir(filePath string) (string, bool) {
+	dir, name := path.Split(filePath)
+	if name != gitattributesFile {
+		return "", false
+	}
+	return strings.TrimSuffix(dir, "/"), true
+}
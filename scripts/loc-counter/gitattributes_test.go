@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestGitAttributes(t *testing.T) {
+	attributes := gitAttributes{
+		"": parseGitattributes(`# Root rules
+*.gen.go linguist-generated
+/dist/** linguist-vendored=true
+docs/** linguist-documentation
+*.go text eol=lf
+third_party/** linguist-vendored
+third_party/keep/** -linguist-vendored
+"with space.js" linguist-generated
+!negated linguist-generated
+build/ linguist-generated
+[attr]binary -diff -merge -text
+`),
+		"pkg": parseGitattributes(`*.gen.go -linguist-generated
+api/*.js	linguist-generated=false linguist-documentation
+docs/** !linguist-documentation
+`),
+	}
+	tests := []struct {
+		path string
+		want linguistAttributes
+	}{
+		{"main.go", linguistAttributes{}},
+		{"api.gen.go", linguistAttributes{generated: attrTrue}},
+		{"deep/in/tree/api.gen.go", linguistAttributes{generated: attrTrue}},
+		{"dist/app.js", linguistAttributes{vendored: attrTrue}},
+		{"src/dist/app.js", linguistAttributes{}}, // Patterns with a slash are anchored
+		{"docs/guide/intro.md", linguistAttributes{documentation: attrTrue}},
+		{"third_party/lib/a.c", linguistAttributes{vendored: attrTrue}},
+		{"third_party/keep/a.c", linguistAttributes{vendored: attrFalse}}, // Later lines win
+		{"with space.js", linguistAttributes{generated: attrTrue}},
+		{"negated", linguistAttributes{}},
+		{"build/out.js", linguistAttributes{}},                       // Directory patterns don't match the files in them
+		{"pkg/api.gen.go", linguistAttributes{generated: attrFalse}}, // Deeper .gitattributes files win
+		{"pkg/api/client.js", linguistAttributes{generated: attrFalse, documentation: attrTrue}},
+		{"pkg/api/v1/client.js", linguistAttributes{}}, // * doesn't match across directories
+		{"pkg/docs/a.md", linguistAttributes{documentation: attrUnspecified}},
+		{"pkg/dist/app.js", linguistAttributes{}},
+	}
+	for _, tt := range tests {
+		if got := attributes.linguist(tt.path); got != tt.want {
+			t.Errorf("linguist(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
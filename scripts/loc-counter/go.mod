@@ -0,0 +1,3 @@
+module gitstrata/scripts/loc-counter
+
+go 1.25
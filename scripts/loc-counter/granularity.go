@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// granularity is how commits are bucketed into snapshots. Each bucket is counted at its last commit.
+type granularity int
+
+const (
+	granularityDay granularity = iota // The zero value, so days are the default
+	granularityCommit
+	granularityWeek // ISO 8601 weeks, starting on Monday
+	granularityMonth
+	granularityQuarter
+)
+
+var granularityNames = []string{"day", "commit", "week", "month", "quarter"}
+
+func (g granularity) String() string {
+	return granularityNames[g]
+}
+
+// plural is the bucket name for messages, like "3 weeks".
+func (g granularity) plural() string {
+	return g.String() + "s"
+}
+
+// parseGranularity parses a --granularity value.
+func parseGranularity(name string) (granularity, error) {
+	for i, n := range granularityNames {
+		if n == name {
+			return granularity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown granularity %q (expected %s)", name, strings.Join(granularityNames, ", "))
+}
+
+// label returns the label of the bucket c falls in: its date for days, like 2024-W05, 2024-01, or 2024-Q1 for
+// weeks, months, and quarters, and its date and short hash for commits. Labels of time buckets sort in
+// chronological order.
+func (g granularity) label(c commit) string {
+	switch g {
+	case granularityDay:
+		return c.date
+	case granularityCommit:
+		return c.date + " " + c.hash[:min(len(c.hash), 12)]
+	}
+	day, err := time.Parse(time.DateOnly, c.date)
+	if err != nil {
+		return c.date // Dates come from git log, so this doesn't happen
+	}
+	return g.labelAt(day)
+}
+
+// labelAt returns the label of the time bucket that day falls in.
+func (g granularity) labelAt(day time.Time) string {
+	switch g {
+	case granularityWeek:
+		year, week := day.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case granularityMonth:
+		return day.Format("2006-01")
+	case granularityQuarter:
+		return fmt.Sprintf("%04d-Q%d", day.Year(), (int(day.Month())-1)/3+1)
+	}
+	return day.Format(time.DateOnly)
+}
+
+// labelsBetween returns the labels of every time bucket from the one startDate falls in to the one endDate
+// falls in, inclusive, so buckets without commits can be filled in. Dates are YYYY-MM-DD.
+func (g granularity) labelsBetween(startDate, endDate string) ([]string, error) {
+	start, err := time.Parse(time.DateOnly, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start date %q: %w", startDate, err)
+	}
+	end, err := time.Parse(time.DateOnly, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end date %q: %w", endDate, err)
+	}
+
+	// Step from the start of the first bucket, so the last one is reached even if end is early in it
+	step := func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	switch g {
+	case granularityWeek:
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+	case granularityMonth:
+		start = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+		step = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	case granularityQuarter:
+		start = time.Date(start.Year(), start.Month()-(start.Month()-1)%3, 1, 0, 0, 0, 0, time.UTC)
+		step = func(t time.Time) time.Time { return t.AddDate(0, 3, 0) }
+	}
+
+	var labels []string
+	for t := start; !t.After(end); t = step(t) {
+		labels = append(labels, g.labelAt(t))
+	}
+	return labels, nil
+}
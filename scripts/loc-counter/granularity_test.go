@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGranularityLabelsBetween(t *testing.T) {
+	tests := []struct {
+		name       string
+		g          granularity
+		start, end string
+		want       []string
+	}{
+		{"same day", granularityDay, "2024-06-15", "2024-06-15", []string{"2024-06-15"}},
+		{"month boundary", granularityDay, "2024-01-30", "2024-02-02", []string{"2024-01-30", "2024-01-31", "2024-02-01", "2024-02-02"}},
+		{"leap day", granularityDay, "2024-02-28", "2024-03-01", []string{"2024-02-28", "2024-02-29", "2024-03-01"}},
+		{"year boundary", granularityDay, "2023-12-31", "2024-01-01", []string{"2023-12-31", "2024-01-01"}},
+		// 2024-12-30 is in week 1 of 2025, and 2021-01-03 in week 53 of 2020
+		{"ISO weeks across years", granularityWeek, "2024-12-22", "2025-01-06", []string{"2024-W51", "2024-W52", "2025-W01", "2025-W02"}},
+		{"week 53", granularityWeek, "2020-12-31", "2021-01-04", []string{"2020-W53", "2021-W01"}},
+		{"end early in its week", granularityWeek, "2024-03-07", "2024-03-11", []string{"2024-W10", "2024-W11"}},
+		{"months", granularityMonth, "2023-11-30", "2024-02-01", []string{"2023-11", "2023-12", "2024-01", "2024-02"}},
+		{"quarters", granularityQuarter, "2023-12-31", "2024-07-01", []string{"2023-Q4", "2024-Q1", "2024-Q2", "2024-Q3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.g.labelsBetween(tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("labelsBetween() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("labelsBetween() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGranularity(t *testing.T) {
+	for i, name := range granularityNames {
+		if g, err := parseGranularity(name); err != nil || g != granularity(i) || g.String() != name {
+			t.Errorf("parseGranularity(%q) = %v, %v", name, g, err)
+		}
+	}
+	if _, err := parseGranularity("year"); err == nil {
+		t.Error("parseGranularity(\"year\") should fail")
+	}
+}
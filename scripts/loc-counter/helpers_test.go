@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// testRepo is a throwaway git repo for end-to-end tests.
+type testRepo struct {
+	t   *testing.T
+	dir string
+}
+
+// newTestRepo creates an empty repo with main as the initial branch.
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	r := &testRepo{t: t, dir: t.TempDir()}
+	r.git("init", "-q", "-b", "main")
+	return r
+}
+
+// open opens the repo with the given backend, and closes it when the test ends.
+func (r *testRepo) open(backend string) repository {
+	r.t.Helper()
+	repo, err := openRepo(r.dir, backend, 2)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	r.t.Cleanup(repo.close)
+	return repo
+}
+
+// git runs a git command in the repo and returns its trimmed stdout.
+func (r *testRepo) git(args ...string) string {
+	r.t.Helper()
+	return r.gitEnv(nil, args...)
+}
+
+func (r *testRepo) gitEnv(env []string, args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", r.dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=Test Author",
+		"GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_COMMITTER_NAME=Test Author",
+		"GIT_COMMITTER_EMAIL=author@example.com",
+	)
+	cmd.Env = append(cmd.Env, env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %v failed: %v\n%s", args, err, output)
+	}
+	return string(output)
+}
+
+// write creates or overwrites a file relative to the repo root.
+func (r *testRepo) write(path, content string) {
+	r.t.Helper()
+	full := filepath.Join(r.dir, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// commit stages everything and commits with both author and committer date set to date (RFC 3339).
+func (r *testRepo) commit(date, message string) {
+	r.t.Helper()
+	r.git("add", "-A")
+	r.gitEnv([]string{"GIT_AUTHOR_DATE=" + date, "GIT_COMMITTER_DATE=" + date},
+		"commit", "-q", "--allow-empty", "-m", message)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// jsonLanguageCount mirrors LanguageCount in src/lib/types.ts.
+type jsonLanguageCount struct {
+	Total   int  `json:"total"`
+	Prod    *int `json:"prod,omitempty"`
+	Test    *int `json:"test,omitempty"`
+	Code    int  `json:"code"`
+	Comment int  `json:"comment"`
+	Blank   int  `json:"blank"`
+}
+
+// jsonDayStats mirrors DayStats in src/lib/types.ts.
+type jsonDayStats struct {
+	Date      string                       `json:"date"`
+	Total     int                          `json:"total"`
+	Languages map[string]jsonLanguageCount `json:"languages"`
+	Comments  []string                     `json:"comments"`
+	Authors   []string                     `json:"authors"`
+	// Lines added and removed per language since the previous day, from line diffs
+	LanguageAdded   map[string]int `json:"languageAdded"`
+	LanguageRemoved map[string]int `json:"languageRemoved"`
+	// Net lines per author since the start of the history, and lines each author added and removed that day
+	Contributors       map[string]int `json:"contributors"`
+	ContributorAdded   map[string]int `json:"contributorAdded"`
+	ContributorRemoved map[string]int `json:"contributorRemoved"`
+}
+
+// jsonAnalysisResult mirrors AnalysisResult in src/lib/types.ts, so the output can be loaded into
+// the web app or uploaded to the shared cache.
+type jsonAnalysisResult struct {
+	RepoURL           string         `json:"repoUrl"`
+	DefaultBranch     string         `json:"defaultBranch"`
+	HeadCommit        string         `json:"headCommit"`
+	AnalyzedAt        string         `json:"analyzedAt"`
+	DetectedLanguages []string       `json:"detectedLanguages"`
+	Days              []jsonDayStats `json:"days"`
+	TotalContributors int            `json:"totalContributors"`
+}
+
+// resultMeta holds the repo-level fields of an analysis result.
+type resultMeta struct {
+	repoURL       string
+	defaultBranch string
+	headCommit    string
+	analyzedAt    time.Time
+}
+
+// buildAnalysisResult converts per-day stats into the web app's result format.
+// days must be parallel to dates, with gaps already carried forward.
+func buildAnalysisResult(meta resultMeta, dates []string, days []*fileStats) jsonAnalysisResult {
+	result := jsonAnalysisResult{
+		RepoURL:       meta.repoURL,
+		DefaultBranch: meta.defaultBranch,
+		HeadCommit:    meta.headCommit,
+		// Same format as JS Date.toISOString()
+		AnalyzedAt:        meta.analyzedAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+		DetectedLanguages: []string{},
+		Days:              make([]jsonDayStats, len(days)),
+	}
+	if len(days) > 0 {
+		// Like the web app, only languages present on the last day count as detected
+		result.DetectedLanguages = detectLanguages(days[len(days)-1:])
+	}
+
+	for i, stats := range days {
+		day := jsonDayStats{
+			Date:      dates[i],
+			Total:     stats.total,
+			Languages: jsonLanguages(stats.languages),
+			Comments:  stats.comments,
+			Authors:   stats.authors,
+
+			LanguageAdded:   stats.added,
+			LanguageRemoved: stats.removed,
+
+			Contributors:       stats.contributors,
+			ContributorAdded:   stats.contributorAdded,
+			ContributorRemoved: stats.contributorRemoved,
+		}
+		if day.Comments == nil {
+			day.Comments = []string{}
+		}
+		if day.Authors == nil {
+			day.Authors = []string{}
+		}
+		for _, m := range []*map[string]int{&day.LanguageAdded, &day.LanguageRemoved, &day.Contributors,
+			&day.ContributorAdded, &day.ContributorRemoved} {
+			if *m == nil {
+				*m = map[string]int{}
+			}
+		}
+		result.Days[i] = day
+	}
+	result.TotalContributors = countContributors(days)
+
+	return result
+}
+
+// jsonLanguages converts line counts per language, leaving out prod and test for languages without that split.
+func jsonLanguages(languages map[string]*languageCount) map[string]jsonLanguageCount {
+	result := make(map[string]jsonLanguageCount, len(languages))
+	for id, count := range languages {
+		lc := jsonLanguageCount{Total: count.total, Code: count.code, Comment: count.comment, Blank: count.blank}
+		if lang := languageByID(id); lang != nil && !lang.noTestSplit {
+			prod, test := count.prod, count.test
+			lc.Prod, lc.Test = &prod, &test
+		}
+		result[id] = lc
+	}
+	return result
+}
+
+// countContributors returns the number of distinct authors across all days.
+func countContributors(days []*fileStats) int {
+	seen := make(map[string]bool)
+	for _, stats := range days {
+		for _, author := range stats.authors {
+			seen[author] = true
+		}
+	}
+	return len(seen)
+}
+
+func writeJSON(w io.Writer, result jsonAnalysisResult) error {
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	return nil
+}
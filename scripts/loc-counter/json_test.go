@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRun_WritesAnalysisResultJSON(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.git("remote", "add", "origin", "git@github.com:Owner/Repo.git")
+	repo.write("src/app.ts", "export const a = 1\nexport const b = 2\n")
+	repo.write("src/app.test.ts", "test('a', () => {})\n")
+	repo.write("style.css", "body {}\n")
+	repo.commit("2024-03-01T10:00:00Z", "Initial")
+	repo.write("style.css", "body {}\na {}\nb {}\n")
+	repo.commit("2024-03-03T10:00:00Z", "More style")
+
+	out := filepath.Join(t.TempDir(), "result.json")
+	err := run(&cliFlags{repoPath: repo.dir, ref: "main", output: out, format: "json", workers: 2})
+	if err != nil {
+		t.Fatalf("run() error: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decode into a generic map to check the exact JSON shape the web app expects
+	var result map[string]any
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result["repoUrl"] != "https://github.com/owner/repo" {
+		t.Errorf("repoUrl = %v", result["repoUrl"])
+	}
+	if result["defaultBranch"] != "main" {
+		t.Errorf("defaultBranch = %v", result["defaultBranch"])
+	}
+	if head, _ := result["headCommit"].(string); len(head) != 40 {
+		t.Errorf("headCommit = %v", result["headCommit"])
+	}
+	if !reflect.DeepEqual(result["detectedLanguages"], []any{"css", "typescript"}) {
+		t.Errorf("detectedLanguages = %v", result["detectedLanguages"])
+	}
+
+	days := result["days"].([]any)
+	if len(days) != 3 {
+		t.Fatalf("got %d days, want 3", len(days))
+	}
+	first := days[0].(map[string]any)
+	expectedFirst := map[string]any{
+		"date":  "2024-03-01",
+		"total": float64(4),
+		"languages": map[string]any{
+			"typescript": map[string]any{"total": float64(3), "prod": float64(2), "test": float64(1),
+				"code": float64(3), "comment": float64(0), "blank": float64(0)},
+			"css": map[string]any{"total": float64(1), "code": float64(1), "comment": float64(0), "blank": float64(0)},
+		},
+		"comments":           []any{"Initial"},
+		"authors":            []any{"Test Author <author@example.com>"},
+		"languageAdded":      map[string]any{"typescript": float64(3), "css": float64(1)},
+		"languageRemoved":    map[string]any{},
+		"contributors":       map[string]any{"Test Author <author@example.com>": float64(4)},
+		"contributorAdded":   map[string]any{"Test Author <author@example.com>": float64(4)},
+		"contributorRemoved": map[string]any{"Test Author <author@example.com>": float64(0)},
+	}
+	if !reflect.DeepEqual(first, expectedFirst) {
+		t.Errorf("days[0] = %v, want %v", first, expectedFirst)
+	}
+	gap := days[1].(map[string]any)
+	if !reflect.DeepEqual(gap["comments"], []any{"-"}) || gap["total"] != float64(4) ||
+		!reflect.DeepEqual(gap["authors"], []any{}) {
+		t.Errorf("gap day = %v, want carried-forward total 4 with comments [-] and no authors", gap)
+	}
+	if result["totalContributors"] != float64(1) {
+		t.Errorf("totalContributors = %v, want 1", result["totalContributors"])
+	}
+}
+
+func TestRun_JSONNeedsDays(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("main.go", "package main\n")
+	repo.commit("2024-03-01T10:00:00Z", "Initial")
+
+	out := filepath.Join(t.TempDir(), "result.json")
+	err := run(&cliFlags{repoPath: repo.dir, ref: "main", output: out, format: "json", workers: 2, granularity: granularityWeek})
+	if err == nil {
+		t.Fatal("run() with --format json --granularity week succeeded, want an error")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("run() wrote %s, want no output", out)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// languageDefinition describes how to recognize a language and split its prod/test lines.
+// Mirrors LanguageDefinition in src/lib/types.ts.
+type languageDefinition struct {
+	id                   string
+	name                 string
+	extensions           []string
+	testFilePatterns     []string
+	testDirPatterns      []string // Replaces defaultTestDirPatterns if set
+	countInlineTestLines func(content []byte) int
+	noTestSplit          bool           // If true, all lines are counted as total only — no prod/test breakdown
+	comments             *commentSyntax // If nil, every line that isn't blank counts as code
+	isMeta               bool
+}
+
+// otherLanguageID is the catch-all for files with unrecognized extensions.
+const otherLanguageID = "other"
+
+// defaultTestDirPatterns lists directory names that indicate test code.
+var defaultTestDirPatterns = []string{
+	"test",
+	"tests",
+	"__tests__",
+	"__mocks__",
+	"__fixtures__",
+	"spec",
+	"e2e",
+	"testutil",
+	"testdata",
+}
+
+// languages lists all recognized languages, ordered by priority for extension conflicts.
+// Keep in sync with src/lib/languages.ts and shared/language-ids.ts.
+var languages = []languageDefinition{
+	{
+		id:               "python",
+		name:             "Python",
+		extensions:       []string{".py", ".pyw"},
+		testFilePatterns: []string{"test_*.py", "*_test.py", "conftest.py"},
+		comments: &commentSyntax{line: []string{"#"}, strings: []stringSyntax{
+			{start: `"""`, end: `"""`, multiline: true}, {start: "'''", end: "'''", multiline: true},
+			{start: `"`, end: `"`}, {start: "'", end: "'"},
+		}},
+	},
+	{
+		id:         "javascript",
+		name:       "JavaScript",
+		extensions: []string{".js", ".jsx", ".mjs", ".cjs"},
+		testFilePatterns: []string{
+			"*.test.js", "*.spec.js", "*.test.jsx", "*.spec.jsx",
+			"*.test.mjs", "*.spec.mjs", "*.test.cjs", "*.spec.cjs",
+		},
+		comments: jsSyntax,
+	},
+	{
+		id:         "typescript",
+		name:       "TypeScript",
+		extensions: []string{".ts", ".tsx", ".mts", ".cts"},
+		testFilePatterns: []string{
+			"*.test.ts", "*.spec.ts", "*.test.tsx", "*.spec.tsx",
+			"*.test.mts", "*.spec.mts", "*.test.cts", "*.spec.cts",
+		},
+		comments: jsSyntax,
+	},
+	{
+		id:                   "rust",
+		name:                 "Rust",
+		extensions:           []string{".rs"},
+		countInlineTestLines: countRustTestLines,
+		comments: &commentSyntax{line: cLineComments, block: cBlockComments, nested: true,
+			strings: []stringSyntax{{start: `"`, end: `"`, multiline: true}}, charQuotes: true},
+	},
+	{
+		id:               "go",
+		name:             "Go",
+		extensions:       []string{".go"},
+		testFilePatterns: []string{"*_test.go"},
+		comments: &commentSyntax{line: cLineComments, block: cBlockComments,
+			strings: append([]stringSyntax{{start: "`", end: "`", multiline: true, raw: true}}, cStrings...)},
+	},
+	{
+		id:               "c",
+		name:             "C",
+		extensions:       []string{".c"},
+		testFilePatterns: []string{"test_*.c", "*_test.c"},
+		comments:         cSyntax,
+	},
+	{
+		id:               "cpp",
+		name:             "C++",
+		extensions:       []string{".cpp", ".cc", ".cxx", ".hpp", ".hxx", ".hh"},
+		testFilePatterns: []string{"test_*.cpp", "*_test.cpp", "*_test.cc", "*_test.cxx"},
+		comments:         cSyntax,
+	},
+	{
+		id:               "csharp",
+		name:             "C#",
+		extensions:       []string{".cs"},
+		testFilePatterns: []string{"*Tests.cs", "*Test.cs"},
+		comments: &commentSyntax{line: cLineComments, block: cBlockComments, strings: append([]stringSyntax{
+			{start: `"""`, end: `"""`, multiline: true, raw: true}, {start: `@"`, end: `"`, multiline: true, raw: true},
+		}, cStrings...)},
+	},
+	{
+		id:               "java",
+		name:             "Java",
+		extensions:       []string{".java"},
+		testFilePatterns: []string{"*Test.java", "*Tests.java", "*IT.java"},
+		comments: &commentSyntax{line: cLineComments, block: cBlockComments,
+			strings: append([]stringSyntax{{start: `"""`, end: `"""`, multiline: true}}, cStrings...)},
+	},
+	{
+		id:               "kotlin",
+		name:             "Kotlin",
+		extensions:       []string{".kt", ".kts"},
+		testFilePatterns: []string{"*Test.kt", "*Tests.kt"},
+		comments: &commentSyntax{line: cLineComments, block: cBlockComments, nested: true,
+			strings: append([]stringSyntax{{start: `"""`, end: `"""`, multiline: true, raw: true}}, cStrings...)},
+	},
+	{
+		id:               "swift",
+		name:             "Swift",
+		extensions:       []string{".swift"},
+		testFilePatterns: []string{"*Tests.swift", "*Test.swift"},
+		comments: &commentSyntax{line: cLineComments, block: cBlockComments, nested: true, strings: []stringSyntax{
+			{start: `"""`, end: `"""`, multiline: true}, {start: `"`, end: `"`},
+		}},
+	},
+	{
+		id:               "objc",
+		name:             "Objective-C",
+		extensions:       []string{".m", ".mm"},
+		testFilePatterns: []string{"*Tests.m", "*Test.m"},
+		comments:         cSyntax,
+	},
+	{
+		id:                   "zig",
+		name:                 "Zig",
+		extensions:           []string{".zig"},
+		countInlineTestLines: countZigTestLines,
+		comments:             &commentSyntax{line: cLineComments, strings: cStrings},
+	},
+	{
+		id:               "ruby",
+		name:             "Ruby",
+		extensions:       []string{".rb"},
+		testFilePatterns: []string{"*_test.rb", "*_spec.rb"},
+		comments:         &commentSyntax{line: []string{"#"}, strings: cStrings},
+	},
+	{
+		id:               "php",
+		name:             "PHP",
+		extensions:       []string{".php"},
+		testFilePatterns: []string{"*Test.php"},
+		comments:         &commentSyntax{line: cLineComments, block: cBlockComments, strings: cStrings},
+	},
+	{
+		id:               "scala",
+		name:             "Scala",
+		extensions:       []string{".scala"},
+		testFilePatterns: []string{"*Test.scala", "*Spec.scala"},
+		comments: &commentSyntax{line: cLineComments, block: cBlockComments, nested: true, strings: []stringSyntax{
+			{start: `"""`, end: `"""`, multiline: true, raw: true}, {start: `"`, end: `"`},
+		}, charQuotes: true},
+	},
+	{
+		id:               "dart",
+		name:             "Dart",
+		extensions:       []string{".dart"},
+		testFilePatterns: []string{"*_test.dart"},
+		comments: &commentSyntax{line: cLineComments, block: cBlockComments, nested: true, strings: append([]stringSyntax{
+			{start: `"""`, end: `"""`, multiline: true}, {start: "'''", end: "'''", multiline: true},
+		}, cStrings...)},
+	},
+	{
+		id:               "elixir",
+		name:             "Elixir",
+		extensions:       []string{".ex", ".exs"},
+		testFilePatterns: []string{"*_test.exs"},
+		comments: &commentSyntax{line: []string{"#"}, strings: append([]stringSyntax{
+			{start: `"""`, end: `"""`, multiline: true}, {start: "'''", end: "'''", multiline: true},
+		}, cStrings...)},
+	},
+	{
+		id:               "haskell",
+		name:             "Haskell",
+		extensions:       []string{".hs"},
+		testFilePatterns: []string{"*Spec.hs"},
+		comments: &commentSyntax{line: []string{"--"}, block: []blockComment{{"{-", "-}"}}, nested: true,
+			strings: []stringSyntax{{start: `"`, end: `"`}}, charQuotes: true},
+	},
+	{
+		id:               "lua",
+		name:             "Lua",
+		extensions:       []string{".lua"},
+		testFilePatterns: []string{"*_test.lua", "*_spec.lua"},
+		comments: &commentSyntax{line: []string{"--"}, block: []blockComment{{"--[[", "]]"}}, strings: append([]stringSyntax{
+			{start: "[[", end: "]]", multiline: true, raw: true},
+		}, cStrings...)},
+	},
+	{
+		id:               "perl",
+		name:             "Perl",
+		extensions:       []string{".pl", ".pm", ".t"},
+		testFilePatterns: []string{"*.t"},
+		testDirPatterns:  []string{"test", "tests", "__tests__", "spec", "e2e", "testutil", "testdata", "t"},
+		comments:         &commentSyntax{line: []string{"#"}, strings: cStrings},
+	},
+	{
+		id:               "r",
+		name:             "R",
+		extensions:       []string{".r", ".R"},
+		testFilePatterns: []string{"test-*.R", "test_*.R"},
+		comments:         &commentSyntax{line: []string{"#"}, strings: cStrings},
+	},
+	{
+		id:         "julia",
+		name:       "Julia",
+		extensions: []string{".jl"},
+		comments: &commentSyntax{line: []string{"#"}, block: []blockComment{{"#=", "=#"}}, nested: true, strings: []stringSyntax{
+			{start: `"""`, end: `"""`, multiline: true}, {start: `"`, end: `"`},
+		}, charQuotes: true},
+	},
+	{
+		id:               "clojure",
+		name:             "Clojure",
+		extensions:       []string{".clj", ".cljs", ".cljc"},
+		testFilePatterns: []string{"*_test.clj", "*_test.cljs", "*_test.cljc"},
+		comments:         &commentSyntax{line: []string{";"}, strings: []stringSyntax{{start: `"`, end: `"`, multiline: true}}},
+	},
+	{
+		id:               "erlang",
+		name:             "Erlang",
+		extensions:       []string{".erl", ".hrl"},
+		testFilePatterns: []string{"*_SUITE.erl", "*_test.erl", "*_tests.erl"},
+		comments:         &commentSyntax{line: []string{"%"}, strings: cStrings},
+	},
+	{
+		id:               "ocaml",
+		name:             "OCaml",
+		extensions:       []string{".ml", ".mli"},
+		testFilePatterns: []string{"*_test.ml"},
+		comments: &commentSyntax{block: []blockComment{{"(*", "*)"}}, nested: true,
+			strings: []stringSyntax{{start: `"`, end: `"`, multiline: true}}, charQuotes: true},
+	},
+	{
+		id:               "fsharp",
+		name:             "F#",
+		extensions:       []string{".fs", ".fsx"},
+		testFilePatterns: []string{"*Tests.fs", "*Test.fs"},
+		comments: &commentSyntax{line: cLineComments, block: []blockComment{{"(*", "*)"}}, strings: []stringSyntax{
+			{start: `"""`, end: `"""`, multiline: true, raw: true}, {start: `"`, end: `"`},
+		}, charQuotes: true},
+	},
+	{
+		id:          "shell",
+		name:        "Shell",
+		extensions:  []string{".sh", ".bash", ".zsh"},
+		noTestSplit: true,
+		comments:    &commentSyntax{line: []string{"#"}, strings: cStrings},
+	},
+	{
+		id:               "powershell",
+		name:             "PowerShell",
+		extensions:       []string{".ps1", ".psm1"},
+		testFilePatterns: []string{"*.Tests.ps1"},
+		comments:         &commentSyntax{line: []string{"#"}, block: []blockComment{{"<#", "#>"}}, strings: cStrings},
+	},
+	{
+		id:          "html",
+		name:        "HTML",
+		extensions:  []string{".html", ".htm"},
+		noTestSplit: true,
+		comments:    &commentSyntax{block: htmlComments},
+	},
+	{
+		id:          "css",
+		name:        "CSS",
+		extensions:  []string{".css", ".scss", ".sass", ".less"},
+		noTestSplit: true,
+		comments:    &commentSyntax{line: cLineComments, block: cBlockComments, strings: cStrings},
+	},
+	{
+		id:          "sql",
+		name:        "SQL",
+		extensions:  []string{".sql"},
+		noTestSplit: true,
+		comments:    &commentSyntax{line: []string{"--"}, block: cBlockComments, strings: cStrings},
+	},
+	{
+		id:          "svelte",
+		name:        "Svelte",
+		extensions:  []string{".svelte"},
+		noTestSplit: true,
+		comments:    componentSyntax,
+	},
+	{
+		id:          "vue",
+		name:        "Vue",
+		extensions:  []string{".vue"},
+		noTestSplit: true,
+		comments:    componentSyntax,
+	},
+	{
+		id:          "astro",
+		name:        "Astro",
+		extensions:  []string{".astro"},
+		noTestSplit: true,
+		comments:    componentSyntax,
+	},
+	{
+		id:          "docs",
+		name:        "Docs",
+		extensions:  []string{".md", ".mdx", ".rst", ".txt", ".adoc"},
+		noTestSplit: true,
+		isMeta:      true,
+	},
+	{
+		id:          "config",
+		name:        "Config/Data",
+		extensions:  []string{".json", ".yaml", ".yml", ".toml", ".xml", ".ini"},
+		noTestSplit: true,
+		isMeta:      true,
+	},
+}
+
+// otherLanguage is the definition used for unrecognized files. It keeps the prod/test split
+// (based on test directories only) like "other" in the web app.
+var otherLanguage = &languageDefinition{id: otherLanguageID, name: "Other"}
+
+// extensionToLanguage maps lowercase extensions to languages. `.h` is assigned to C by default.
+var extensionToLanguage = buildExtensionToLanguageMap()
+
+func buildExtensionToLanguageMap() map[string]*languageDefinition {
+	m := make(map[string]*languageDefinition)
+	for i := range languages {
+		lang := &languages[i]
+		for _, ext := range lang.extensions {
+			ext = strings.ToLower(ext)
+			if _, ok := m[ext]; !ok {
+				m[ext] = lang
+			}
+		}
+	}
+	m[".h"] = languageByID("c")
+	return m
+}
+
+// languageByID returns the registered language with the given id, or otherLanguage for "other".
+// Returns nil for unknown ids.
+func languageByID(id string) *languageDefinition {
+	if id == otherLanguageID {
+		return otherLanguage
+	}
+	for i := range languages {
+		if languages[i].id == id {
+			return &languages[i]
+		}
+	}
+	return nil
+}
+
+// resolveHeaderLanguage returns the extension map to use for a tree, based on extMap. If the tree has C++
+// sources, `.h` files are counted as C++ instead of C.
+func resolveHeaderLanguage(extMap map[string]*languageDefinition, extensions map[string]bool) map[string]*languageDefinition {
+	if !extensions[".cpp"] && !extensions[".cc"] && !extensions[".cxx"] {
+		return extMap
+	}
+	m := maps.Clone(extMap)
+	m[".h"] = languageByID("cpp")
+	return m
+}
+
+// languageMap picks the language of files and finds their tests, with a repo's config applied. A nil map uses
+// the built-in rules.
+type languageMap struct {
+	extensions  map[string]*languageDefinition // extensionToLanguage with the config's extensions
+	fixedHeader bool                           // Whether the config maps .h, so it's not resolved per tree
+	testDirs    []string                       // Test directory names on top of each language's
+}
+
+// newLanguageMap applies config's extensions and test dirs. Returns nil if it has neither.
+func newLanguageMap(config *repoConfig) *languageMap {
+	if config == nil || (len(config.languages) == 0 && len(config.testDirs) == 0) {
+		return nil
+	}
+	m := &languageMap{extensions: maps.Clone(extensionToLanguage), testDirs: config.testDirs}
+	for ext, id := range config.languages {
+		m.extensions[ext] = languageByID(id)
+	}
+	_, m.fixedHeader = config.languages[".h"]
+	return m
+}
+
+// extensionMap returns the extension map to use before the tree is known, with `.h` as C.
+func (m *languageMap) extensionMap() map[string]*languageDefinition {
+	if m == nil {
+		return extensionToLanguage
+	}
+	return m.extensions
+}
+
+// forTree returns the extension map to use for a tree with the given extensions, with `.h` resolved.
+func (m *languageMap) forTree(extensions map[string]bool) map[string]*languageDefinition {
+	if m != nil && m.fixedHeader {
+		return m.extensions
+	}
+	return resolveHeaderLanguage(m.extensionMap(), extensions)
+}
+
+// extraTestDirs returns the test directory names to check on top of each language's.
+func (m *languageMap) extraTestDirs() []string {
+	if m == nil {
+		return nil
+	}
+	return m.testDirs
+}
+
+// fileExtension returns the lowercase extension of a path, or "" for dotfiles and extensionless names.
+func fileExtension(path string) string {
+	base := filepath.Base(path)
+	dotIdx := strings.LastIndexByte(base, '.')
+	if dotIdx <= 0 {
+		return ""
+	}
+	return strings.ToLower(base[dotIdx:])
+}
+
+// isTestFilename checks if a basename matches any of the given test file patterns.
+func isTestFilename(base string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, base); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isInTestDir checks if a file lives under any of the given test directories.
+// Uses defaultTestDirPatterns if dirPatterns is nil.
+func isInTestDir(file string, dirPatterns []string) bool {
+	if dirPatterns == nil {
+		dirPatterns = defaultTestDirPatterns
+	}
+	dir := filepath.Dir(file)
+	if dir == "." {
+		return false
+	}
+	for part := range strings.SplitSeq(dir, "/") {
+		if slices.Contains(dirPatterns, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestLanguageIDsMatchSharedList(t *testing.T) {
+	// shared/language-ids.ts is the source of truth for ids accepted by the web app and the shared cache
+	data, err := os.ReadFile("../../shared/language-ids.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := make(map[string]bool)
+	for _, m := range regexp.MustCompile(`(?m)^\s+'([a-z]+)',$`).FindAllStringSubmatch(string(data), -1) {
+		shared[m[1]] = true
+	}
+	if len(shared) == 0 {
+		t.Fatal("found no language ids in shared/language-ids.ts")
+	}
+
+	registered := map[string]bool{otherLanguageID: true}
+	for _, lang := range languages {
+		registered[lang.id] = true
+		if !shared[lang.id] {
+			t.Errorf("%q is in languages but missing from shared/language-ids.ts", lang.id)
+		}
+	}
+	for id := range shared {
+		if !registered[id] {
+			t.Errorf("%q is in shared/language-ids.ts but not in languages", id)
+		}
+	}
+}
+
+func TestLanguageForFileAndTestLines(t *testing.T) {
+	tests := []struct {
+		path          string
+		wantLanguage  string
+		wantTestLines int
+	}{
+		{"src/app.py", "python", 0},
+		{"src/test_app.py", "python", 10},
+		{"pkg/server_test.go", "go", 10},
+		{"pkg/server.go", "go", 0},
+		{"src/Foo.java", "java", 0},
+		{"src/FooTest.java", "java", 10},
+		{"lib/thing_spec.rb", "ruby", 10},
+		{"src/lib/url.ts", "typescript", 0},
+		{"src/lib/url.test.ts", "typescript", 10},
+		{"tests/helpers.ts", "typescript", 10},
+		{"t/basic.pl", "perl", 10},
+		{"README.md", "docs", 0},
+		{"LICENSE", "docs", 0},
+		{"tests/fixtures.yaml", "config", 0},
+		{"scripts/build.sh", "shell", 0},
+		{"Makefile", "other", 0},
+		{"testdata/blob.bin", "other", 10},
+		{"include/thing.h", "c", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			lang := languageForFile(tt.path, extensionToLanguage)
+			if lang.id != tt.wantLanguage {
+				t.Errorf("languageForFile(%q) = %s, want %s", tt.path, lang.id, tt.wantLanguage)
+			}
+			if got := countTestLines(tt.path, 10, 0, lang, nil); got != tt.wantTestLines {
+				t.Errorf("countTestLines(%q) = %d, want %d", tt.path, got, tt.wantTestLines)
+			}
+		})
+	}
+}
+
+func TestResolveHeaderLanguage(t *testing.T) {
+	if lang := resolveHeaderLanguage(extensionToLanguage, map[string]bool{".c": true, ".h": true})[".h"]; lang.id != "c" {
+		t.Errorf(".h without C++ sources = %s, want c", lang.id)
+	}
+	if lang := resolveHeaderLanguage(extensionToLanguage, map[string]bool{".cc": true, ".h": true})[".h"]; lang.id != "cpp" {
+		t.Errorf(".h with C++ sources = %s, want cpp", lang.id)
+	}
+	if extensionToLanguage[".h"].id != "c" {
+		t.Error("resolveHeaderLanguage must not modify the shared extension map")
+	}
+}
+
+func TestCountZigTestLines(t *testing.T) {
+	content := `const std = @import("std");
+
+pub fn add(a: i32, b: i32) i32 {
+    return a + b;
+}
+
+test "add" {
+    try std.testing.expect(add(1, 2) == 3);
+}
+`
+	if got := countZigTestLines([]byte(content)); got != 3 {
+		t.Errorf("countZigTestLines() = %d, want 3", got)
+	}
+}
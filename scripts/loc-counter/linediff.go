@@ -0,0 +1,108 @@
+package main
+
+import "bytes"
+
+// lineDiff is the number of lines added and removed between two versions of a file.
+type lineDiff struct {
+	added   int
+	removed int
+}
+
+// maxDiffCost bounds the work diffLines spends on one pair of files, in line comparisons. Past it, the
+// diff is estimated instead.
+const maxDiffCost = 50_000_000
+
+// diffLines counts the lines added and removed between old and new, like `git diff --numstat` does:
+// a changed line counts as one removed and one added. Lines are split the same way countLines counts them.
+func diffLines(old, new []byte) lineDiff {
+	// Give every distinct line an id, so the diff compares ints instead of strings
+	ids := make(map[string]int)
+	a := lineIDs(old, ids)
+	b := lineIDs(new, ids)
+
+	// Common prefixes and suffixes are cheap to skip, and are most of a typical change
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return lineDiff{added: len(b), removed: len(a)}
+	}
+
+	maxEdits := maxDiffCost / (len(a) + len(b))
+	edits, ok := myersDistance(a, b, min(maxEdits, len(a)+len(b)))
+	if !ok {
+		return estimateLineDiff(a, b)
+	}
+	// edits = added + removed, and len(a) - len(b) = removed - added
+	return lineDiff{added: (edits - len(a) + len(b)) / 2, removed: (edits + len(a) - len(b)) / 2}
+}
+
+func lineIDs(content []byte, ids map[string]int) []int {
+	content = bytes.TrimSuffix(content, []byte("\n"))
+	if len(content) == 0 {
+		return nil
+	}
+	var result []int
+	for line := range bytes.SplitSeq(content, []byte("\n")) {
+		id, ok := ids[string(line)]
+		if !ok {
+			id = len(ids)
+			ids[string(line)] = id
+		}
+		result = append(result, id)
+	}
+	return result
+}
+
+// myersDistance returns the length of the shortest edit script between a and b (the number of lines
+// inserted plus deleted), using Myers' O(ND) algorithm. Gives up if it's more than maxEdits.
+func myersDistance(a, b []int, maxEdits int) (int, bool) {
+	n, m := len(a), len(b)
+	offset := maxEdits + 1
+	// v[offset+k] is the furthest x reached on diagonal k = x - y
+	v := make([]int, 2*maxEdits+3)
+	for d := 0; d <= maxEdits; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Insertion
+			} else {
+				x = v[offset+k-1] + 1 // Deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return d, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// estimateLineDiff treats the lines of each side as a multiset: lines only in a are removed, lines only
+// in b are added. Moved lines count as unchanged, so this can only underestimate the real diff.
+func estimateLineDiff(a, b []int) lineDiff {
+	counts := make(map[int]int)
+	for _, id := range a {
+		counts[id]++
+	}
+	var diff lineDiff
+	for _, id := range b {
+		if counts[id] > 0 {
+			counts[id]--
+		} else {
+			diff.added++
+		}
+	}
+	for _, n := range counts {
+		diff.removed += n
+	}
+	return diff
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		expected lineDiff
+	}{
+		{"identical", "a\nb\n", "a\nb\n", lineDiff{}},
+		{"added file", "", "a\nb\n", lineDiff{added: 2}},
+		{"deleted file", "a\nb\n", "", lineDiff{removed: 2}},
+		{"appended", "a\nb\n", "a\nb\nc\n", lineDiff{added: 1}},
+		{"changed line", "a\nb\nc\n", "a\nB\nc\n", lineDiff{added: 1, removed: 1}},
+		{"net zero churn", "a\nb\nc\nd\n", "a\nx\ny\nd\n", lineDiff{added: 2, removed: 2}},
+		{"moved line", "a\nb\nc\n", "b\nc\na\n", lineDiff{added: 1, removed: 1}},
+		{"no trailing newline", "a\nb", "a\nb\n", lineDiff{}},
+		{"interleaved", "a\nb\nc\nd\ne\n", "a\nc\nx\ne\ny\n", lineDiff{added: 2, removed: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines([]byte(tt.old), []byte(tt.new)); got != tt.expected {
+				t.Errorf("diffLines() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDiffLines_EstimatesHugeRewrites(t *testing.T) {
+	var old, new strings.Builder
+	for i := range 20_000 {
+		old.WriteString("old " + strings.Repeat("x", i%7) + "\n")
+		new.WriteString("new " + strings.Repeat("y", i%5) + "\n")
+	}
+	// Every line differs, so the estimate is exact
+	got := diffLines([]byte(old.String()), []byte(new.String()))
+	if got != (lineDiff{added: 20_000, removed: 20_000}) {
+		t.Errorf("diffLines() = %+v, want 20000 added and removed", got)
+	}
+}
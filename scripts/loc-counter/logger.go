@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// logger prints progress and warnings to stderr. In batch mode, each repo gets its own logger whose lines are
+// prefixed with the repo's name, and day-by-day progress is left out since several repos run at once. A nil
+// logger prints nothing, which is what tests use. Safe for concurrent use.
+type logger struct {
+	prefix   string
+	progress bool // Whether to print the "Processed n/m days" line
+	verbose  bool // Whether to print details, like why each skipped file was skipped
+
+	mu      sync.Mutex
+	midLine bool            // Whether a progress line is on screen without a newline after it
+	printed map[string]bool // Keys of the details and one-time warnings printed so far
+}
+
+func newLogger(prefix string, progress, verbose bool) *logger {
+	return &logger{prefix: prefix, progress: progress, verbose: verbose, printed: make(map[string]bool)}
+}
+
+// printf prints a line. The format shouldn't end in a newline.
+func (l *logger) printf(format string, args ...any) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.endLine()
+	fmt.Fprintf(os.Stderr, l.prefix+format+"\n", args...)
+}
+
+// detailf prints a line in verbose mode. Details with the same key are only printed once, since workers run
+// into the same files on many days.
+func (l *logger) detailf(key, format string, args ...any) {
+	if l == nil || !l.verbose {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.printed[key] {
+		return
+	}
+	l.printed[key] = true
+	l.endLine()
+	fmt.Fprintf(os.Stderr, l.prefix+format+"\n", args...)
+}
+
+// warnf prints a warning line.
+func (l *logger) warnf(format string, args ...any) {
+	l.printf("Warning: "+format, args...)
+}
+
+// warnOncef prints a warning line, only once for warnings with the same key, like detailf.
+func (l *logger) warnOncef(key, format string, args ...any) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.printed[key] {
+		return
+	}
+	l.printed[key] = true
+	l.endLine()
+	fmt.Fprintf(os.Stderr, l.prefix+"Warning: "+format+"\n", args...)
+}
+
+// progressf prints a progress line that replaces the previous one.
+func (l *logger) progressf(format string, args ...any) {
+	if l == nil || !l.progress {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "\r"+l.prefix+format, args...)
+	l.midLine = true
+}
+
+// endProgress moves past the progress line, if one is on screen.
+func (l *logger) endProgress() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.endLine()
+}
+
+func (l *logger) endLine() {
+	if l.midLine {
+		fmt.Fprintln(os.Stderr)
+		l.midLine = false
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// mailmapEntry is one line of a .mailmap file. Mirrors MailmapEntry in src/lib/git/mailmap.ts.
+type mailmapEntry struct {
+	properName  string // Empty if only the email is mapped
+	properEmail string // Empty if only the name is mapped
+	commitEmail string
+	commitName  string // Only set for "Proper Name <proper@email> Commit Name <commit@email>" lines
+}
+
+var mailmapEmailRe = regexp.MustCompile(`<([^>]+)>`)
+
+// parseMailmap parses a .mailmap file the same way the web app does. Lines it doesn't understand are skipped.
+func parseMailmap(content string) []mailmapEntry {
+	var entries []mailmapEntry
+	for raw := range strings.SplitSeq(content, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		emails := mailmapEmailRe.FindAllStringSubmatchIndex(line, -1)
+		switch {
+		case len(emails) == 0:
+			continue
+		case len(emails) == 1:
+			// Proper Name <commit@email>. A bare <email> isn't a valid line.
+			if name := strings.TrimSpace(line[:emails[0][0]]); name != "" {
+				entries = append(entries, mailmapEntry{properName: name, commitEmail: line[emails[0][2]:emails[0][3]]})
+			}
+			continue
+		}
+
+		// <proper@email> <commit@email>, Proper Name <proper@email> <commit@email>,
+		// or Proper Name <proper@email> Commit Name <commit@email>. Any name can be empty.
+		entries = append(entries, mailmapEntry{
+			properName:  strings.TrimSpace(line[:emails[0][0]]),
+			properEmail: line[emails[0][2]:emails[0][3]],
+			commitName:  strings.TrimSpace(line[emails[0][1]:emails[1][0]]),
+			commitEmail: line[emails[1][2]:emails[1][3]],
+		})
+	}
+	return entries
+}
+
+// mailmap normalizes commit authors to "Name <email>", resolving aliases from .mailmap.
+type mailmap struct {
+	byEmail map[string][]mailmapEntry // Keyed by lowercase commit email
+}
+
+func newMailmap(entries []mailmapEntry) *mailmap {
+	m := &mailmap{byEmail: make(map[string][]mailmapEntry)}
+	for _, e := range entries {
+		key := strings.ToLower(e.commitEmail)
+		m.byEmail[key] = append(m.byEmail[key], e)
+	}
+	return m
+}
+
+// lookup returns the normalized "Name <email>" for a commit author. Entries matching both name and email
+// win over entries matching only the email, and among those, entries with a proper email win over
+// name-only ones.
+func (m *mailmap) lookup(name, email string) string {
+	if m == nil {
+		return formatAuthor(name, email)
+	}
+	candidates := m.byEmail[strings.ToLower(email)]
+
+	for _, e := range candidates {
+		if e.commitName != "" && strings.EqualFold(e.commitName, name) {
+			return e.resolve(name, email)
+		}
+	}
+
+	var best *mailmapEntry
+	for i, e := range candidates {
+		if e.commitName != "" {
+			continue
+		}
+		if best == nil || (e.properEmail != "" && best.properEmail == "") {
+			best = &candidates[i]
+		}
+	}
+	if best != nil {
+		return best.resolve(name, email)
+	}
+	return formatAuthor(name, email)
+}
+
+func (e mailmapEntry) resolve(name, email string) string {
+	if e.properName != "" {
+		name = e.properName
+	}
+	if e.properEmail != "" {
+		email = e.properEmail
+	}
+	return formatAuthor(name, email)
+}
+
+func formatAuthor(name, email string) string {
+	return name + " <" + email + ">"
+}
+
+// readMailmap reads .mailmap from the root of the tree at commitHash. Returns an empty mailmap if there
+// isn't one.
+func readMailmap(repo repository, commitHash string) (*mailmap, error) {
+	tree, err := commitTree(repo, commitHash)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readTrees(repo, []string{tree})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries[0] {
+		if e.name != ".mailmap" || e.isTree() || e.isSubmodule() {
+			continue
+		}
+		objs, err := repo.read([]string{e.hash})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read .mailmap: %w", err)
+		}
+		if objs[0].missing {
+			break
+		}
+		return newMailmap(parseMailmap(string(objs[0].data))), nil
+	}
+	return newMailmap(nil), nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMailmapLookup(t *testing.T) {
+	mm := newMailmap(parseMailmap(`# Comment
+Jane Doe <jane@old.example>
+<jane@example.com> <jane@work.example>
+Jane Doe <jane@example.com> <JANE@personal.example>
+Bob Smith <bob@example.com> bob <bob@shared.example>
+<ci@example.com> CI Bot <bob@shared.example>
+<ignored@example.com>
+Jane Name Only <jane@both.example>
+<jane@example.com> <jane@both.example>
+`))
+
+	tests := []struct {
+		name, email string
+		expected    string
+	}{
+		{"Jane", "jane@old.example", "Jane Doe <jane@old.example>"},
+		{"Jane", "jane@work.example", "Jane <jane@example.com>"},
+		{"J", "jane@Personal.Example", "Jane Doe <jane@example.com>"},
+		{"BOB", "bob@shared.example", "Bob Smith <bob@example.com>"},
+		{"CI Bot", "bob@shared.example", "CI Bot <ci@example.com>"},
+		{"Someone", "bob@shared.example", "Someone <bob@shared.example>"},
+		{"Jane", "jane@both.example", "Jane <jane@example.com>"}, // Proper email wins over name-only
+		{"Ignored", "ignored@example.com", "Ignored <ignored@example.com>"},
+		{"Unknown", "unknown@example.com", "Unknown <unknown@example.com>"},
+	}
+	for _, tt := range tests {
+		if got := mm.lookup(tt.name, tt.email); got != tt.expected {
+			t.Errorf("lookup(%q, %q) = %q, want %q", tt.name, tt.email, got, tt.expected)
+		}
+	}
+}
+
+func TestGetCommits_NormalizesAuthorsWithMailmap(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write(".mailmap", "Test Author <author@example.com> <old@example.com>\n")
+	repo.commit("2024-01-01T10:00:00Z", "First")
+	repo.gitEnv([]string{"GIT_AUTHOR_NAME=Old Name", "GIT_AUTHOR_EMAIL=old@example.com",
+		"GIT_AUTHOR_DATE=2024-01-01T11:00:00Z", "GIT_COMMITTER_DATE=2024-01-01T11:00:00Z"},
+		"commit", "-q", "--allow-empty", "-m", "Second")
+	repo.gitEnv([]string{"GIT_AUTHOR_NAME=Other | Person", "GIT_AUTHOR_EMAIL=other@example.com",
+		"GIT_AUTHOR_DATE=2024-01-01T12:00:00Z", "GIT_COMMITTER_DATE=2024-01-01T12:00:00Z"},
+		"commit", "-q", "--allow-empty", "-m", "Third | with pipes")
+
+	r := repo.open(backendExec)
+	head := strings.TrimSpace(repo.git("rev-parse", "HEAD"))
+	mm, err := readMailmap(r, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits, err := getCommits(r, "HEAD", mm, historyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daily, _ := groupCommits(commits, granularityDay)
+	day := daily["2024-01-01"]
+	if !reflect.DeepEqual(day.messages, []string{"Third | with pipes", "Second", "First"}) {
+		t.Errorf("messages = %q", day.messages)
+	}
+	expected := []string{"Other | Person <other@example.com>", "Test Author <author@example.com>"}
+	if !reflect.DeepEqual(day.authors, expected) {
+		t.Errorf("authors = %q, want %q", day.authors, expected)
+	}
+}
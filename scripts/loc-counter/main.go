@@ -0,0 +1,529 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// cliFlags holds the parsed command-line flags.
+type cliFlags struct {
+	batchFile string // The list of repos to analyze, in batch mode
+	outDir    string // Where batch mode writes each repo's output
+	repoPath  string // A local path, or a URL to clone
+	cloneDir  string // Where to mirror remote repos, empty for the default
+	ref       string
+	output    string
+	format    string
+	repoURL   string
+	authors   string // Where to write per-author lines per day, if anywhere
+	include   []string
+	exclude   []string
+	groupFile string   // Where to write lines per directory or component per day, if anywhere
+	depth     int      // Directory depth to group by
+	groups    []string // Named components as NAME=GLOB, to group by instead of directories
+	config    string   // Config file to use instead of the repo's own, if any
+	backend   string   // How to read the repo: auto, exec, or native
+	workers   int
+	noCache   bool
+	noResume  bool
+	maxBlob   int // Largest blob in bytes to buffer for inline test counting, 0 for the default
+	verbose   bool
+	// How commits are bucketed into rows: one per commit, or the last commit of each day, week, month, or quarter
+	granularity granularity
+	history     historyOptions // Which commits to list, and which date to bucket them by
+}
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func main() {
+	flags, err := parseFlags(os.Args[1:])
+	if err == nil && flags == nil {
+		return // Help was shown
+	}
+	if err == nil {
+		if flags.batchFile != "" {
+			err = runBatch(flags)
+		} else {
+			err = run(flags)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseFlags parses command-line arguments, with "batch FILE" in front for batch mode. Returns nil if help
+// was shown.
+func parseFlags(args []string) (*cliFlags, error) {
+	batch := len(args) > 0 && args[0] == "batch"
+	if batch {
+		args = args[1:]
+	}
+
+	fs := flag.NewFlagSet("loc-counter", flag.ExitOnError)
+	fs.Usage = showUsage
+	var (
+		repoPath = fs.String("repo", ".", "Path or URL of the git repository to analyze")
+		cloneDir = fs.String("clone-dir", "", "Where to keep clones of remote repos (default: the user cache dir)")
+		ref      = fs.String("ref", "", "Branch, tag, commit, or A..B range to analyze (default: the repo's default branch)")
+		output   = fs.String("o", "", "Write output to this file instead of stdout")
+		outDir   = fs.String("out-dir", ".", "Where batch mode writes each repo's output")
+		format   = fs.String("format", "csv", "Output format: csv or json (AnalysisResult, as used by the web app)")
+		repoURL  = fs.String("repo-url", "", "Repo URL to record in JSON output (default: the origin remote)")
+		authors  = fs.String("contributors-csv", "", "Also write per-author line counts per day to this CSV file")
+		include  stringList
+		exclude  stringList
+		groups   stringList
+		group    = fs.String("breakdown-csv", "", "Also write line counts per directory or component per day to this CSV file")
+		depth    = fs.Int("breakdown-depth", 1, "Directory depth to break counts down by")
+		config   = fs.String("config", "", "Read settings from this file instead of the repo's .gitstrata.yml or .gitstrata.toml")
+		backend  = fs.String("backend", backendAuto, "How to read the repo: exec (the git binary), native (pure Go), or auto")
+		workers  = fs.Int("workers", runtime.NumCPU(), "Number of parallel workers")
+		noCache  = fs.Bool("no-cache", false, "Don't read or write the on-disk blob cache")
+		noResume = fs.Bool("no-checkpoint", false, "Don't resume from or save checkpoints")
+		maxBuf   = fs.Int("max-blob-buffer", defaultMaxBufferedBlob>>20, "Largest file in MiB to buffer for inline test detection")
+		gran     = fs.String("granularity", "day", "One row per commit, day, week, month, or quarter")
+		date     = fs.String("date", "committer", "Bucket commits by their author or committer date")
+		timezone = fs.String("timezone", "", "Time zone to bucket dates in, like UTC or Europe/Berlin (default: each commit's own)")
+		firstPar = fs.Bool("first-parent", false, "Follow only the first parent of merges, so each row is the mainline as it was")
+		merges   = fs.Bool("mark-merges", false, "Start the messages of merge commits with "+mergeMarker)
+		verbose  = fs.Bool("verbose", false, "Print why each skipped file is skipped")
+		v        = fs.Bool("v", false, "Same as --verbose")
+		help     = fs.Bool("help", false, "Show help message")
+		h        = fs.Bool("h", false, "Show help message")
+	)
+	fs.Var(&include, "include", "Only count files matching this glob (repeatable)")
+	fs.Var(&exclude, "exclude", "Don't count files matching this glob (repeatable)")
+	fs.Var(&groups, "component", "Break counts down by named components, given as NAME=GLOB (repeatable)")
+	fs.Parse(args)
+
+	if *help || *h {
+		showUsage()
+		return nil, nil
+	}
+
+	granularity, err := parseGranularity(*gran)
+	if err != nil {
+		return nil, err
+	}
+	dates, err := parseDateOptions(*date, *timezone)
+	if err != nil {
+		return nil, err
+	}
+	if *maxBuf < 1 {
+		return nil, fmt.Errorf("--max-blob-buffer must be at least 1 MiB, got %d", *maxBuf)
+	}
+
+	flags := &cliFlags{
+		outDir:    *outDir,
+		repoPath:  *repoPath,
+		cloneDir:  *cloneDir,
+		ref:       *ref,
+		output:    *output,
+		format:    *format,
+		repoURL:   *repoURL,
+		authors:   *authors,
+		include:   include,
+		exclude:   exclude,
+		groupFile: *group,
+		depth:     *depth,
+		groups:    groups,
+		config:    *config,
+		backend:   *backend,
+		workers:   max(*workers, 1),
+		noCache:   *noCache,
+		noResume:  *noResume,
+		maxBlob:   *maxBuf << 20,
+		verbose:   *verbose || *v,
+
+		granularity: granularity,
+		history:     historyOptions{dates: dates, firstParent: *firstPar, markMerges: *merges},
+	}
+
+	if !batch {
+		if fs.NArg() > 0 {
+			return nil, fmt.Errorf("unexpected argument %q (see --help)", fs.Arg(0))
+		}
+		return flags, nil
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("batch mode takes one file listing the repos to analyze (see --help)")
+	}
+	flags.batchFile = fs.Arg(0)
+	// These are per repo, so they come from the list file, if anywhere
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "repo", "ref", "repo-url", "contributors-csv", "breakdown-csv":
+			err = fmt.Errorf("--%s can't be used in batch mode", f.Name)
+		}
+	})
+	return flags, err
+}
+
+// checkFormat checks that flags.format is known, and works with the other flags.
+func checkFormat(flags *cliFlags) error {
+	switch {
+	case flags.format != "csv" && flags.format != "json":
+		return fmt.Errorf("unknown format %q (expected csv or json)", flags.format)
+	case flags.format == "json" && flags.granularity != granularityDay:
+		// The web app reads every date as a calendar day
+		return fmt.Errorf("--format json only supports --granularity day, not %s", flags.granularity)
+	}
+	return nil
+}
+
+// run analyzes one repo and writes its CSV or JSON report.
+func run(flags *cliFlags) error {
+	if err := checkFormat(flags); err != nil {
+		return err
+	}
+	if flags.maxBlob > 0 {
+		maxBufferedBlob = flags.maxBlob
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+
+	// An interrupted run still returns the days counted so far, which are written before returning its error
+	result, err := analyze(ctx, flags, pipelineOptions{workers: flags.workers, log: newLogger("", true, flags.verbose)})
+	if result == nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if flags.output != "" {
+		file, err := os.Create(flags.output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if flags.authors != "" {
+		if err := writeCSVFile(flags.authors, writeContributorsCSV, result.dates, result.days); err != nil {
+			return err
+		}
+	}
+	if flags.groupFile != "" {
+		if err := writeCSVFile(flags.groupFile, writeBreakdownCSV, result.dates, result.days); err != nil {
+			return err
+		}
+	}
+	if writeErr := writeAnalysis(out, flags.format, result); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// interruptContext returns a context that's canceled on the first Ctrl-C, so workers can stop after their
+// current day and save progress. A second Ctrl-C kills the process.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// analysis is one repo's line counts over time.
+type analysis struct {
+	meta  resultMeta
+	dates []string     // Every day, or bucket of --granularity, from the first commit to the last
+	days  []*fileStats // Parallel to dates, with gaps carried forward
+}
+
+// analyze reads the history of the repo in flags, cloning it first if it's a URL, and counts its lines for
+// each day. If ctx is canceled, it returns the days from the first one up to the last one counted without a
+// gap, along with the error. Their headCommit is that last day's commit, so the result reads as out of date.
+func analyze(ctx context.Context, flags *cliFlags, opts pipelineOptions) (*analysis, error) {
+	log := opts.log
+	var err error
+	if flags.groupFile != "" {
+		if opts.breakdown, err = newBreakdown(flags.depth, flags.groups); err != nil {
+			return nil, err
+		}
+	}
+	backend := flags.backend
+	if backend == "" {
+		backend = backendAuto
+	}
+	repoPath := flags.repoPath
+	cloned, err := isRepoURL(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if cloned {
+		dir, err := cloneRepo(flags.repoPath, flags.cloneDir, log)
+		if err != nil {
+			return nil, err
+		}
+		repoPath = dir
+	}
+	repo, err := openRepo(repoPath, backend, cmp.Or(opts.readers, opts.workers))
+	if err != nil {
+		return nil, err
+	}
+	defer repo.close()
+
+	spec, err := resolveRef(repo, flags.ref)
+	if err != nil {
+		return nil, err
+	}
+	if cloned {
+		if err := prefetchBlobs(repo.gitDir(), spec.rev, log); err != nil {
+			return nil, err
+		}
+	}
+	log.printf("Analyzing %s (%s)", spec.name, spec.head[:min(len(spec.head), 12)])
+
+	config, err := loadConfig(repo, spec.head, flags.config, log)
+	if err != nil {
+		return nil, err
+	}
+	if opts.scope, err = newPathScope(flags.include, flags.exclude, config); err != nil {
+		return nil, err
+	}
+	opts.languages = newLanguageMap(config)
+
+	// Like the web app, authors are normalized with the .mailmap at the analyzed tip
+	mm, err := readMailmap(repo, spec.head)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := getCommits(repo, spec.rev, mm, flags.history)
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("no commits found on %s", spec.name)
+	}
+
+	buckets, labels := groupCommits(commits, flags.granularity)
+
+	contributors := make(map[string]bool)
+	for _, c := range commits {
+		for _, author := range c.authors {
+			contributors[author] = true
+		}
+	}
+	switch flags.granularity {
+	case granularityCommit:
+		log.printf("Found %d commits by %d contributors", len(commits), len(contributors))
+	case granularityDay:
+		log.printf("Found %d commits by %d contributors on %d days", len(commits), len(contributors), len(labels))
+	default:
+		log.printf("Found %d commits by %d contributors in %d %s", len(commits), len(contributors), len(labels),
+			flags.granularity.plural())
+	}
+
+	opts.granularity = flags.granularity
+	cache := openBlobCache(repo, flags.noCache, log)
+	key := checkpointKey(spec, opts.scope, opts.breakdown, config, flags.granularity, flags.history)
+	cp := openCheckpoint(repo, flags.noResume, key, flags.granularity, log)
+
+	dayStats, interrupted := processDays(ctx, repo, labels, buckets, opts, cache, cp)
+	// Save even after a failure, so the blobs counted so far don't need to be counted again
+	if saveErr := cache.save(); saveErr != nil {
+		log.warnf("%v", saveErr)
+	}
+	if interrupted != nil {
+		if saveErr := cp.save(); saveErr != nil {
+			log.warnf("%v", saveErr)
+		} else if cp.path != "" {
+			log.printf("Saved progress, run the same command again to resume")
+		}
+		// Workers count chunks side by side, so only the days before the first one missing can be written
+		done := 0
+		for done < len(labels) && dayStats[labels[done]] != nil {
+			done++
+		}
+		if done == 0 {
+			return nil, interrupted
+		}
+		labels = labels[:done]
+		spec.head = buckets[labels[done-1]].hash
+		log.printf("Writing partial results up to %s (%d/%d %s)", labels[done-1], done, len(buckets),
+			flags.granularity.plural())
+	} else if err := cp.remove(); err != nil {
+		log.warnf("%v", err)
+	}
+
+	// Fill in buckets without commits, from the first bucket to the last
+	allDates := labels
+	if flags.granularity != granularityCommit {
+		first, last := buckets[labels[0]], buckets[labels[len(labels)-1]]
+		if allDates, err = flags.granularity.labelsBetween(first.date, last.date); err != nil {
+			return nil, err
+		}
+	}
+	repoURL := flags.repoURL
+	if repoURL == "" {
+		repoURL = detectRepoURL(repo)
+	}
+	return &analysis{
+		meta: resultMeta{
+			repoURL:       repoURL,
+			defaultBranch: spec.name,
+			headCommit:    spec.head,
+			analyzedAt:    time.Now(),
+		},
+		dates: allDates,
+		days:  carryForward(allDates, dayStats),
+	}, interrupted
+}
+
+// writeAnalysis writes a repo's report in format, csv or json.
+func writeAnalysis(w io.Writer, format string, result *analysis) error {
+	if format == "json" {
+		return writeJSON(w, buildAnalysisResult(result.meta, result.dates, result.days))
+	}
+	return writeCSV(w, result.dates, result.days)
+}
+
+// writeCSVFile writes a CSV report of days to a file.
+func writeCSVFile(path string, write func(io.Writer, []string, []*fileStats) error, dates []string, days []*fileStats) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := write(file, dates, days); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// cloneRepo clones or fetches the repo at url into a mirror under cloneDir, and returns the mirror's path.
+func cloneRepo(url, cloneDir string, log *logger) (string, error) {
+	remote, err := parseRepoURL(url)
+	if err != nil {
+		return "", err
+	}
+	if cloneDir == "" {
+		if cloneDir, err = defaultMirrorRoot(); err != nil {
+			return "", err
+		}
+	}
+	dir := mirrorPath(cloneDir, remote)
+	return dir, syncMirror(remote, dir, log)
+}
+
+// openBlobCache loads the repo's on-disk blob cache, or returns an in-memory one if disabled.
+func openBlobCache(repo repository, disabled bool, log *logger) *blobCache {
+	if disabled {
+		return newBlobCache("")
+	}
+	path := defaultBlobCachePath(repo)
+	cache := newBlobCache(path)
+	if err := cache.load(); err != nil {
+		// A broken cache only costs time, so start over rather than fail
+		log.warnf("%v, ignoring it", err)
+		return newBlobCache(path)
+	}
+	log.printf("Loaded %d cached blob counts", len(cache.entries))
+	return cache
+}
+
+// openCheckpoint loads the checkpoint for key left by an interrupted run, or returns an in-memory one if
+// disabled.
+func openCheckpoint(repo repository, disabled bool, key string, g granularity, log *logger) *checkpoint {
+	if disabled {
+		return newCheckpoint("", key)
+	}
+	path := defaultCheckpointPath(repo, key)
+	cp := newCheckpoint(path, key)
+	if err := cp.load(); err != nil {
+		// A broken checkpoint only costs time, so start over rather than fail
+		log.warnf("%v, ignoring it", err)
+		return newCheckpoint(path, key)
+	}
+	if n := cp.doneDays(); n > 0 {
+		log.printf("Resuming from a checkpoint with %d %s done", n, g.plural())
+	}
+	return cp
+}
+
+// showUsage displays the help message.
+func showUsage() {
+	fmt.Println("Usage: go run . [OPTIONS] > loc.csv")
+	fmt.Println("       go run . batch [OPTIONS] FILE > portfolio.csv")
+	fmt.Println()
+	fmt.Println("Count lines of code for each day in a repo's git history and print a CSV or JSON report.")
+	fmt.Println()
+	fmt.Println("Batch mode analyzes each repo listed in FILE, one per line as \"PATH_OR_URL [REF]\" (# starts a")
+	fmt.Println("comment). Each repo's report goes to --out-dir, and a portfolio of all repos' latest line counts per")
+	fmt.Println("language, with a total row, to -o or stdout. Repos run side by side, sharing the --workers budget.")
+	fmt.Println("A repo that fails is listed with its error and doesn't stop the others.")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("    --repo PATH|URL  Path to the git repository to analyze (default: current directory), or a")
+	fmt.Println("                     GitHub/GitLab/Bitbucket URL, owner/repo for GitHub, or a file:// URL to clone")
+	fmt.Println("    --clone-dir DIR  Where to keep clones of remote repos, fetched again on each run")
+	fmt.Println("                     (default: gitstrata/repos in the user cache dir)")
+	fmt.Println("    --ref REF        Branch, tag, commit SHA, or A..B range to analyze")
+	fmt.Println("                     (default: origin/HEAD's branch, else the checked-out branch)")
+	fmt.Println("    -o FILE          Write output to FILE instead of stdout")
+	fmt.Println("    --out-dir DIR    Batch mode: where to write each repo's report, as NAME.csv or NAME.json")
+	fmt.Println("                     (default: current directory)")
+	fmt.Println("    --format FORMAT  csv (default) or json (AnalysisResult, loadable by the web app)")
+	fmt.Println("    --repo-url URL   Repo URL to record in JSON output (default: the origin remote)")
+	fmt.Println("    --contributors-csv FILE")
+	fmt.Println("                     Also write each author's net lines per day to FILE")
+	fmt.Println("    --include GLOB   Only count files matching GLOB, like services/api or '*.go' (repeatable)")
+	fmt.Println("    --exclude GLOB   Don't count files matching GLOB (repeatable). Globs match a path or any dir")
+	fmt.Println("                     above it, ** matches any number of dirs, and globs without a / match at")
+	fmt.Println("                     any depth.")
+	fmt.Println("    --breakdown-csv FILE")
+	fmt.Println("                     Also write each directory's lines per language per day to FILE")
+	fmt.Println("    --breakdown-depth N")
+	fmt.Println("                     Break down by the first N levels of directories (default: 1)")
+	fmt.Println("    --component NAME=GLOB")
+	fmt.Println("                     Break down by named components instead of directories (repeatable). Files")
+	fmt.Println("                     go to the first component they match, or \"other\".")
+	fmt.Println("    --config FILE    Read skip rules, test dirs, and languages for extensions from FILE (.yml or")
+	fmt.Println("                     .toml) instead of the repo's .gitstrata.yml or .gitstrata.toml")
+	fmt.Println("    --granularity G  One row per commit, or for the last commit of each day (default), week,")
+	fmt.Println("                     month, or quarter. Rows are labeled like 2024-01-31, 2024-W05 (ISO week),")
+	fmt.Println("                     2024-01, 2024-Q1, or 2024-01-31 followed by the commit's short hash.")
+	fmt.Println("                     --format json only supports day.")
+	fmt.Println("    --date SOURCE    Bucket commits by their author or committer date (default: committer)")
+	fmt.Println("    --timezone TZ    Time zone to bucket dates in: UTC or an IANA name like Europe/Berlin")
+	fmt.Println("                     (default: each commit's own). Commits dated before their parents count as")
+	fmt.Println("                     made on their latest parent's date.")
+	fmt.Println("    --first-parent   Follow only the first parent of merges, so each row is counted at the")
+	fmt.Println("                     mainline as it was, not at a commit on a branch merged later. Lines of")
+	fmt.Println("                     merged commits are still attributed to their authors.")
+	fmt.Println("    --mark-merges    Start the messages of merge commits with \"[merge] \", to tell merged pull")
+	fmt.Println("                     requests apart from direct pushes")
+	fmt.Println("    --workers N      Number of parallel workers (default: one per CPU core)")
+	fmt.Println("    --backend NAME   How to read the repo: exec runs the git binary, native reads .git directly")
+	fmt.Println("                     in pure Go (default: auto, exec if git is installed)")
+	fmt.Println("    --no-cache       Don't read or write the blob cache in .git/gitstrata/")
+	fmt.Println("    --no-checkpoint  Don't resume from or save checkpoints in .git/gitstrata/checkpoints/")
+	fmt.Println("    --max-blob-buffer MIB")
+	fmt.Println("                     Largest file to hold in memory for inline test detection, like Rust's")
+	fmt.Println("                     #[cfg(test)] (default: 16). Peak memory is about workers × this.")
+	fmt.Println("    -v, --verbose    Print each file that isn't counted, and why: a skip rule, .gitattributes, or")
+	fmt.Println("                     content that looks generated or minified")
+	fmt.Println("    -h, --help       Show this help message")
+	fmt.Println()
+	fmt.Println("Progress is printed to stderr.")
+}
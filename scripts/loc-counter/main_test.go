@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestParseFlags_MaxBlobBuffer(t *testing.T) {
+	flags, err := parseFlags([]string{"--max-blob-buffer", "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.maxBlob != 4<<20 {
+		t.Errorf("maxBlob = %d, want 4 MiB", flags.maxBlob)
+	}
+
+	for _, arg := range []string{"0", "-1"} {
+		if _, err := parseFlags([]string{"--max-blob-buffer", arg}); err == nil {
+			t.Errorf("parseFlags(--max-blob-buffer %s) should fail", arg)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memRepo is an in-memory repository for tests that don't need a real git repo. Objects are built with
+// blob, tree, and commit, and refs are set directly.
+type memRepo struct {
+	t       *testing.T
+	objects map[string]gitObject
+	refs    map[string]string // Full ref name to a hash, or "ref: <target>" for symbolic refs
+	root    string
+}
+
+func newMemRepo(t *testing.T) *memRepo {
+	return &memRepo{t: t, objects: make(map[string]gitObject), refs: make(map[string]string), root: t.TempDir()}
+}
+
+// add stores an object, and returns its hash.
+func (r *memRepo) add(typ string, data []byte) string {
+	sum := sha1.Sum(append(fmt.Appendf(nil, "%s %d\x00", typ, len(data)), data...))
+	hash := hex.EncodeToString(sum[:])
+	r.objects[hash] = gitObject{hash: hash, typ: typ, size: len(data), data: data}
+	return hash
+}
+
+// tree stores the trees for files, keyed by slash-separated path, and returns the root tree's hash.
+func (r *memRepo) tree(files map[string]string) string {
+	blobs := make(map[string]string)
+	subdirs := make(map[string]map[string]string)
+	for path, content := range files {
+		if dir, rest, ok := strings.Cut(path, "/"); ok {
+			if subdirs[dir] == nil {
+				subdirs[dir] = make(map[string]string)
+			}
+			subdirs[dir][rest] = content
+		} else {
+			blobs[path] = r.add("blob", []byte(content))
+		}
+	}
+
+	var entries []treeEntry
+	for name, hash := range blobs {
+		entries = append(entries, treeEntry{mode: "100644", name: name, hash: hash})
+	}
+	for name, sub := range subdirs {
+		entries = append(entries, treeEntry{mode: "40000", name: name, hash: r.tree(sub)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	var data []byte
+	for _, e := range entries {
+		raw, _ := hex.DecodeString(e.hash)
+		data = append(data, e.mode+" "+e.name+"\x00"...)
+		data = append(data, raw...)
+	}
+	return r.add("tree", data)
+}
+
+// commit stores a commit of files by author ("Name <email>") at date (RFC 3339), and returns its hash.
+func (r *memRepo) commit(files map[string]string, date, author, message string, parents ...string) string {
+	when, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "tree %s\n", r.tree(files))
+	for _, p := range parents {
+		fmt.Fprintf(&b, "parent %s\n", p)
+	}
+	fmt.Fprintf(&b, "author %s %d %s\n", author, when.Unix(), when.Format("-0700"))
+	fmt.Fprintf(&b, "committer %s %d %s\n\n%s\n", author, when.Unix(), when.Format("-0700"), message)
+	return r.add("commit", []byte(b.String()))
+}
+
+func (r *memRepo) log(rev string) ([]logEntry, error) {
+	return walkLog(r, rev)
+}
+
+func (r *memRepo) resolveCommit(rev string) (string, bool, error) {
+	return resolveRevision(r, rev, func(name string) (string, bool, error) {
+		if _, ok := r.objects[name]; ok {
+			return name, true, nil
+		}
+		for _, ref := range refCandidates(name) {
+			value, ok := r.refs[ref]
+			for ok && strings.HasPrefix(value, "ref: ") {
+				value, ok = r.refs[strings.TrimPrefix(value, "ref: ")]
+			}
+			if ok {
+				return value, true, nil
+			}
+		}
+		return "", false, nil
+	})
+}
+
+func (r *memRepo) symbolicRef(name string) (string, bool, error) {
+	target, ok := strings.CutPrefix(r.refs[name], "ref: ")
+	return target, ok, nil
+}
+
+func (r *memRepo) remoteURL(string) (string, bool, error) {
+	return "", false, nil
+}
+
+func (r *memRepo) read(hashes []string) ([]gitObject, error) {
+	objs := make([]gitObject, len(hashes))
+	for i, hash := range hashes {
+		obj, ok := r.objects[hash]
+		if !ok {
+			obj = gitObject{hash: hash, missing: true}
+		}
+		objs[i] = obj
+	}
+	return objs, nil
+}
+
+func (r *memRepo) stream(hashes []string, handle streamHandler) ([]gitObject, error) {
+	objs, _ := r.read(hashes)
+	for i, obj := range objs {
+		if !obj.missing {
+			handle(i, gitObject{hash: obj.hash, typ: obj.typ, size: obj.size}, bytes.NewReader(obj.data))
+		}
+	}
+	return objs, nil
+}
+
+func (r *memRepo) dir() string    { return r.root }
+func (r *memRepo) gitDir() string { return r.root }
+func (r *memRepo) close()         {}
+
+func TestProcessDays_InMemoryRepo(t *testing.T) {
+	repo := newMemRepo(t)
+	alice, bob := "Alice <alice@example.com>", "Bob <bob@example.com>"
+	lib := "fn lib() {}\n"
+	first := repo.commit(map[string]string{"main.go": "package main\n", "src/lib.rs": lib},
+		"2024-01-01T10:00:00Z", alice, "Add main")
+	second := repo.commit(map[string]string{"main.go": "package main\n\nfunc main() {}\n", "src/lib.rs": lib},
+		"2024-01-02T09:00:00Z", bob, "Add func", first)
+	third := repo.commit(map[string]string{"src/lib.rs": lib},
+		"2024-01-02T12:00:00Z", alice, "Remove main", second)
+	repo.refs["HEAD"] = "ref: refs/heads/main"
+	repo.refs["refs/heads/main"] = third
+
+	spec, err := resolveRef(repo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.name != "main" || spec.head != third {
+		t.Fatalf("resolveRef() = %+v, want main at %s", spec, third)
+	}
+	commits, err := getCommits(repo, spec.rev, nil, historyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 3 || commits[0].hash != third || commits[2].hash != first {
+		t.Fatalf("getCommits() = %+v, want the 3 commits newest first", commits)
+	}
+
+	daily, dates := groupCommits(commits, granularityDay)
+	days, err := processDays(context.Background(), repo, dates, daily, pipelineOptions{workers: 2}, newBlobCache(""), newCheckpoint("", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if days["2024-01-01"].total != 2 || days["2024-01-02"].total != 1 {
+		t.Errorf("totals = %d, %d, want 2, 1", days["2024-01-01"].total, days["2024-01-02"].total)
+	}
+	if want := map[string]int{alice: 0, bob: 2}; !reflect.DeepEqual(days["2024-01-02"].contributorAdded, want) {
+		t.Errorf("contributorAdded = %v, want %v", days["2024-01-02"].contributorAdded, want)
+	}
+	if want := map[string]int{alice: 3, bob: 0}; !reflect.DeepEqual(days["2024-01-02"].contributorRemoved, want) {
+		t.Errorf("contributorRemoved = %v, want %v", days["2024-01-02"].contributorRemoved, want)
+	}
+}
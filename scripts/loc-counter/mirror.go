@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// defaultMirrorRoot returns where remote repos are cloned by default: gitstrata/repos in the user's cache dir.
+func defaultMirrorRoot() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the cache dir (use --clone-dir): %w", err)
+	}
+	return filepath.Join(dir, "gitstrata", "repos"), nil
+}
+
+// mirrorPath returns where remote is mirrored under root. Forge repos go to host/owner/name.git, like the web
+// app's repoToDir. Local file:// repos are keyed by a hash of their path, since any path can be cloned.
+func mirrorPath(root string, remote remoteRepo) string {
+	if remote.host == "" {
+		sum := sha256.Sum256([]byte(remote.url))
+		return filepath.Join(root, "file", remote.name+"-"+hex.EncodeToString(sum[:6])+".git")
+	}
+	return filepath.Join(root, remote.host, remote.owner, remote.name+".git")
+}
+
+// mirrorGit returns a git command that runs in dir, with its output going to stderr with our progress.
+func mirrorGit(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// syncMirror clones remote into a bare repo at dir, or fetches into it if it's already there. Clones are
+// blobless where the server supports it: blobs are fetched later by prefetchBlobs, only for the analyzed
+// history. If a fetch fails, the mirror is used as of the last successful one.
+func syncMirror(remote remoteRepo, dir string, log *logger) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		if _, statErr := os.Stat(filepath.Join(dir, "HEAD")); statErr == nil {
+			log.warnf("git isn't installed, so %s can't be fetched. Analyzing it as of the last fetch.", remote.url)
+			return nil
+		}
+		return fmt.Errorf("cloning %s needs git to be installed", remote.url)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err == nil {
+		log.printf("Fetching %s into %s", remote.url, dir)
+		if err := mirrorGit(dir, "fetch", "--quiet", "--prune", "origin").Run(); err != nil {
+			log.warnf("failed to fetch %s (%v). Analyzing it as of the last fetch.", remote.url, err)
+			return nil
+		}
+		updateMirrorHead(dir)
+		return nil
+	}
+
+	log.printf("Cloning %s into %s", remote.url, dir)
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return fmt.Errorf("failed to create the clone dir: %w", err)
+	}
+	// Clone next to the mirror and move it into place when done, so an interrupted clone isn't mistaken for one
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".clone-*")
+	if err != nil {
+		return fmt.Errorf("failed to create the clone dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := mirrorGit(tmp, "clone", "--quiet", "--bare", "--filter=blob:none", remote.url, ".").Run(); err != nil {
+		return fmt.Errorf("failed to clone %s: %w", remote.url, err)
+	}
+	// Bare clones don't set up fetching, so later fetches wouldn't update the branches and tags
+	for _, refspec := range []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"} {
+		if err := mirrorGit(tmp, "config", "--add", "remote.origin.fetch", refspec).Run(); err != nil {
+			return fmt.Errorf("failed to configure %s: %w", dir, err)
+		}
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		if _, statErr := os.Stat(filepath.Join(dir, "HEAD")); statErr == nil {
+			return nil // Cloned by another run meanwhile
+		}
+		return fmt.Errorf("failed to move the clone into place: %w", err)
+	}
+	return nil
+}
+
+// updateMirrorHead points the mirror's HEAD at the remote's default branch, in case it changed since the clone.
+// Best effort: on any failure, HEAD stays as it was.
+func updateMirrorHead(dir string) {
+	output, err := exec.Command("git", "-C", dir, "ls-remote", "--symref", "origin", "HEAD").Output()
+	if err != nil {
+		return
+	}
+	// The first line is like "ref: refs/heads/main\tHEAD"
+	line, _, _ := strings.Cut(string(output), "\n")
+	target, ok := strings.CutPrefix(strings.TrimSuffix(line, "\tHEAD"), "ref: ")
+	if !ok || !strings.HasPrefix(target, "refs/heads/") {
+		return
+	}
+	if exec.Command("git", "-C", dir, "rev-parse", "--verify", "--quiet", target).Run() == nil {
+		exec.Command("git", "-C", dir, "symbolic-ref", "HEAD", target).Run()
+	}
+}
+
+// prefetchBlobs fetches all blobs that the partial clone at dir is missing for rev in one go. Otherwise git
+// would fetch them one at a time as they're read, and the native backend couldn't read them at all.
+func prefetchBlobs(dir, rev string, log *logger) error {
+	if output, _ := exec.Command("git", "-C", dir, "config", "--bool", "remote.origin.promisor").Output(); strings.TrimSpace(string(output)) != "true" {
+		return nil // A full clone
+	}
+
+	missing, err := missingObjects(dir, rev)
+	if err != nil {
+		return err
+	}
+	// The first day of a range is diffed against the commit before it, which is outside the range
+	if strings.Contains(rev, "..") {
+		output, err := exec.Command("git", "-C", dir, "rev-list", "--boundary", rev, "--").Output()
+		if err != nil {
+			return fmt.Errorf("failed to list the commits of %s: %w", rev, err)
+		}
+		var boundary []string
+		for line := range strings.SplitSeq(string(output), "\n") {
+			if hash, ok := strings.CutPrefix(line, "-"); ok {
+				boundary = append(boundary, hash)
+			}
+		}
+		if len(boundary) > 0 {
+			more, err := missingObjects(dir, append([]string{"--no-walk"}, boundary...)...)
+			if err != nil {
+				return err
+			}
+			seen := make(map[string]bool, len(missing))
+			for _, hash := range missing {
+				seen[hash] = true
+			}
+			for _, hash := range more {
+				if !seen[hash] {
+					missing = append(missing, hash)
+				}
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	log.printf("Fetching %d files", len(missing))
+	// The same fetch git does for missing objects of a partial clone, but for all of them at once
+	cmd := mirrorGit(dir, "-c", "fetch.negotiationAlgorithm=noop", "fetch", "--quiet", "--no-tags", "--no-write-fetch-head",
+		"--recurse-submodules=no", "--filter=blob:none", "--stdin", "origin")
+	cmd.Stdin = strings.NewReader(strings.Join(missing, "\n") + "\n")
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to fetch files: %w", err)
+	}
+	return nil
+}
+
+// missingObjects lists the objects reachable from the rev-list args that aren't in the repo at dir.
+func missingObjects(dir string, args ...string) ([]string, error) {
+	args = append([]string{"-C", dir, "rev-list", "--objects", "--missing=print"}, args...)
+	cmd := exec.Command("git", append(args, "--")...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run git rev-list: %w", err)
+	}
+
+	var missing []string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if hash, ok := strings.CutPrefix(scanner.Text(), "?"); ok {
+			missing = append(missing, hash)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to run git rev-list: %w", err)
+	}
+	return missing, scanner.Err()
+}
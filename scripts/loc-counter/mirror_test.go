@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncMirror_BloblessCloneAndFetch(t *testing.T) {
+	source := newTestRepo(t)
+	// Local repos don't serve partial clones unless told to
+	source.git("config", "uploadpack.allowFilter", "true")
+	source.write("a.go", "package a\n")
+	source.commit("2024-01-01T10:00:00Z", "First")
+	source.write("b.go", "package a\n\nfunc B() {}\n")
+	source.commit("2024-01-02T10:00:00Z", "Second")
+
+	remote, err := parseRepoURL("file://" + filepath.ToSlash(source.dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := mirrorPath(t.TempDir(), remote)
+	if err := syncMirror(remote, dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	mirror := &testRepo{t: t, dir: dir}
+	if got := strings.TrimSpace(mirror.git("config", "remote.origin.promisor")); got != "true" {
+		t.Fatalf("remote.origin.promisor = %q, want a partial clone", got)
+	}
+	if missing, err := missingObjects(dir, "main"); err != nil || len(missing) != 2 {
+		t.Fatalf("missingObjects() = %v, %v, want the 2 blobs", missing, err)
+	}
+
+	source.write("c.go", "package a\n")
+	source.commit("2024-01-03T10:00:00Z", "Third")
+	source.git("checkout", "-q", "-b", "trunk")
+	source.git("branch", "-q", "-D", "main")
+	if err := syncMirror(remote, dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	head := strings.TrimSpace(source.git("rev-parse", "HEAD"))
+	if got := strings.TrimSpace(mirror.git("rev-parse", "HEAD")); got != head {
+		t.Errorf("mirror HEAD = %s, want the fetched %s", got, head)
+	}
+	if got := strings.TrimSpace(mirror.git("symbolic-ref", "HEAD")); got != "refs/heads/trunk" {
+		t.Errorf("mirror HEAD -> %s, want the remote's new default branch", got)
+	}
+	if refs := mirror.git("for-each-ref", "--format=%(refname)"); strings.Contains(refs, "refs/heads/main") {
+		t.Errorf("deleted branch wasn't pruned: %s", refs)
+	}
+
+	// The range's first day is diffed against the first commit, so its blobs are needed too
+	if err := prefetchBlobs(dir, "trunk~1..trunk", nil); err != nil {
+		t.Fatal(err)
+	}
+	if missing, err := missingObjects(dir, "trunk"); err != nil || len(missing) != 0 {
+		t.Fatalf("missingObjects() after prefetch = %v, %v, want none", missing, err)
+	}
+
+	// Every object the analysis reads must now be local, so the native backend can read the mirror too
+	repo := mirror.open(backendNative)
+	spec, err := resolveRef(repo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.name != "trunk" || spec.head != head {
+		t.Errorf("resolveRef() = %+v, want trunk at %s", spec, head)
+	}
+}
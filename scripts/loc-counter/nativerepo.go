@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// maxSymrefDepth bounds how many symbolic refs are followed, in case of a loop.
+const maxSymrefDepth = 8
+
+// nativeRepo reads a repo's refs and object database directly, in pure Go. It handles loose objects,
+// packfiles with deltas, alternates, packed refs, and linked worktrees, but not SHA-256 repos or the
+// reftable ref format.
+type nativeRepo struct {
+	root    string // Top-level directory, or the git dir if bare
+	private string // The worktree's own git dir, with its HEAD. Same as common outside linked worktrees.
+	common  string
+	config  map[string]string
+	store   *objectStore
+	shallow map[string]bool // Commits whose parents weren't fetched
+
+	packedOnce sync.Once
+	packed     map[string]string
+	packedErr  error
+}
+
+// openNativeRepo opens the repo containing path, looking for it in path and its parents like git does.
+func openNativeRepo(path string) (*nativeRepo, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("not a git repository: %s", path)
+	}
+	for dir := abs; ; {
+		if private, root, ok := findGitDir(dir); ok {
+			return newNativeRepo(root, private)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("not a git repository: %s", path)
+		}
+		dir = parent
+	}
+}
+
+// findGitDir returns the git dir of the repo whose top-level directory is dir, if it is one: dir/.git, the
+// dir a .git file points to (in linked worktrees and submodules), or dir itself for bare repos.
+func findGitDir(dir string) (gitDir, root string, ok bool) {
+	dotGit := filepath.Join(dir, ".git")
+	info, err := os.Stat(dotGit)
+	switch {
+	case err == nil && info.IsDir():
+		return dotGit, dir, true
+	case err == nil:
+		data, err := os.ReadFile(dotGit)
+		if err != nil {
+			return "", "", false
+		}
+		target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+		if !ok {
+			return "", "", false
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		return filepath.Clean(target), dir, true
+	}
+	if isGitDir(dir) {
+		return dir, dir, true
+	}
+	return "", "", false
+}
+
+func isGitDir(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func newNativeRepo(root, private string) (*nativeRepo, error) {
+	common := private
+	if data, err := os.ReadFile(filepath.Join(private, "commondir")); err == nil {
+		common = strings.TrimSpace(string(data))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(private, common)
+		}
+		common = filepath.Clean(common)
+	}
+
+	config, err := readGitConfig(filepath.Join(common, "config"))
+	if err != nil {
+		return nil, err
+	}
+	if format := config["extensions.objectformat"]; format != "" && !strings.EqualFold(format, "sha1") {
+		return nil, fmt.Errorf("the native backend doesn't support %s repositories, use --backend exec", format)
+	}
+	if refs := config["extensions.refstorage"]; refs != "" && !strings.EqualFold(refs, "files") {
+		return nil, fmt.Errorf("the native backend doesn't support %s refs, use --backend exec", refs)
+	}
+
+	store, err := openObjectStore(filepath.Join(common, "objects"))
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(config["core.bare"], "true") {
+		root = common
+	}
+	shallow := make(map[string]bool)
+	if data, err := os.ReadFile(filepath.Join(common, "shallow")); err == nil {
+		for _, hash := range strings.Fields(string(data)) {
+			shallow[hash] = true
+		}
+	}
+	return &nativeRepo{root: root, private: private, common: common, config: config, store: store, shallow: shallow}, nil
+}
+
+func (r *nativeRepo) isShallow(hash string) bool {
+	return r.shallow[hash]
+}
+
+func (r *nativeRepo) log(rev string) ([]logEntry, error) {
+	return walkLog(r, rev)
+}
+
+func (r *nativeRepo) resolveCommit(rev string) (string, bool, error) {
+	return resolveRevision(r, rev, r.lookup)
+}
+
+// lookup resolves a name to an object hash: a full hash, a ref (tried in refCandidates order), or an
+// abbreviated hash.
+func (r *nativeRepo) lookup(name string) (string, bool, error) {
+	if _, ok := parseObjectID(name); ok {
+		return name, true, nil
+	}
+	for _, ref := range refCandidates(name) {
+		hash, ok, err := r.resolveRef(ref)
+		if err != nil || ok {
+			return hash, ok, err
+		}
+	}
+	hash, ok := r.store.findAbbrev(name)
+	return hash, ok, nil
+}
+
+// resolveRef returns the hash a full ref name points to, following symbolic refs.
+func (r *nativeRepo) resolveRef(name string) (string, bool, error) {
+	for range maxSymrefDepth {
+		value, ok, err := r.readRef(name)
+		if err != nil || !ok {
+			return "", false, err
+		}
+		target, symbolic := strings.CutPrefix(value, "ref: ")
+		if !symbolic {
+			// Some pseudo-refs, like FETCH_HEAD, have more after the hash
+			hash, _, _ := strings.Cut(value, "\t")
+			if _, ok := parseObjectID(hash); !ok {
+				return "", false, fmt.Errorf("bad ref %s: %q", name, value)
+			}
+			return hash, true, nil
+		}
+		name = target
+	}
+	return "", false, fmt.Errorf("too many levels of symbolic refs at %s", name)
+}
+
+// readRef returns the first line of a ref: a hash, or "ref: <target>" for symbolic refs. Loose refs win
+// over packed ones. Names outside refs/ must be all caps, like HEAD.
+func (r *nativeRepo) readRef(name string) (string, bool, error) {
+	if !validRefName(name) {
+		return "", false, nil
+	}
+	if !strings.HasPrefix(name, "refs/") && strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ_") != "" {
+		return "", false, nil
+	}
+	dir := r.common
+	if !strings.HasPrefix(name, "refs/") || strings.HasPrefix(name, "refs/bisect/") ||
+		strings.HasPrefix(name, "refs/worktree/") || strings.HasPrefix(name, "refs/rewritten/") {
+		dir = r.private // Per-worktree refs, like HEAD
+	}
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err == nil {
+		line, _, _ := strings.Cut(string(data), "\n")
+		return strings.TrimSpace(line), true, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		if info, statErr := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); statErr != nil || !info.IsDir() {
+			return "", false, fmt.Errorf("failed to read ref %s: %w", name, err)
+		}
+	}
+
+	r.packedOnce.Do(func() { r.packed, r.packedErr = readPackedRefs(filepath.Join(r.common, "packed-refs")) })
+	if r.packedErr != nil {
+		return "", false, r.packedErr
+	}
+	hash, ok := r.packed[name]
+	return hash, ok, nil
+}
+
+// validRefName rejects names that could escape the git dir, or aren't refs at all.
+func validRefName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.ContainsAny(name, "\\\x00 ~^:?*[") {
+		return false
+	}
+	for part := range strings.SplitSeq(name, "/") {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// readPackedRefs reads packed-refs: "<hash> <name>" per line, each optionally followed by a "^<hash>" line
+// with the commit an annotated tag peels to.
+func readPackedRefs(path string) (map[string]string, error) {
+	refs := make(map[string]string)
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read packed refs: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		hash, name, ok := strings.Cut(line, " ")
+		if ok {
+			refs[name] = hash
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read packed refs: %w", err)
+	}
+	return refs, nil
+}
+
+func (r *nativeRepo) symbolicRef(name string) (string, bool, error) {
+	value, ok, err := r.readRef(name)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	target, ok := strings.CutPrefix(value, "ref: ")
+	return target, ok, nil
+}
+
+func (r *nativeRepo) remoteURL(name string) (string, bool, error) {
+	url := r.config["remote."+name+".url"]
+	return url, url != "", nil
+}
+
+func (r *nativeRepo) read(hashes []string) ([]gitObject, error) {
+	objs := make([]gitObject, len(hashes))
+	for i, hash := range hashes {
+		typ, data, err := r.store.readObject(hash)
+		if errors.Is(err, errObjectNotFound) {
+			objs[i] = gitObject{hash: hash, missing: true}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		objs[i] = gitObject{hash: hash, typ: typ, size: len(data), data: data}
+	}
+	return objs, nil
+}
+
+func (r *nativeRepo) stream(hashes []string, handle streamHandler) ([]gitObject, error) {
+	objs := make([]gitObject, len(hashes))
+	for i, hash := range hashes {
+		typ, size, content, err := r.store.openObject(hash)
+		if errors.Is(err, errObjectNotFound) {
+			objs[i] = gitObject{hash: hash, missing: true}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		objs[i] = gitObject{hash: hash, typ: typ, size: int(size)}
+		counted := &countingReader{r: content}
+		handle(i, objs[i], counted)
+		// Read whatever the handler didn't, so a truncated or corrupt object fails the request
+		_, err = io.Copy(io.Discard, counted)
+		content.Close()
+		if err == nil && counted.n != size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", hash, err)
+		}
+	}
+	return objs, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (r *nativeRepo) dir() string    { return r.root }
+func (r *nativeRepo) gitDir() string { return r.common }
+
+func (r *nativeRepo) close() {
+	r.store.close()
+}
+
+// readGitConfig reads a git config file into a map keyed like "remote.origin.url". Section and variable
+// names are lowercased, subsection names kept as they are. Later values win, and includes aren't followed.
+// A missing file is an empty config.
+func readGitConfig(path string) (map[string]string, error) {
+	config := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read git config: %w", err)
+	}
+
+	section := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.LastIndexByte(line, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%s:%d: bad section header", path, i+1)
+			}
+			name, sub, hasSub := strings.Cut(line[1:end], " ")
+			section = strings.ToLower(name)
+			if hasSub {
+				section += "." + strings.Trim(strings.TrimSpace(sub), `"`)
+			}
+			continue
+		}
+		key, value, hasValue := strings.Cut(line, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !hasValue {
+			value = "true" // A bare key is a boolean
+		}
+		config[section+"."+key] = parseConfigValue(value)
+	}
+	return config, nil
+}
+
+// parseConfigValue unquotes a config value and strips its trailing comment.
+func parseConfigValue(value string) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && i+1 < len(value):
+			i++
+			switch value[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(value[i])
+			}
+		case (c == '#' || c == ';') && !quoted:
+			return strings.TrimSpace(b.String())
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
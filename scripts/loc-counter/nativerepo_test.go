@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNativeRepo_MatchesExec(t *testing.T) {
+	repo := newTestRepo(t)
+	var big strings.Builder
+	for i := range 300 {
+		big.WriteString("line number " + strings.Repeat("x", i%7) + "\n")
+	}
+	edit := func(n int) {
+		repo.write("big.txt", strings.Replace(big.String(), "line number \n", "edit "+strings.Repeat("!", n)+"\n", n))
+	}
+
+	repo.write("big.txt", big.String())
+	repo.write("src/main.go", "package main\n")
+	repo.commit("2024-01-01T10:00:00+02:00", "First\n\nWith a body")
+	repo.git("tag", "-a", "v1", "-m", "Release 1")
+	for i := range 3 {
+		edit(i + 1)
+		repo.commit("2024-01-0"+string(rune('2'+i))+"T23:30:00-05:00", "Edit\nwrapped subject")
+	}
+	repo.git("checkout", "-q", "-b", "side", "HEAD~1")
+	repo.write("side.txt", "side\n")
+	repo.commit("2024-01-06T10:00:00Z", "Side")
+	repo.git("checkout", "-q", "main")
+	repo.gitEnv([]string{"GIT_AUTHOR_DATE=2024-01-07T10:00:00Z", "GIT_COMMITTER_DATE=2024-01-07T10:00:00Z"},
+		"merge", "-q", "--no-ff", "-m", "Merge side", "side")
+	// Pack everything so far with offset deltas
+	repo.git("repack", "-a", "-d", "-q")
+
+	for i := range 2 {
+		edit(i + 10)
+		repo.commit("2024-01-0"+string(rune('8'+i))+"T10:00:00Z", "More")
+	}
+	// Pack the new objects with ref deltas, as older git versions and some servers do
+	pack := exec.Command("git", "-C", repo.dir, "pack-objects", "-q", ".git/objects/pack/pack")
+	pack.Stdin = strings.NewReader(repo.git("rev-list", "--objects", "v1..main"))
+	if output, err := pack.CombinedOutput(); err != nil {
+		t.Fatalf("pack-objects failed: %v\n%s", err, output)
+	}
+	repo.git("prune-packed")
+	// And leave the last commit loose
+	edit(20)
+	repo.commit("2024-01-10T10:00:00Z", "Loose")
+
+	execRepo, nativeRepo := repo.open(backendExec), repo.open(backendNative)
+	if execRepo.dir() != nativeRepo.dir() || execRepo.gitDir() != nativeRepo.gitDir() {
+		t.Errorf("dirs = %s %s, want %s %s", nativeRepo.dir(), nativeRepo.gitDir(), execRepo.dir(), execRepo.gitDir())
+	}
+
+	for _, rev := range []string{"main", "v1..main", "side"} {
+		want, err := execRepo.log(rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := nativeRepo.log(rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Compare as text, since root commits have nil or empty parents depending on the backend
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("log(%s) =\n%+v\nwant\n%+v", rev, got, want)
+		}
+	}
+
+	head := strings.TrimSpace(repo.git("rev-parse", "HEAD"))
+	for _, rev := range []string{"HEAD", "HEAD~3", "main^2", "main~1^2~1", "v1", "v1^{commit}", "v1^0", head[:7], "@", "side~99", "nope", "HEAD:big.txt"} {
+		wantHash, wantOK, err := execRepo.resolveCommit(rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotHash, gotOK, err := nativeRepo.resolveCommit(rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotHash != wantHash || gotOK != wantOK {
+			t.Errorf("resolveCommit(%q) = %s %v, want %s %v", rev, gotHash, gotOK, wantHash, wantOK)
+		}
+	}
+
+	var hashes []string
+	for line := range strings.SplitSeq(repo.git("rev-list", "--objects", "--all"), "\n") {
+		if hash, _, _ := strings.Cut(line, " "); hash != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	hashes = append(hashes, strings.Repeat("0", 40))
+	want, err := execRepo.read(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := nativeRepo.read(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range hashes {
+		if got[i].typ != want[i].typ || got[i].missing != want[i].missing || !bytes.Equal(got[i].data, want[i].data) {
+			t.Errorf("read(%s) = %s %d bytes, want %s %d bytes", hashes[i], got[i].typ, len(got[i].data), want[i].typ, len(want[i].data))
+		}
+	}
+
+	streamed := make([]string, len(hashes))
+	if _, err := nativeRepo.stream(hashes, func(i int, _ gitObject, content io.Reader) {
+		data, _ := io.ReadAll(content)
+		streamed[i] = string(data)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := range hashes {
+		if streamed[i] != string(want[i].data) {
+			t.Errorf("stream(%s) = %d bytes, want %d", hashes[i], len(streamed[i]), len(want[i].data))
+		}
+	}
+}
+
+func TestReadGitConfig(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write(".git/config", `[core]
+	bare = false
+[remote "origin"]
+	url = "git@github.com:Owner/Repo.git" ; a comment
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[Extensions]
+	worktreeConfig
+`)
+	config, err := readGitConfig(repo.dir + "/.git/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"core.bare":                 "false",
+		"remote.origin.url":         "git@github.com:Owner/Repo.git",
+		"remote.origin.fetch":       "+refs/heads/*:refs/remotes/origin/*",
+		"extensions.worktreeconfig": "true",
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("readGitConfig() = %v, want %v", config, want)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// treeEntry is one entry of a git tree object.
+type treeEntry struct {
+	mode string // Like "100644", "40000" (tree), or "160000" (submodule)
+	name string
+	hash string
+}
+
+func (e treeEntry) isTree() bool      { return e.mode == "40000" }
+func (e treeEntry) isSubmodule() bool { return e.mode == "160000" }
+
+// treeCacheMaxEntries bounds the tree cache. Trees are small, but big repos have a lot of them.
+const treeCacheMaxEntries = 200_000
+
+// trees caches parsed tree objects by hash. Consecutive commits share most of their trees, so this
+// saves most reads when listing and diffing. Trees are content-addressed, so the cache is shared by all
+// repos.
+var trees = struct {
+	sync.Mutex
+	entries map[string][]treeEntry
+}{entries: make(map[string][]treeEntry)}
+
+// readTrees returns the entries of each tree, in order. Uncached trees are read in a single batch.
+func readTrees(repo repository, hashes []string) ([][]treeEntry, error) {
+	result := make([][]treeEntry, len(hashes))
+	var missing []string
+	var missingIdx []int
+
+	trees.Lock()
+	for i, h := range hashes {
+		if entries, ok := trees.entries[h]; ok {
+			result[i] = entries
+		} else {
+			missing = append(missing, h)
+			missingIdx = append(missingIdx, i)
+		}
+	}
+	trees.Unlock()
+
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	objs, err := repo.read(missing)
+	if err != nil {
+		return nil, err
+	}
+
+	trees.Lock()
+	defer trees.Unlock()
+	if len(trees.entries)+len(objs) > treeCacheMaxEntries {
+		trees.entries = make(map[string][]treeEntry)
+	}
+	for i, obj := range objs {
+		if obj.missing || obj.typ != "tree" {
+			return nil, fmt.Errorf("not a tree: %s", missing[i])
+		}
+		entries, err := parseTree(obj.data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tree %s: %w", obj.hash, err)
+		}
+		trees.entries[obj.hash] = entries
+		result[missingIdx[i]] = entries
+	}
+	return result, nil
+}
+
+// parseTree parses the binary tree format: "<mode> <name>\0<20-byte hash>" per entry.
+func parseTree(data []byte) ([]treeEntry, error) {
+	var entries []treeEntry
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if space < 0 || nul < space || len(data) < nul+21 {
+			return nil, fmt.Errorf("truncated entry")
+		}
+		entries = append(entries, treeEntry{
+			mode: string(data[:space]),
+			name: string(data[space+1 : nul]),
+			hash: hex.EncodeToString(data[nul+1 : nul+21]),
+		})
+		data = data[nul+21:]
+	}
+	return entries, nil
+}
+
+// commitTree returns the root tree hash of a commit.
+func commitTree(repo repository, commitHash string) (string, error) {
+	objs, err := repo.read([]string{commitHash})
+	if err != nil {
+		return "", err
+	}
+	if objs[0].missing || objs[0].typ != "commit" {
+		return "", fmt.Errorf("not a commit: %s", commitHash)
+	}
+	header, _, _ := strings.Cut(string(objs[0].data), "\n")
+	tree, ok := strings.CutPrefix(header, "tree ")
+	if !ok {
+		return "", fmt.Errorf("commit %s has no tree", commitHash)
+	}
+	return tree, nil
+}
+
+func joinPath(base, name string) string {
+	if base == "" {
+		return name
+	}
+	return base + "/" + name
+}
+
+// listTreeFiles lists all files under a tree, reading one directory level per batch.
+// Submodules, skipped directories, and files outside scope are left out, other than .gitattributes files,
+// which apply to the files in scope below them.
+func listTreeFiles(repo repository, scope *pathScope, treeHash, base string) ([]fileEntry, error) {
+	type dir struct{ path, hash string }
+	var files []fileEntry
+	level := []dir{{base, treeHash}}
+
+	for len(level) > 0 {
+		hashes := make([]string, len(level))
+		for i, d := range level {
+			hashes[i] = d.hash
+		}
+		allEntries, err := readTrees(repo, hashes)
+		if err != nil {
+			return nil, err
+		}
+
+		var next []dir
+		for i, entries := range allEntries {
+			for _, e := range entries {
+				path := joinPath(level[i].path, e.name)
+				switch {
+				case e.isTree():
+					if !scope.skipsDir(e.name) && scope.walksInto(path) {
+						next = append(next, dir{path, e.hash})
+					}
+				case !e.isSubmodule() && (scope.includes(path) || e.name == gitattributesFile):
+					files = append(files, fileEntry{path: path, blob: e.hash})
+				}
+			}
+		}
+		level = next
+	}
+	return files, nil
+}
+
+// diffTrees appends the file changes in scope between two trees to changes, along with changes to
+// .gitattributes files anywhere it walks. Either hash may be empty for an empty tree. Subtrees with the same hash are skipped without being read.
+func diffTrees(repo repository, scope *pathScope, prevHash, currHash, base string, changes []fileChange) ([]fileChange, error) {
+	if prevHash == currHash {
+		return changes, nil
+	}
+	if prevHash == "" || currHash == "" {
+		hash := prevHash + currHash
+		files, err := listTreeFiles(repo, scope, hash, base)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if prevHash == "" {
+				changes = append(changes, fileChange{path: f.path, blob: f.blob})
+			} else {
+				changes = append(changes, fileChange{path: f.path, oldBlob: f.blob, deleted: true})
+			}
+		}
+		return changes, nil
+	}
+
+	both, err := readTrees(repo, []string{prevHash, currHash})
+	if err != nil {
+		return nil, err
+	}
+	prevEntries := make(map[string]treeEntry, len(both[0]))
+	for _, e := range both[0] {
+		prevEntries[e.name] = e
+	}
+	currNames := make(map[string]bool, len(both[1]))
+
+	// subtree returns the hash of e if it's a tree we walk into, empty otherwise
+	subtree := func(e treeEntry, ok bool, path string) string {
+		if ok && e.isTree() && !scope.skipsDir(e.name) && scope.walksInto(path) {
+			return e.hash
+		}
+		return ""
+	}
+	isFile := func(e treeEntry, ok bool, path string) bool {
+		return ok && !e.isTree() && !e.isSubmodule() && (scope.includes(path) || e.name == gitattributesFile)
+	}
+
+	for _, curr := range both[1] {
+		currNames[curr.name] = true
+		prev, hadPrev := prevEntries[curr.name]
+		path := joinPath(base, curr.name)
+
+		if changes, err = diffTrees(repo, scope, subtree(prev, hadPrev, path), subtree(curr, true, path), path, changes); err != nil {
+			return nil, err
+		}
+		currFile, prevFile := isFile(curr, true, path), isFile(prev, hadPrev, path)
+		switch {
+		case currFile && prevFile && prev.hash != curr.hash:
+			changes = append(changes, fileChange{path: path, blob: curr.hash, oldBlob: prev.hash})
+		case currFile && !prevFile:
+			changes = append(changes, fileChange{path: path, blob: curr.hash})
+		case prevFile && !currFile:
+			changes = append(changes, fileChange{path: path, oldBlob: prev.hash, deleted: true})
+		}
+	}
+
+	for _, prev := range both[0] {
+		if currNames[prev.name] {
+			continue
+		}
+		path := joinPath(base, prev.name)
+		if changes, err = diffTrees(repo, scope, subtree(prev, true, path), "", path, changes); err != nil {
+			return nil, err
+		}
+		if isFile(prev, true, path) {
+			changes = append(changes, fileChange{path: path, oldBlob: prev.hash, deleted: true})
+		}
+	}
+	return changes, nil
+}
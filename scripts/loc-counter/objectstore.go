@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errObjectNotFound is returned for objects that aren't in the store.
+var errObjectNotFound = errors.New("object not found")
+
+// maxAlternatesDepth bounds how deep objects/info/alternates chains are followed, like git does.
+const maxAlternatesDepth = 5
+
+// deltaBaseCacheSize is how many bytes of delta bases objectStore keeps around.
+const deltaBaseCacheSize = 96 << 20
+
+// objectStore reads objects from a git objects directory and its alternates: loose objects, and packfiles
+// through their indexes. Safe for concurrent use.
+type objectStore struct {
+	dirs  []string // The objects dir, then its alternates
+	bases *deltaBaseCache
+
+	mu    sync.RWMutex
+	packs []*packFile
+}
+
+func openObjectStore(dir string) (*objectStore, error) {
+	s := &objectStore{bases: newDeltaBaseCache(deltaBaseCacheSize)}
+	s.addDir(dir, 0)
+	if err := s.loadPacks(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// addDir adds an objects dir and, recursively, the alternates listed in its info/alternates.
+func (s *objectStore) addDir(dir string, depth int) {
+	if depth > maxAlternatesDepth || slices.Contains(s.dirs, dir) {
+		return
+	}
+	s.dirs = append(s.dirs, dir)
+	data, err := os.ReadFile(filepath.Join(dir, "info", "alternates"))
+	if err != nil {
+		return
+	}
+	for line := range strings.SplitSeq(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(dir, line)
+		}
+		s.addDir(filepath.Clean(line), depth+1)
+	}
+}
+
+// loadPacks opens the packs that aren't open yet. Packs that disappeared (after a repack) are kept open,
+// since their files stay readable until closed.
+func (s *objectStore) loadPacks() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	open := make(map[string]bool, len(s.packs))
+	for _, p := range s.packs {
+		open[p.path] = true
+	}
+	for _, dir := range s.dirs {
+		indexes, err := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+		if err != nil {
+			return fmt.Errorf("failed to list packs: %w", err)
+		}
+		for _, idx := range indexes {
+			path := strings.TrimSuffix(idx, ".idx") + ".pack"
+			if open[path] {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				continue // An index without its pack, like git leaves behind while repacking
+			}
+			pack, err := openPackFile(path)
+			if err != nil {
+				return err
+			}
+			s.packs = append(s.packs, pack)
+		}
+	}
+	return nil
+}
+
+func (s *objectStore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.packs {
+		p.close()
+	}
+	s.packs = nil
+}
+
+// find returns the pack and offset of a packed object, or the path of a loose one.
+func (s *objectStore) find(hash string) (pack *packFile, offset int64, loose string, err error) {
+	id, ok := parseObjectID(hash)
+	if !ok {
+		return nil, 0, "", errObjectNotFound
+	}
+	for attempt := range 2 {
+		s.mu.RLock()
+		for _, p := range s.packs {
+			if offset, ok := p.index.find(id); ok {
+				s.mu.RUnlock()
+				return p, offset, "", nil
+			}
+		}
+		s.mu.RUnlock()
+		for _, dir := range s.dirs {
+			path := filepath.Join(dir, hash[:2], hash[2:])
+			if _, err := os.Stat(path); err == nil {
+				return nil, 0, path, nil
+			}
+		}
+		// A repack may have moved it into a pack we haven't opened yet
+		if attempt == 0 {
+			if err := s.loadPacks(); err != nil {
+				return nil, 0, "", err
+			}
+		}
+	}
+	return nil, 0, "", errObjectNotFound
+}
+
+// readObject returns the type and content of an object. The content may be shared, and must not be modified.
+func (s *objectStore) readObject(hash string) (string, []byte, error) {
+	pack, offset, loose, err := s.find(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	if pack != nil {
+		return s.readPacked(pack, offset)
+	}
+	typ, size, content, err := openLooseObject(loose)
+	if err != nil {
+		return "", nil, err
+	}
+	defer content.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(content, data); err != nil {
+		return "", nil, fmt.Errorf("failed to read object %s: %w", hash, err)
+	}
+	return typ, data, nil
+}
+
+// openObject returns the type and size of an object, and a reader for its content. Loose objects and
+// undeltified packed objects are inflated as they're read, deltified ones are resolved up front.
+func (s *objectStore) openObject(hash string) (string, int64, io.ReadCloser, error) {
+	pack, offset, loose, err := s.find(hash)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if pack == nil {
+		return openLooseObject(loose)
+	}
+	entry, err := pack.entry(offset)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if typ, ok := packObjectTypes[entry.typ]; ok {
+		content, err := pack.inflater(entry)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		return typ, entry.size, content, nil
+	}
+	typ, data, err := s.readPacked(pack, offset)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	return typ, int64(len(data)), io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// readPacked returns the type and content of the object at offset in pack, resolving deltas: it walks down
+// the delta chain to a base it has (cached, undeltified, or loose), then applies the deltas back up.
+func (s *objectStore) readPacked(pack *packFile, offset int64) (string, []byte, error) {
+	type link struct {
+		pack  *packFile
+		entry packEntry
+	}
+	var chain []link
+	var typ string
+	var data []byte
+
+	for {
+		if len(chain) > maxDeltaDepth {
+			return "", nil, fmt.Errorf("delta chain too long in %s", pack.path)
+		}
+		if cached, ok := s.bases.get(pack, offset); ok {
+			typ, data = cached.typ, cached.data
+			break
+		}
+		entry, err := pack.entry(offset)
+		if err != nil {
+			return "", nil, err
+		}
+		if name, ok := packObjectTypes[entry.typ]; ok {
+			if data, err = pack.inflate(entry); err != nil {
+				return "", nil, err
+			}
+			typ = name
+			s.bases.put(pack, offset, typ, data)
+			break
+		}
+
+		chain = append(chain, link{pack, entry})
+		if entry.typ == packOfsDelta {
+			offset = entry.baseOffset
+			continue
+		}
+		basePack, baseOffset, _, err := s.find(entry.baseHash)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to find delta base %s: %w", entry.baseHash, err)
+		}
+		if basePack == nil { // Loose
+			if typ, data, err = s.readObject(entry.baseHash); err != nil {
+				return "", nil, err
+			}
+			break
+		}
+		pack, offset = basePack, baseOffset
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		link := chain[i]
+		delta, err := link.pack.inflate(link.entry)
+		if err != nil {
+			return "", nil, err
+		}
+		if data, err = applyDelta(data, delta); err != nil {
+			return "", nil, fmt.Errorf("failed to apply delta at %d in %s: %w", link.entry.offset, link.pack.path, err)
+		}
+		s.bases.put(link.pack, link.entry.offset, typ, data)
+	}
+	return typ, data, nil
+}
+
+// findAbbrev returns the full hash of the only object whose hash starts with prefix. Returns false if there
+// are none, or more than one.
+func (s *objectStore) findAbbrev(prefix string) (string, bool) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < 4 || len(prefix) > 40 || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return "", false
+	}
+
+	found := make(map[string]bool)
+	s.mu.RLock()
+	for _, p := range s.packs {
+		for _, hash := range p.index.withPrefix(prefix, 2) {
+			found[hash] = true
+		}
+	}
+	s.mu.RUnlock()
+	for _, dir := range s.dirs {
+		entries, err := os.ReadDir(filepath.Join(dir, prefix[:2]))
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if name := prefix[:2] + e.Name(); len(name) == 40 && strings.HasPrefix(name, prefix) {
+				found[name] = true
+			}
+		}
+	}
+	if len(found) != 1 {
+		return "", false
+	}
+	for hash := range found {
+		return hash, true
+	}
+	return "", false
+}
+
+// parseObjectID parses a full lowercase hex SHA-1.
+func parseObjectID(hash string) ([20]byte, bool) {
+	var id [20]byte
+	if len(hash) != 40 || strings.ToLower(hash) != hash {
+		return id, false
+	}
+	if _, err := hex.Decode(id[:], []byte(hash)); err != nil {
+		return id, false
+	}
+	return id, true
+}
+
+// openLooseObject opens a zlib-compressed loose object, and reads its "<type> <size>\0" header.
+func openLooseObject(path string) (string, int64, io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("failed to open object: %w", err)
+	}
+	zr, err := zlib.NewReader(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return "", 0, nil, fmt.Errorf("corrupt object %s: %w", path, err)
+	}
+	r := bufio.NewReader(zr)
+	header, err := r.ReadString(0)
+	typ, sizeStr, ok := strings.Cut(strings.TrimSuffix(header, "\x00"), " ")
+	size, sizeErr := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil || !ok || sizeErr != nil {
+		zr.Close()
+		file.Close()
+		return "", 0, nil, fmt.Errorf("corrupt object %s: bad header", path)
+	}
+	return typ, size, &objectReader{Reader: io.LimitReader(r, size), closers: []io.Closer{zr, file}}, nil
+}
+
+// objectReader reads an object's content, and closes what it reads from.
+type objectReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *objectReader) Close() error {
+	var errs []error
+	for _, c := range r.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
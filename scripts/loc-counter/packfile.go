@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"container/list"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Object types in pack entry headers.
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6 // Delta against an earlier entry in the same pack, by offset
+	packRefDelta = 7 // Delta against any object, by hash
+)
+
+var packObjectTypes = map[int]string{packCommit: "commit", packTree: "tree", packBlob: "blob", packTag: "tag"}
+
+// maxDeltaDepth bounds delta chains, in case of a corrupt pack. git's own limit when packing is 4095.
+const maxDeltaDepth = 10_000
+
+// packFile is an open .pack file and its .idx index.
+type packFile struct {
+	path  string
+	file  *os.File
+	size  int64
+	index *packIndex
+}
+
+func openPackFile(path string) (*packFile, error) {
+	index, err := readPackIndex(strings.TrimSuffix(path, ".pack") + ".idx")
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pack: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open pack: %w", err)
+	}
+	header := make([]byte, 12)
+	if _, err := file.ReadAt(header, 0); err != nil || string(header[:4]) != "PACK" {
+		file.Close()
+		return nil, fmt.Errorf("not a pack: %s", path)
+	}
+	if version := binary.BigEndian.Uint32(header[4:]); version != 2 && version != 3 {
+		file.Close()
+		return nil, fmt.Errorf("unsupported pack version %d: %s", version, path)
+	}
+	return &packFile{path: path, file: file, size: info.Size(), index: index}, nil
+}
+
+func (p *packFile) close() {
+	p.file.Close()
+}
+
+// packEntry is the header of one object in a pack.
+type packEntry struct {
+	offset     int64 // Where the entry starts
+	typ        int
+	size       int64  // Inflated size of the object, or of the delta for deltas
+	dataOffset int64  // Where the zlib stream starts
+	baseOffset int64  // For packOfsDelta
+	baseHash   string // For packRefDelta
+}
+
+// entry reads the header of the entry at offset: a type and size varint, then for deltas, the base.
+func (p *packFile) entry(offset int64) (packEntry, error) {
+	var buf [32]byte
+	n, err := p.file.ReadAt(buf[:], offset)
+	if n == 0 || (err != nil && !errors.Is(err, io.EOF)) {
+		return packEntry{}, fmt.Errorf("failed to read pack entry at %d in %s: %w", offset, p.path, err)
+	}
+	data := buf[:n]
+	corrupt := fmt.Errorf("corrupt pack entry at %d in %s", offset, p.path)
+
+	e := packEntry{offset: offset, typ: int(data[0]>>4) & 7, size: int64(data[0] & 15)}
+	i, shift := 1, 4
+	for data[i-1]&0x80 != 0 {
+		if i >= len(data) || shift > 56 {
+			return packEntry{}, corrupt
+		}
+		e.size |= int64(data[i]&0x7f) << shift
+		shift += 7
+		i++
+	}
+
+	switch e.typ {
+	case packOfsDelta:
+		// Big-endian, with 1 added for each continuation byte so there's only one encoding per offset
+		if i >= len(data) {
+			return packEntry{}, corrupt
+		}
+		b := data[i]
+		i++
+		back := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if i >= len(data) || back > 1<<48 {
+				return packEntry{}, corrupt
+			}
+			b = data[i]
+			i++
+			back = (back+1)<<7 | int64(b&0x7f)
+		}
+		if back <= 0 || back > offset {
+			return packEntry{}, corrupt
+		}
+		e.baseOffset = offset - back
+	case packRefDelta:
+		if i+20 > len(data) {
+			return packEntry{}, corrupt
+		}
+		e.baseHash = hex.EncodeToString(data[i : i+20])
+		i += 20
+	default:
+		if _, ok := packObjectTypes[e.typ]; !ok {
+			return packEntry{}, corrupt
+		}
+	}
+	e.dataOffset = offset + int64(i)
+	return e, nil
+}
+
+// inflater returns a reader for the inflated data of e, which is e.size bytes long.
+func (p *packFile) inflater(e packEntry) (io.ReadCloser, error) {
+	section := io.NewSectionReader(p.file, e.dataOffset, p.size-e.dataOffset)
+	zr, err := zlib.NewReader(bufio.NewReader(section))
+	if err != nil {
+		return nil, fmt.Errorf("corrupt pack entry at %d in %s: %w", e.offset, p.path, err)
+	}
+	return &objectReader{Reader: io.LimitReader(zr, e.size), closers: []io.Closer{zr}}, nil
+}
+
+// inflate returns the inflated data of e.
+func (p *packFile) inflate(e packEntry) ([]byte, error) {
+	if e.size > p.size*1032 { // zlib can't compress better than about 1032:1
+		return nil, fmt.Errorf("corrupt pack entry at %d in %s: size %d", e.offset, p.path, e.size)
+	}
+	r, err := p.inflater(e)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data := make([]byte, e.size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("failed to inflate pack entry at %d in %s: %w", e.offset, p.path, err)
+	}
+	return data, nil
+}
+
+// packIndex is a version 2 .idx file: a fanout table of cumulative counts by first hash byte, the sorted
+// hashes, their CRCs, their 31-bit offsets, and a table of 64-bit offsets for entries past 2 GiB.
+type packIndex struct {
+	fanout       [256]uint32
+	hashes       []byte // 20 bytes per object, sorted
+	offsets      []byte // 4 bytes per object
+	largeOffsets []byte // 8 bytes per large offset
+}
+
+func readPackIndex(path string) (*packIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pack index: %w", err)
+	}
+	corrupt := fmt.Errorf("corrupt pack index %s", path)
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], []byte("\xfftOc")) {
+		return nil, fmt.Errorf("unsupported pack index %s (only version 2 is)", path)
+	}
+	if version := binary.BigEndian.Uint32(data[4:]); version != 2 {
+		return nil, fmt.Errorf("unsupported pack index version %d: %s", version, path)
+	}
+
+	idx := &packIndex{}
+	for i := range idx.fanout {
+		idx.fanout[i] = binary.BigEndian.Uint32(data[8+i*4:])
+		if i > 0 && idx.fanout[i] < idx.fanout[i-1] {
+			return nil, corrupt
+		}
+	}
+	n := int(idx.fanout[255])
+	pos := 8 + 256*4
+	if len(data) < pos+n*(20+4+4)+40 {
+		return nil, corrupt
+	}
+	idx.hashes = data[pos : pos+n*20]
+	pos += n * 20
+	pos += n * 4 // CRCs
+	idx.offsets = data[pos : pos+n*4]
+	pos += n * 4
+	idx.largeOffsets = data[pos : len(data)-40] // Before the pack and index checksums
+	return idx, nil
+}
+
+func (idx *packIndex) hash(i int) []byte {
+	return idx.hashes[i*20 : i*20+20]
+}
+
+// find returns the pack offset of an object.
+func (idx *packIndex) find(id [20]byte) (int64, bool) {
+	lo, hi := 0, int(idx.fanout[id[0]])
+	if id[0] > 0 {
+		lo = int(idx.fanout[id[0]-1])
+	}
+	i := lo + sort.Search(hi-lo, func(i int) bool { return bytes.Compare(idx.hash(lo+i), id[:]) >= 0 })
+	if i >= hi || !bytes.Equal(idx.hash(i), id[:]) {
+		return 0, false
+	}
+	offset := binary.BigEndian.Uint32(idx.offsets[i*4:])
+	if offset&0x80000000 == 0 {
+		return int64(offset), true
+	}
+	large := int(offset&0x7fffffff) * 8
+	if large+8 > len(idx.largeOffsets) {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(idx.largeOffsets[large:])), true
+}
+
+// withPrefix returns up to limit hashes that start with the lowercase hex prefix.
+func (idx *packIndex) withPrefix(prefix string, limit int) []string {
+	n := int(idx.fanout[255])
+	start := sort.Search(n, func(i int) bool { return hex.EncodeToString(idx.hash(i)) >= prefix })
+	var found []string
+	for i := start; i < n && len(found) < limit; i++ {
+		hash := hex.EncodeToString(idx.hash(i))
+		if !strings.HasPrefix(hash, prefix) {
+			break
+		}
+		found = append(found, hash)
+	}
+	return found
+}
+
+var errCorruptDelta = errors.New("corrupt delta")
+
+// applyDelta builds an object from its base and a git delta: the base size and result size as varints,
+// then instructions that each copy a range of the base or insert literal bytes.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	baseSize, delta, ok := readDeltaSize(delta)
+	if !ok || baseSize != len(base) {
+		return nil, errCorruptDelta
+	}
+	resultSize, delta, ok := readDeltaSize(delta)
+	if !ok {
+		return nil, errCorruptDelta
+	}
+	result := make([]byte, 0, resultSize)
+
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			// Copy: bits 0-3 say which offset bytes follow, bits 4-6 which size bytes
+			var offset, size int
+			for i := range 7 {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errCorruptDelta
+				}
+				if i < 4 {
+					offset |= int(delta[0]) << (8 * i)
+				} else {
+					size |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > len(base) || len(result)+size > resultSize {
+				return nil, errCorruptDelta
+			}
+			result = append(result, base[offset:offset+size]...)
+		case op != 0:
+			// Insert the next op bytes
+			size := int(op)
+			if size > len(delta) || len(result)+size > resultSize {
+				return nil, errCorruptDelta
+			}
+			result = append(result, delta[:size]...)
+			delta = delta[size:]
+		default:
+			return nil, errCorruptDelta
+		}
+	}
+	if len(result) != resultSize {
+		return nil, errCorruptDelta
+	}
+	return result, nil
+}
+
+// readDeltaSize reads a little-endian base-128 varint from the start of a delta.
+func readDeltaSize(delta []byte) (int, []byte, bool) {
+	size, shift := 0, 0
+	for i, b := range delta {
+		if shift > 56 {
+			return 0, nil, false
+		}
+		size |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return size, delta[i+1:], true
+		}
+	}
+	return 0, nil, false
+}
+
+// deltaBaseCache keeps recently resolved objects around, since most objects in a pack are deltas against a
+// few common bases, and long chains share most of their links. Evicts the least recently used entries once
+// they take more than maxBytes. Safe for concurrent use.
+type deltaBaseCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	entries  map[packLocation]*list.Element
+	lru      list.List // Of *cachedBase, most recently used first
+}
+
+type packLocation struct {
+	pack   *packFile
+	offset int64
+}
+
+type cachedBase struct {
+	key  packLocation
+	typ  string
+	data []byte
+}
+
+func newDeltaBaseCache(maxBytes int) *deltaBaseCache {
+	return &deltaBaseCache{maxBytes: maxBytes, entries: make(map[packLocation]*list.Element)}
+}
+
+func (c *deltaBaseCache) get(pack *packFile, offset int64) (*cachedBase, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[packLocation{pack, offset}]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cachedBase), true
+}
+
+func (c *deltaBaseCache) put(pack *packFile, offset int64, typ string, data []byte) {
+	// Big objects would evict everything else, and are rarely bases
+	if len(data) > c.maxBytes/8 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := packLocation{pack, offset}
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cachedBase{key: key, typ: typ, data: data})
+	c.bytes += len(data)
+	for c.bytes > c.maxBytes {
+		oldest := c.lru.Remove(c.lru.Back()).(*cachedBase)
+		delete(c.entries, oldest.key)
+		c.bytes -= len(oldest.data)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello, world\n")
+	tests := []struct {
+		name    string
+		delta   []byte
+		want    string
+		wantErr bool
+	}{
+		// Sizes 13 and 13, copy 7 bytes from offset 0, insert "there\n"
+		{"copy and insert", []byte{13, 13, 0x90, 7, 6, 't', 'h', 'e', 'r', 'e', '\n'}, "hello, there\n", false},
+		// Copy 6 bytes from offset 7, with both an offset and a size byte
+		{"copy with offset", []byte{13, 6, 0x91, 7, 6}, "world\n", false},
+		{"empty result", []byte{13, 0}, "", false},
+		{"wrong base size", []byte{12, 1, 1, 'x'}, "", true},
+		{"copy past base", []byte{13, 20, 0x91, 7, 20}, "", true},
+		{"insert past delta", []byte{13, 5, 5, 'a'}, "", true},
+		{"result too short", []byte{13, 5, 1, 'a'}, "", true},
+		{"reserved op", []byte{13, 1, 0}, "", true},
+		{"truncated size", []byte{0x80}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyDelta(base, tt.delta)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyDelta() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("applyDelta() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pipelineOptions controls how processDays runs, and which files it counts.
+type pipelineOptions struct {
+	workers   int // Number of contiguous chunks to split the days into
+	readers   int // Number of objects the exec backend reads in parallel, or 0 for one per worker
+	scope     *pathScope
+	languages *languageMap
+	breakdown *breakdown
+	// If set, each chunk holds a slot while it runs. Batch mode shares one between repos to limit the workers
+	// running at once.
+	slots       chan struct{}
+	granularity granularity // What each date is a bucket of, for progress messages
+	log         *logger
+}
+
+// processDays counts lines for each day's latest commit, or each bucket's with a coarser or finer granularity.
+// Dates are split into one contiguous chunk per worker. Each worker reads the full tree for the first day of
+// its chunk, then only re-counts the files that changed from one day to the next. Days already in cp are
+// reused, and a worker that starts after them diffs from one of cp's file maps instead of reading a full tree.
+// Progress is saved to cp periodically. Returns stats keyed by date. Stops at the first error, or soon after
+// ctx is canceled, in which case it also returns the stats of the days counted so far.
+func processDays(ctx context.Context, repo repository, dates []string, dailyCommits map[string]commit, opts pipelineOptions, cache *blobCache, cp *checkpoint) (map[string]*fileStats, error) {
+	results := make(map[string]*fileStats, len(dates))
+	var (
+		mu       sync.Mutex
+		firstErr error
+		done     atomic.Int64
+		wg       sync.WaitGroup
+	)
+
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	chunks := splitIntoChunks(dates, opts.workers)
+	cp.workers = len(chunks)
+	stopCheckpoints := saveCheckpoints(cp, opts.log)
+
+	for worker, chunk := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if opts.slots != nil {
+				select {
+				case opts.slots <- struct{}{}:
+					defer func() { <-opts.slots }()
+				case <-ctx.Done():
+					return
+				}
+			}
+			var state *treeState
+			prevHash, prevDate := "", ""
+			var seen int64
+			// Save where this worker got to, so a resumed run can diff from here
+			defer func() {
+				if state != nil && prevHash != "" {
+					cp.snapshot(worker, prevDate, prevHash, state)
+				}
+			}()
+
+			for _, date := range chunk {
+				if failed() || ctx.Err() != nil {
+					return
+				}
+				c := dailyCommits[date]
+				if stats, ok := cp.doneDay(date, c.hash); ok {
+					mu.Lock()
+					results[date] = stats
+					mu.Unlock()
+					done.Add(1)
+					continue
+				}
+				if state == nil {
+					state = newTreeState(repo, cache)
+					state.scope, state.languages, state.breakdown, state.log = opts.scope, opts.languages, opts.breakdown, opts.log
+					prevHash = cp.base(date, state)
+				}
+
+				stats, err := countDay(state, prevHash, c, cache)
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("failed to count lines on %s (%s): %w", date, c.hash, err)
+					}
+					mu.Unlock()
+					state = nil // Possibly half-updated, don't snapshot it
+					return
+				}
+				results[date] = stats
+				mu.Unlock()
+				cp.recordDay(date, c.hash, stats)
+				prevHash, prevDate = c.hash, date
+				cp.maybeSnapshot(worker, &seen, date, c.hash, state)
+
+				n := done.Add(1)
+				opts.log.progressf("Processed %d/%d %s", n, len(dates), opts.granularity.plural())
+			}
+		}()
+	}
+	wg.Wait()
+	stopCheckpoints()
+	opts.log.endProgress()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return results, fmt.Errorf("interrupted after %d/%d %s: %w", done.Load(), len(dates), opts.granularity.plural(), err)
+	}
+	return results, nil
+}
+
+// countDay brings state up to date with the day's commit, and returns its stats with the day's authors,
+// churn, and lines per author. state must hold the files at prevHash, or be empty if prevHash is empty.
+func countDay(state *treeState, prevHash string, c commit, cache *blobCache) (*fileStats, error) {
+	stats, err := countLinesForCommit(state, prevHash, c.hash, c.messages)
+	if err != nil {
+		return nil, err
+	}
+	stats.authors = c.authors
+	rules := state.rules(state.extensionMap())
+	stats.added, stats.removed, err = countChurn(state.repo, rules, c.base, c.hash, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	// A day with a single non-merge commit on top of the previous day has the same churn as that commit
+	if len(c.commits) == 1 && len(c.parents) <= 1 && c.firstParent() == c.base {
+		author := c.commits[0].authors[0]
+		stats.contributorAdded = map[string]int{author: sumValues(stats.added)}
+		stats.contributorRemoved = map[string]int{author: sumValues(stats.removed)}
+		return stats, nil
+	}
+	stats.contributorAdded, stats.contributorRemoved, err = attributeLines(state.repo, rules, c, cache)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// saveCheckpoints saves cp every checkpointInterval, and asks workers for fresh file map snapshots to go
+// into the next save. Returns a function that stops saving.
+func saveCheckpoints(cp *checkpoint, log *logger) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := cp.save(); err != nil {
+					log.warnf("%v", err)
+				}
+				cp.requestSnapshots()
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// splitIntoChunks splits items into at most n contiguous chunks of nearly equal size.
+func splitIntoChunks(items []string, n int) [][]string {
+	n = max(min(n, len(items)), 1)
+	chunks := make([][]string, 0, n)
+	for i := range n {
+		start := i * len(items) / n
+		end := (i + 1) * len(items) / n
+		if start < end {
+			chunks = append(chunks, items[start:end])
+		}
+	}
+	return chunks
+}
+
+// carryForward returns one stats entry per date. Dates without their own stats reuse the previous day's
+// counts, with "-" as the only comment, and no authors or churn (same as gap days in the web app).
+// Also sets each day's running per-author line totals.
+func carryForward(dates []string, dayStats map[string]*fileStats) []*fileStats {
+	days := make([]*fileStats, len(dates))
+	prev := newFileStats(nil)
+	contributors := make(map[string]int)
+	for i, date := range dates {
+		stats, ok := dayStats[date]
+		if !ok {
+			stats = prev.copyWithoutComments()
+			stats.comments = []string{"-"}
+		}
+		for author, n := range stats.contributorAdded {
+			contributors[author] += n
+		}
+		for author, n := range stats.contributorRemoved {
+			contributors[author] -= n
+		}
+		stats.contributors = maps.Clone(contributors)
+		days[i] = stats
+		prev = stats
+	}
+	return days
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun_WritesCSVWithCarriedForwardGaps(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("main.go", "package main\n\nfunc main() {}\n")
+	repo.commit("2024-03-01T10:00:00Z", "Add main")
+	repo.write("README.md", "# Hello\n")
+	repo.commit("2024-03-03T10:00:00Z", "Add readme")
+
+	out := filepath.Join(t.TempDir(), "loc.csv")
+	authors := filepath.Join(t.TempDir(), "authors.csv")
+	err := run(&cliFlags{repoPath: repo.dir, ref: "main", output: out, format: "csv", authors: authors, workers: 2})
+	if err != nil {
+		t.Fatalf("run() error: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	expected := []string{
+		"date,total,added,removed,go,go prod,go test,go code,go comment,go blank,go added,go removed,docs,docs code,docs comment,docs blank,docs added,docs removed,comments,authors",
+		"2024-03-01,3,3,0,3,3,0,2,0,1,3,0,0,0,0,0,0,0,Add main,Test Author <author@example.com>",
+		"2024-03-02,3,0,0,3,3,0,2,0,1,0,0,0,0,0,0,0,0,-,",
+		"2024-03-03,4,1,0,3,3,0,2,0,1,0,0,1,1,0,0,1,0,Add readme,Test Author <author@example.com>",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("CSV =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+
+	data, err = os.ReadFile(authors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	expected = []string{
+		"date,author,lines,added,removed",
+		"2024-03-01,Test Author <author@example.com>,3,3,0",
+		"2024-03-02,Test Author <author@example.com>,3,0,0",
+		"2024-03-03,Test Author <author@example.com>,4,1,0",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("contributors CSV =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestRun_Granularity(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("main.go", "package main\n")
+	repo.commit("2024-03-01T10:00:00Z", "Add main")
+	repo.write("lib.go", "package main\n\nfunc lib() {}\n")
+	repo.commit("2024-03-03T10:00:00Z", "Add lib") // A Sunday, so still in the first week
+	repo.write("README.md", "# Hello\n")
+	repo.commit("2024-03-03T12:00:00Z", "Add readme")
+	repo.git("rm", "-q", "lib.go")
+	repo.commit("2024-03-19T10:00:00Z", "Remove lib")
+
+	rows := func(g granularity) []string {
+		t.Helper()
+		out := filepath.Join(t.TempDir(), "loc.csv")
+		err := run(&cliFlags{repoPath: repo.dir, ref: "main", output: out, format: "csv", workers: 2, granularity: g})
+		if err != nil {
+			t.Fatalf("run() error: %v", err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows []string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n")[1:] {
+			fields := strings.Split(line, ",")
+			rows = append(rows, strings.Join([]string{fields[0], fields[1], fields[2], fields[3], fields[len(fields)-2]}, ","))
+		}
+		return rows
+	}
+
+	// Each row is the bucket's last commit, with churn and messages from all of its commits
+	want := []string{
+		"2024-W09,5,5,0,Add readme;Add lib;Add main",
+		"2024-W10,5,0,0,-",
+		"2024-W11,5,0,0,-",
+		"2024-W12,2,0,3,Remove lib",
+	}
+	if got := rows(granularityWeek); !reflect.DeepEqual(got, want) {
+		t.Errorf("weekly rows = %q, want %q", got, want)
+	}
+	if got, want := rows(granularityQuarter), []string{"2024-Q1,2,2,0,Remove lib;Add readme;Add lib;Add main"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("quarterly rows = %q, want %q", got, want)
+	}
+
+	got := rows(granularityCommit)
+	if len(got) != 4 {
+		t.Fatalf("per-commit rows = %q, want one per commit", got)
+	}
+	for i, prefix := range []string{"2024-03-01 ", "2024-03-03 ", "2024-03-03 ", "2024-03-19 "} {
+		if !strings.HasPrefix(got[i], prefix) {
+			t.Errorf("row %d = %q, want it labeled with %q and the commit's short hash", i, got[i], prefix)
+		}
+	}
+	if !strings.HasSuffix(got[1], ",4,3,0,Add lib") || !strings.HasSuffix(got[2], ",5,1,0,Add readme") {
+		t.Errorf("per-commit rows = %q, want the two commits of 2024-03-03 in their own rows", got)
+	}
+}
+
+func TestRun_Dates(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("main.go", "package main\n")
+	repo.commit("2024-03-01T10:00:00Z", "Add main")
+	// Written before main.go, and rebased onto it late on March 2 in New York
+	repo.write("lib.go", "package main\n\nfunc lib() {}\n")
+	repo.git("add", "-A")
+	repo.gitEnv([]string{"GIT_AUTHOR_DATE=2024-02-20T10:00:00Z", "GIT_COMMITTER_DATE=2024-03-02T23:30:00-05:00"},
+		"commit", "-q", "-m", "Add lib")
+
+	rows := func(dates dateOptions) []string {
+		t.Helper()
+		out := filepath.Join(t.TempDir(), "loc.csv")
+		err := run(&cliFlags{repoPath: repo.dir, ref: "main", output: out, format: "csv", workers: 2, history: historyOptions{dates: dates}})
+		if err != nil {
+			t.Fatalf("run() error: %v", err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows []string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n")[1:] {
+			fields := strings.Split(line, ",")
+			rows = append(rows, strings.Join([]string{fields[0], fields[1], fields[len(fields)-2]}, ","))
+		}
+		return rows
+	}
+
+	tests := []struct {
+		name  string
+		dates dateOptions
+		want  []string
+	}{
+		{"committer date in its own time zone", dateOptions{}, []string{"2024-03-01,1,Add main", "2024-03-02,4,Add lib"}},
+		{"committer date in UTC", dateOptions{location: time.UTC},
+			[]string{"2024-03-01,1,Add main", "2024-03-02,1,-", "2024-03-03,4,Add lib"}},
+		// The author date is before the parent's, so it's counted on the parent's day
+		{"author date", dateOptions{author: true}, []string{"2024-03-01,4,Add lib;Add main"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rows(tt.dates); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_FirstParent(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("main.go", "package main\n")
+	repo.commit("2024-03-01T10:00:00Z", "Add main")
+	repo.git("checkout", "-q", "-b", "feature")
+	repo.write("feature.go", "package main\n\nfunc feature() {}\n")
+	repo.git("add", "-A")
+	repo.gitEnv([]string{"GIT_AUTHOR_NAME=Feature Dev", "GIT_AUTHOR_EMAIL=dev@example.com",
+		"GIT_AUTHOR_DATE=2024-03-02T10:00:00Z", "GIT_COMMITTER_DATE=2024-03-02T10:00:00Z"}, "commit", "-q", "-m", "Add feature")
+	repo.git("checkout", "-q", "main")
+	repo.write("main.go", "package main\n\n")
+	repo.commit("2024-03-03T10:00:00Z", "Fix main")
+	repo.gitEnv([]string{"GIT_AUTHOR_DATE=2024-03-04T10:00:00Z", "GIT_COMMITTER_DATE=2024-03-04T10:00:00Z"},
+		"merge", "-q", "--no-ff", "-m", "Merge branch 'feature'", "feature")
+
+	analyzeRows := func(history historyOptions) (rows, contributors []string) {
+		t.Helper()
+		out := filepath.Join(t.TempDir(), "loc.csv")
+		authors := filepath.Join(t.TempDir(), "authors.csv")
+		err := run(&cliFlags{repoPath: repo.dir, ref: "main", output: out, format: "csv", authors: authors, workers: 2, history: history})
+		if err != nil {
+			t.Fatalf("run() error: %v", err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n")[1:] {
+			fields := strings.Split(line, ",")
+			rows = append(rows, strings.Join([]string{fields[0], fields[1], fields[len(fields)-2], fields[len(fields)-1]}, ","))
+		}
+		data, err = os.ReadFile(authors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows, strings.Split(strings.TrimSpace(string(data)), "\n")[1:]
+	}
+
+	// March 2 is counted at the feature commit, which was never the state of main
+	rows, _ := analyzeRows(historyOptions{})
+	want := []string{
+		"2024-03-01,1,Add main,Test Author <author@example.com>",
+		"2024-03-02,4,Add feature,Feature Dev <dev@example.com>",
+		"2024-03-03,2,Fix main,Test Author <author@example.com>",
+		"2024-03-04,5,Merge branch 'feature',Test Author <author@example.com>",
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+
+	rows, contributors := analyzeRows(historyOptions{firstParent: true, markMerges: true})
+	want = []string{
+		"2024-03-01,1,Add main,Test Author <author@example.com>",
+		"2024-03-02,1,-,",
+		"2024-03-03,2,Fix main,Test Author <author@example.com>",
+		"2024-03-04,5,[merge] Merge branch 'feature',Test Author <author@example.com>;Feature Dev <dev@example.com>",
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("first-parent rows = %q, want %q", rows, want)
+	}
+	// The merged commit's lines still go to its author
+	if got := contributors[len(contributors)-2:]; !reflect.DeepEqual(got, []string{
+		"2024-03-04,Feature Dev <dev@example.com>,3,3,0",
+		"2024-03-04,Test Author <author@example.com>,2,0,0",
+	}) {
+		t.Errorf("first-parent contributors = %q, want Feature Dev's 3 lines on the day of the merge", got)
+	}
+}
+
+func TestRun_ScopeAndBreakdown(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("services/api/main.go", "package main\n\nfunc main() {}\n")
+	repo.write("services/api/gen/types.go", "package gen\n")
+	repo.write("services/web/app.ts", "export {}\n")
+	repo.write("README.md", "# Monorepo\n")
+	repo.commit("2024-03-01T10:00:00Z", "Add services")
+	repo.write("services/api/main.go", "package main\n")
+	repo.write("libs/log/log.go", "package log\n\nfunc Log() {}\n")
+	repo.commit("2024-03-02T10:00:00Z", "Add log lib")
+
+	dir := t.TempDir()
+	out, breakdown := filepath.Join(dir, "loc.csv"), filepath.Join(dir, "breakdown.csv")
+	err := run(&cliFlags{repoPath: repo.dir, ref: "main", output: out, format: "csv", workers: 2,
+		include: []string{"services", "libs"}, exclude: []string{"gen"}, groupFile: breakdown, depth: 2})
+	if err != nil {
+		t.Fatalf("run() error: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	// README.md and the generated file don't count, and neither do their changes
+	expected := []string{
+		"date,total,added,removed,go,go prod,go test,go code,go comment,go blank,go added,go removed,typescript,typescript prod,typescript test,typescript code,typescript comment,typescript blank,typescript added,typescript removed,comments,authors",
+		"2024-03-01,4,4,0,3,3,0,2,0,1,3,0,1,1,0,1,0,0,1,0,Add services,Test Author <author@example.com>",
+		"2024-03-02,5,3,2,4,4,0,3,0,1,3,2,1,1,0,1,0,0,0,0,Add log lib,Test Author <author@example.com>",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("CSV =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+
+	data, err = os.ReadFile(breakdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	expected = []string{
+		"date,group,total,go,go prod,go test,go code,go comment,go blank,typescript,typescript prod,typescript test,typescript code,typescript comment,typescript blank",
+		"2024-03-01,libs/log,0,0,0,0,0,0,0,0,0,0,0,0,0",
+		"2024-03-01,services/api,3,3,3,0,2,0,1,0,0,0,0,0,0",
+		"2024-03-01,services/web,1,0,0,0,0,0,0,1,1,0,1,0,0",
+		"2024-03-02,libs/log,3,3,3,0,2,0,1,0,0,0,0,0,0",
+		"2024-03-02,services/api,1,1,1,0,1,0,0,0,0,0,0,0,0",
+		"2024-03-02,services/web,1,0,0,0,0,0,0,1,1,0,1,0,0",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("breakdown CSV =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestRun_RepoConfig(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write(".gitstrata.yml", "skip-dirs: [generated]\nlanguages:\n  .tpl: html\n")
+	repo.write("main.go", "package main\n")
+	repo.write("generated/api.go", "package generated\n\nvar A = 1\n")
+	repo.write("views/index.tpl", "<p>\n</p>\n")
+	repo.commit("2024-03-01T10:00:00Z", "Initial")
+
+	out := filepath.Join(t.TempDir(), "loc.csv")
+	if err := run(&cliFlags{repoPath: repo.dir, ref: "main", output: out, format: "csv", workers: 1}); err != nil {
+		t.Fatalf("run() error: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The config itself counts as config/data
+	if !strings.Contains(string(data), "\n2024-03-01,6,6,0,") {
+		t.Errorf("CSV =\n%s\nwant a total of 6 lines, without generated/", data)
+	}
+	if header := strings.SplitN(string(data), "\n", 2)[0]; !strings.Contains(header, ",html,") {
+		t.Errorf("header = %q, want .tpl files counted as html", header)
+	}
+
+	// --config replaces the repo's config
+	override := filepath.Join(t.TempDir(), "loc.toml")
+	if err := os.WriteFile(override, []byte("skip = [\"*.tpl\"]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = run(&cliFlags{repoPath: repo.dir, ref: "main", output: out, format: "csv", workers: 1, config: override, noCache: true})
+	if err != nil {
+		t.Fatalf("run() error: %v", err)
+	}
+	if data, err = os.ReadFile(out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\n2024-03-01,7,7,0,") {
+		t.Errorf("CSV with --config =\n%s\nwant a total of 7 lines, with generated/ and without views/", data)
+	}
+}
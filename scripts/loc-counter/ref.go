@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// refSpec describes which part of the history to analyze.
+type refSpec struct {
+	name string // As given by the user or detected, like "main" or "v1.0..v2.0"
+	rev  string // Revision argument passed to git log
+	head string // Commit hash at the tip
+}
+
+// resolveRef validates a branch, tag, commit SHA, or A..B range and returns what to pass to git log.
+// An empty ref means the repo's default branch.
+func resolveRef(repo repository, ref string) (refSpec, error) {
+	if ref == "" {
+		detected, err := detectDefaultBranch(repo)
+		if err != nil {
+			return refSpec{}, err
+		}
+		ref = detected
+	}
+
+	if strings.Contains(ref, "...") {
+		return refSpec{}, fmt.Errorf("symmetric ranges are not supported: %s (use A..B)", ref)
+	}
+
+	if from, to, ok := strings.Cut(ref, ".."); ok {
+		if from == "" {
+			from = "HEAD"
+		}
+		if to == "" {
+			to = "HEAD"
+		}
+		if _, err := resolveCommit(repo, from); err != nil {
+			return refSpec{}, err
+		}
+		head, err := resolveCommit(repo, to)
+		if err != nil {
+			return refSpec{}, err
+		}
+		return refSpec{name: ref, rev: from + ".." + to, head: head}, nil
+	}
+
+	head, err := resolveCommit(repo, ref)
+	if err != nil {
+		return refSpec{}, err
+	}
+	return refSpec{name: ref, rev: ref, head: head}, nil
+}
+
+// resolveCommit peels a ref (branch, tag, SHA, or any rev expression) to a commit hash.
+func resolveCommit(repo repository, ref string) (string, error) {
+	hash, ok, err := repo.resolveCommit(ref)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("unknown ref or not a commit: %s", ref)
+	}
+	return hash, nil
+}
+
+// detectDefaultBranch finds the branch to analyze when none is given.
+// Prefers the remote's default branch (refs/remotes/origin/HEAD), as the remote has it rather than a local
+// branch of the same name, which can be behind or have unpushed commits. The local branch is only used if the
+// remote-tracking one is gone. Falls back to the checked-out branch, then main/master, then a detached HEAD.
+func detectDefaultBranch(repo repository) (string, error) {
+	if remoteRef, ok, err := repo.symbolicRef("refs/remotes/origin/HEAD"); err == nil && ok {
+		branch := strings.TrimPrefix(remoteRef, "refs/remotes/origin/")
+		if refExists(repo, remoteRef) {
+			return "origin/" + branch, nil
+		}
+		if refExists(repo, "refs/heads/"+branch) {
+			return branch, nil
+		}
+	}
+
+	if headRef, ok, err := repo.symbolicRef("HEAD"); err == nil && ok {
+		branch := strings.TrimPrefix(headRef, "refs/heads/")
+		if refExists(repo, "refs/heads/"+branch) {
+			return branch, nil
+		}
+	}
+
+	for _, branch := range []string{"main", "master"} {
+		if refExists(repo, "refs/heads/"+branch) {
+			return branch, nil
+		}
+	}
+
+	if refExists(repo, "HEAD") {
+		return "HEAD", nil
+	}
+	return "", fmt.Errorf("could not detect default branch, pass --ref")
+}
+
+func refExists(repo repository, ref string) bool {
+	_, ok, err := repo.resolveCommit(ref)
+	return err == nil && ok
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveRef(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.git("checkout", "-q", "-b", "trunk")
+	repo.write("a.go", "package a\n")
+	repo.commit("2024-01-01T10:00:00Z", "First")
+	first := strings.TrimSpace(repo.git("rev-parse", "HEAD"))
+	repo.git("tag", "-a", "v1", "-m", "Release 1")
+	repo.write("b.go", "package a\n")
+	repo.commit("2024-01-02T10:00:00Z", "Second")
+	second := strings.TrimSpace(repo.git("rev-parse", "HEAD"))
+
+	tests := []struct {
+		name     string
+		ref      string
+		wantName string
+		wantRev  string
+		wantHead string
+	}{
+		{"detects checked-out branch", "", "trunk", "trunk", second},
+		{"branch", "trunk", "trunk", "trunk", second},
+		{"annotated tag peels to commit", "v1", "v1", "v1", first},
+		{"commit SHA", first, first, first, first},
+		{"range", "v1..trunk", "v1..trunk", "v1..trunk", second},
+		{"open-ended range defaults to HEAD", "v1..", "v1..", "v1..HEAD", second},
+	}
+
+	for _, backend := range []string{backendExec, backendNative} {
+		r := repo.open(backend)
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				spec, err := resolveRef(r, tt.ref)
+				if err != nil {
+					t.Fatalf("resolveRef(%q) error: %v", tt.ref, err)
+				}
+				if spec.name != tt.wantName || spec.rev != tt.wantRev || spec.head != tt.wantHead {
+					t.Errorf("resolveRef(%q) = %+v, want name=%s rev=%s head=%s", tt.ref, spec, tt.wantName, tt.wantRev, tt.wantHead)
+				}
+			})
+		}
+
+		for _, bad := range []string{"nope", "v1...trunk", "v1..nope"} {
+			if _, err := resolveRef(r, bad); err == nil {
+				t.Errorf("%s: resolveRef(%q) should fail", backend, bad)
+			}
+		}
+	}
+}
+
+func TestDetectDefaultBranch_PrefersOriginHead(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.commit("2024-01-01T10:00:00Z", "First")
+	repo.git("checkout", "-q", "-b", "feature")
+	repo.git("update-ref", "refs/remotes/origin/develop", "HEAD")
+	repo.git("symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/develop")
+
+	for _, backend := range []string{backendExec, backendNative} {
+		got, err := detectDefaultBranch(repo.open(backend))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "origin/develop" {
+			t.Errorf("%s: detectDefaultBranch() = %q, want %q", backend, got, "origin/develop")
+		}
+	}
+
+	// A local branch of the same name can be out of date, so the remote's still wins
+	repo.git("branch", "develop", "HEAD")
+	repo.git("checkout", "-q", "develop")
+	repo.commit("2024-01-02T10:00:00Z", "Unpushed")
+	for _, backend := range []string{backendExec, backendNative} {
+		if got, _ := detectDefaultBranch(repo.open(backend)); got != "origin/develop" {
+			t.Errorf("%s: detectDefaultBranch() = %q, want %q over the local branch", backend, got, "origin/develop")
+		}
+	}
+
+	// Without the remote-tracking branch, the local one is next best
+	repo.git("update-ref", "-d", "refs/remotes/origin/develop")
+	for _, backend := range []string{backendExec, backendNative} {
+		if got, _ := detectDefaultBranch(repo.open(backend)); got != "develop" {
+			t.Errorf("%s: detectDefaultBranch() = %q, want local %q", backend, got, "develop")
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// repository reads the history and objects of a git repo. execRepo runs the git binary, and nativeRepo reads
+// the object database directly. Implementations are safe for concurrent use.
+type repository interface {
+	// log lists the commits reachable from rev (a ref, or an A..B range), newest first.
+	log(rev string) ([]logEntry, error)
+	// resolveCommit peels a branch, tag, commit hash, or rev expression like "HEAD~2" to a commit hash.
+	// Returns false if rev doesn't name a commit.
+	resolveCommit(rev string) (string, bool, error)
+	// symbolicRef returns the full name of the ref a symbolic ref like HEAD points to. Returns false if name
+	// isn't a symbolic ref.
+	symbolicRef(name string) (string, bool, error)
+	// remoteURL returns the URL of a remote. Returns false if there's no such remote.
+	remoteURL(name string) (string, bool, error)
+
+	// read returns the type, size, and content of each object, in order. Takes full hashes only.
+	read(hashes []string) ([]gitObject, error)
+	// stream reads each object and passes its content to handle without buffering it. Returns the objects
+	// (without data), in order. handle may be called more than once for the same object if a read has to
+	// be retried, and never runs concurrently with itself for one call.
+	stream(hashes []string, handle streamHandler) ([]gitObject, error)
+
+	// dir returns the repo's top-level directory, or its git dir if it's bare.
+	dir() string
+	// gitDir returns the absolute path of the repo's git dir, shared by all worktrees.
+	gitDir() string
+	close()
+}
+
+// logEntry is one commit as listed by repository.log.
+type logEntry struct {
+	hash          string
+	authorTime    time.Time // In the author's time zone
+	committerTime time.Time // In the committer's time zone
+	authorName    string
+	authorEmail   string
+	parents       []string
+	subject       string
+}
+
+// Backends for openRepo.
+const (
+	backendAuto   = "auto" // exec if git is installed, native otherwise
+	backendExec   = "exec"
+	backendNative = "native"
+)
+
+// openRepo opens the repo containing path with the given backend. workers is the number of objects the
+// exec backend reads in parallel.
+func openRepo(path, backend string, workers int) (repository, error) {
+	if backend == backendAuto {
+		backend = backendNative
+		if _, err := exec.LookPath("git"); err == nil {
+			backend = backendExec
+		}
+	}
+	switch backend {
+	case backendExec:
+		return openExecRepo(path, workers)
+	case backendNative:
+		return openNativeRepo(path)
+	default:
+		return nil, fmt.Errorf("unknown backend %q (expected auto, exec, or native)", backend)
+	}
+}
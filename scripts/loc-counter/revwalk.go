@@ -0,0 +1,348 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parsedCommit is what we need from a commit object.
+type parsedCommit struct {
+	tree          string
+	parents       []string
+	authorName    string
+	authorEmail   string
+	authorTime    time.Time // In the author's time zone
+	committerTime time.Time // In the committer's time zone
+	subject       string
+}
+
+// parseCommit parses a raw commit object: headers up to the first blank line, then the message.
+func parseCommit(data []byte) (parsedCommit, error) {
+	var c parsedCommit
+	header, message, _ := strings.Cut(string(data), "\n\n")
+	for line := range strings.SplitSeq(header, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			c.tree = value
+		case "parent":
+			c.parents = append(c.parents, value)
+		case "author":
+			name, email, when, err := parseIdent(value)
+			if err != nil {
+				return c, fmt.Errorf("bad author: %w", err)
+			}
+			c.authorName, c.authorEmail, c.authorTime = name, email, when
+		case "committer":
+			_, _, when, err := parseIdent(value)
+			if err != nil {
+				return c, fmt.Errorf("bad committer: %w", err)
+			}
+			c.committerTime = when
+		}
+	}
+	if c.tree == "" {
+		return c, fmt.Errorf("no tree")
+	}
+	c.subject = commitSubject(message)
+	return c, nil
+}
+
+// parseIdent parses "Name <email> <unix time> <+hhmm zone>", as in author and committer headers. A malformed
+// date, which some old imported histories have, reads as the Unix epoch rather than failing.
+func parseIdent(ident string) (name, email string, when time.Time, err error) {
+	open := strings.IndexByte(ident, '<')
+	end := strings.LastIndexByte(ident, '>')
+	if open < 0 || end < open {
+		return "", "", time.Time{}, fmt.Errorf("no email in %q", ident)
+	}
+	name, email = strings.TrimSpace(ident[:open]), ident[open+1:end]
+
+	when = time.Unix(0, 0).UTC()
+	fields := strings.Fields(ident[end+1:])
+	if len(fields) != 2 || len(fields[1]) != 5 {
+		return name, email, when, nil
+	}
+	secs, err1 := strconv.ParseInt(fields[0], 10, 64)
+	hours, err2 := strconv.Atoi(fields[1][1:3])
+	minutes, err3 := strconv.Atoi(fields[1][3:5])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return name, email, when, nil
+	}
+	offset := hours*3600 + minutes*60
+	if fields[1][0] == '-' {
+		offset = -offset
+	}
+	return name, email, time.Unix(secs, 0).In(time.FixedZone("", offset)), nil
+}
+
+// commitSubject returns the first paragraph of a commit message on one line, like git log's %s.
+func commitSubject(message string) string {
+	var lines []string
+	for line := range strings.SplitSeq(message, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.TrimSpace(line) == "" {
+			if len(lines) > 0 {
+				break
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, " ")
+}
+
+// shallowRepository is implemented by repositories that can be shallow clones. Their oldest commits have
+// parents that were never fetched, which git treats as root commits.
+type shallowRepository interface {
+	isShallow(hash string) bool
+}
+
+// readCommit reads and parses a commit. Shallow commits have no parents.
+func readCommit(repo repository, hash string) (parsedCommit, error) {
+	objs, err := repo.read([]string{hash})
+	if err != nil {
+		return parsedCommit{}, err
+	}
+	if objs[0].missing || objs[0].typ != "commit" {
+		return parsedCommit{}, fmt.Errorf("not a commit: %s", hash)
+	}
+	c, err := parseCommit(objs[0].data)
+	if err != nil {
+		return parsedCommit{}, fmt.Errorf("failed to parse commit %s: %w", hash, err)
+	}
+	if shallow, ok := repo.(shallowRepository); ok && shallow.isShallow(hash) {
+		c.parents = nil
+	}
+	return c, nil
+}
+
+// maxPeelDepth bounds how many tags peelToCommit follows, in case of a tag loop.
+const maxPeelDepth = 32
+
+// peelToCommit follows annotated tags from hash to the commit they point to. Returns false if hash doesn't
+// lead to a commit.
+func peelToCommit(repo repository, hash string) (string, bool, error) {
+	for range maxPeelDepth {
+		objs, err := repo.read([]string{hash})
+		if err != nil {
+			return "", false, err
+		}
+		obj := objs[0]
+		switch {
+		case obj.missing:
+			return "", false, nil
+		case obj.typ == "commit":
+			return hash, true, nil
+		case obj.typ != "tag":
+			return "", false, nil
+		}
+		header, _, _ := strings.Cut(string(obj.data), "\n")
+		target, ok := strings.CutPrefix(header, "object ")
+		if !ok {
+			return "", false, fmt.Errorf("tag %s has no object", hash)
+		}
+		hash = target
+	}
+	return "", false, fmt.Errorf("too many nested tags at %s", hash)
+}
+
+// refCandidates lists the full ref names a short name could mean, in the order git tries them.
+func refCandidates(name string) []string {
+	return []string{
+		name,
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+		"refs/remotes/" + name + "/HEAD",
+	}
+}
+
+// resolveRevision resolves rev to a commit hash. rev is a name that lookup resolves to an object hash,
+// optionally followed by ~N (Nth first-parent ancestor), ^N (Nth parent), and ^{commit} or ^{} suffixes.
+// Returns false if rev doesn't name a commit, or uses syntax that isn't supported.
+func resolveRevision(repo repository, rev string, lookup func(name string) (string, bool, error)) (string, bool, error) {
+	end := strings.IndexAny(rev, "~^")
+	if end < 0 {
+		end = len(rev)
+	}
+	name, suffixes := rev[:end], rev[end:]
+	switch name {
+	case "":
+		return "", false, nil
+	case "@":
+		name = "HEAD"
+	}
+	hash, ok, err := lookup(name)
+	if err != nil || !ok {
+		return "", false, err
+	}
+
+	for suffixes != "" {
+		if peel, ok := strings.CutPrefix(suffixes, "^{"); ok {
+			kind, rest, ok := strings.Cut(peel, "}")
+			if !ok || (kind != "" && kind != "commit") {
+				return "", false, nil
+			}
+			suffixes = rest
+			continue
+		}
+
+		op := suffixes[0]
+		digits := len(suffixes[1:]) - len(strings.TrimLeft(suffixes[1:], "0123456789"))
+		n := 1
+		if digits > 0 {
+			if n, err = strconv.Atoi(suffixes[1 : 1+digits]); err != nil {
+				return "", false, nil
+			}
+		}
+		suffixes = suffixes[1+digits:]
+
+		if hash, ok, err = peelToCommit(repo, hash); err != nil || !ok {
+			return "", false, err
+		}
+		if op == '^' && n == 0 {
+			continue
+		}
+		steps, parent := n, 0 // ~N takes the first parent N times, ^N the Nth parent once
+		if op == '^' {
+			steps, parent = 1, n-1
+		}
+		for range steps {
+			c, err := readCommit(repo, hash)
+			if err != nil {
+				return "", false, err
+			}
+			if parent >= len(c.parents) {
+				return "", false, nil
+			}
+			hash = c.parents[parent]
+		}
+	}
+	return peelToCommit(repo, hash)
+}
+
+// walkLog lists the commits reachable from rev (a revision, or an A..B range), for repositories that
+// resolve revisions themselves.
+func walkLog(repo repository, rev string) ([]logEntry, error) {
+	from, to, isRange := strings.Cut(rev, "..")
+	if !isRange {
+		from, to = "", rev
+	}
+	resolve := func(rev string) (string, error) {
+		if rev == "" {
+			rev = "HEAD"
+		}
+		hash, ok, err := repo.resolveCommit(rev)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("unknown revision: %s", rev)
+		}
+		return hash, nil
+	}
+
+	include, err := resolve(to)
+	if err != nil {
+		return nil, err
+	}
+	var exclude []string
+	if isRange {
+		hash, err := resolve(from)
+		if err != nil {
+			return nil, err
+		}
+		exclude = append(exclude, hash)
+	}
+	return walkCommits(repo, []string{include}, exclude)
+}
+
+// walkCommits lists the commits reachable from include but not from exclude, newest first by committer
+// date, the way git log orders them by default.
+func walkCommits(repo repository, include, exclude []string) ([]logEntry, error) {
+	excluded := make(map[string]bool)
+	stack := append([]string(nil), exclude...)
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if excluded[hash] {
+			continue
+		}
+		excluded[hash] = true
+		c, err := readCommit(repo, hash)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, c.parents...)
+	}
+
+	queue := &commitQueue{}
+	seen := make(map[string]bool)
+	push := func(hash string) error {
+		if seen[hash] || excluded[hash] {
+			return nil
+		}
+		seen[hash] = true
+		c, err := readCommit(repo, hash)
+		if err != nil {
+			return err
+		}
+		heap.Push(queue, queuedCommit{hash: hash, commit: c, seq: len(seen)})
+		return nil
+	}
+	for _, hash := range include {
+		if err := push(hash); err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []logEntry
+	for queue.Len() > 0 {
+		q := heap.Pop(queue).(queuedCommit)
+		entries = append(entries, logEntry{
+			hash:          q.hash,
+			authorTime:    q.commit.authorTime,
+			committerTime: q.commit.committerTime,
+			authorName:    q.commit.authorName,
+			authorEmail:   q.commit.authorEmail,
+			parents:       q.commit.parents,
+			subject:       q.commit.subject,
+		})
+		for _, parent := range q.commit.parents {
+			if err := push(parent); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+type queuedCommit struct {
+	hash   string
+	commit parsedCommit
+	seq    int // Order of insertion, to break ties like git does
+}
+
+// commitQueue is a max-heap of commits by committer date.
+type commitQueue []queuedCommit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	if ti, tj := q[i].commit.committerTime, q[j].commit.committerTime; !ti.Equal(tj) {
+		return ti.After(tj)
+	}
+	return q[i].seq < q[j].seq
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(queuedCommit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// rustTokenKind is the kind of a rustToken.
+type rustTokenKind uint8
+
+const (
+	rustEOF rustTokenKind = iota
+	rustIdent
+	rustLiteral // Strings, raw strings, chars, bytes, and numbers
+	rustLifetime
+	rustPunct
+)
+
+// rustToken is one token of Rust source, as far as finding #[cfg(test)] items needs. Comments and whitespace
+// are skipped, and punctuation is one byte at a time.
+type rustToken struct {
+	kind    rustTokenKind
+	text    []byte // Identifiers and punctuation
+	line    int    // 1-based line of the first byte
+	endLine int    // Line of the last byte, for literals that span lines
+}
+
+func (t rustToken) is(punct byte) bool {
+	return t.kind == rustPunct && t.text[0] == punct
+}
+
+// rustLexer splits Rust source into tokens, so braces in strings, chars, and comments aren't taken for code.
+// It's lenient: anything it doesn't recognize becomes punctuation, and unterminated literals and comments run
+// to the end of the source.
+type rustLexer struct {
+	src    []byte
+	pos    int
+	line   int
+	peeked *rustToken
+}
+
+func newRustLexer(src []byte) *rustLexer {
+	l := &rustLexer{src: src, line: 1}
+	// A shebang line isn't an inner attribute
+	if bytes.HasPrefix(src, []byte("#!")) && !bytes.HasPrefix(bytes.TrimLeft(src[2:], " \t"), []byte("[")) {
+		l.pos = bytes.IndexByte(src, '\n')
+		if l.pos < 0 {
+			l.pos = len(src)
+		}
+	}
+	return l
+}
+
+func (l *rustLexer) peek() rustToken {
+	if l.peeked == nil {
+		tok := l.lex()
+		l.peeked = &tok
+	}
+	return *l.peeked
+}
+
+func (l *rustLexer) next() rustToken {
+	if l.peeked != nil {
+		tok := *l.peeked
+		l.peeked = nil
+		return tok
+	}
+	return l.lex()
+}
+
+func (l *rustLexer) lex() rustToken {
+	l.skipSpaceAndComments()
+	if l.pos >= len(l.src) {
+		return rustToken{kind: rustEOF, line: l.line, endLine: l.line}
+	}
+
+	start, line := l.pos, l.line
+	tok := func(kind rustTokenKind) rustToken {
+		return rustToken{kind: kind, text: l.src[start:l.pos], line: line, endLine: l.line}
+	}
+	c := l.src[l.pos]
+	switch {
+	case c == '"':
+		l.pos++
+		l.skipString()
+		return tok(rustLiteral)
+	case c == '\'':
+		if l.skipChar() {
+			return tok(rustLiteral)
+		}
+		l.pos++
+		l.skipIdent()
+		return tok(rustLifetime)
+	case c >= '0' && c <= '9':
+		for l.pos < len(l.src) && (isRustIdentByte(l.src[l.pos]) ||
+			l.src[l.pos] == '.' && l.pos+1 < len(l.src) && l.src[l.pos+1] >= '0' && l.src[l.pos+1] <= '9') {
+			l.pos++
+		}
+		return tok(rustLiteral)
+	case isRustIdentStart(c):
+		l.skipIdent()
+		next := byte(0)
+		if l.pos < len(l.src) {
+			next = l.src[l.pos]
+		}
+		switch prefix := string(l.src[start:l.pos]); {
+		case (prefix == "r" || prefix == "br" || prefix == "cr") && l.skipRawString():
+			return tok(rustLiteral)
+		case (prefix == "b" || prefix == "c") && next == '"':
+			l.pos++
+			l.skipString()
+			return tok(rustLiteral)
+		case prefix == "b" && next == '\'' && l.skipChar():
+			return tok(rustLiteral)
+		case prefix == "r" && next == '#' && l.pos+1 < len(l.src) && isRustIdentStart(l.src[l.pos+1]):
+			l.pos++ // A raw identifier like r#type
+			l.skipIdent()
+		}
+		return tok(rustIdent)
+	}
+	_, size := utf8.DecodeRune(l.src[l.pos:])
+	l.pos += size
+	return tok(rustPunct)
+}
+
+func (l *rustLexer) skipSpaceAndComments() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case bytes.HasPrefix(l.src[l.pos:], []byte("//")):
+			if end := bytes.IndexByte(l.src[l.pos:], '\n'); end >= 0 {
+				l.pos += end
+			} else {
+				l.pos = len(l.src)
+			}
+		case bytes.HasPrefix(l.src[l.pos:], []byte("/*")):
+			l.pos += 2
+			for depth := 1; depth > 0 && l.pos < len(l.src); {
+				switch {
+				case bytes.HasPrefix(l.src[l.pos:], []byte("/*")):
+					depth++
+					l.pos += 2
+				case bytes.HasPrefix(l.src[l.pos:], []byte("*/")):
+					depth--
+					l.pos += 2
+				default:
+					l.advance()
+				}
+			}
+		default:
+			return
+		}
+	}
+}
+
+// advance moves past one byte, counting it if it's a newline.
+func (l *rustLexer) advance() {
+	if l.src[l.pos] == '\n' {
+		l.line++
+	}
+	l.pos++
+}
+
+func (l *rustLexer) skipIdent() {
+	for l.pos < len(l.src) && isRustIdentByte(l.src[l.pos]) {
+		l.pos++
+	}
+}
+
+// skipString moves past the rest of a string whose opening quote has been read.
+func (l *rustLexer) skipString() {
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos++
+			if l.pos < len(l.src) {
+				l.advance()
+			}
+		case '"':
+			l.pos++
+			return
+		default:
+			l.advance()
+		}
+	}
+}
+
+// skipRawString moves past a raw string like r#"..."#, if one starts at the current position, which is just
+// past its r, br, or cr prefix.
+func (l *rustLexer) skipRawString() bool {
+	hashes := 0
+	for l.pos+hashes < len(l.src) && l.src[l.pos+hashes] == '#' {
+		hashes++
+	}
+	if l.pos+hashes >= len(l.src) || l.src[l.pos+hashes] != '"' {
+		return false
+	}
+	l.pos += hashes + 1
+	closing := append([]byte{'"'}, bytes.Repeat([]byte{'#'}, hashes)...)
+	for l.pos < len(l.src) && !bytes.HasPrefix(l.src[l.pos:], closing) {
+		l.advance()
+	}
+	l.pos = min(l.pos+len(closing), len(l.src))
+	return true
+}
+
+// skipChar moves past a char literal like 'x' or '\u{7FFF}', if one starts at the current quote. Otherwise the
+// quote starts a lifetime or label, and nothing is skipped.
+func (l *rustLexer) skipChar() bool {
+	rest := l.src[l.pos+1:]
+	if len(rest) == 0 {
+		return false
+	}
+	if rest[0] == '\\' {
+		// Skip the escaped character, so '\'' ends at the second quote
+		end := bytes.IndexAny(rest[min(2, len(rest)):], "'\n")
+		if end < 0 || rest[2+end] != '\'' {
+			return false
+		}
+		l.pos += 1 + 2 + end + 1
+		return true
+	}
+	_, size := utf8.DecodeRune(rest)
+	if size >= len(rest) || rest[size] != '\'' || rest[0] == '\n' {
+		return false
+	}
+	l.pos += 1 + size + 1
+	return true
+}
+
+func isRustIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= utf8.RuneSelf
+}
+
+func isRustIdentByte(c byte) bool {
+	return isRustIdentStart(c) || c >= '0' && c <= '9'
+}
+
+// rustItemKeywords start items and statements whose commas don't end them, like the ones in
+// fn f<A, B>() or let m: HashMap<K, V>.
+var rustItemKeywords = map[string]bool{
+	"fn": true, "mod": true, "impl": true, "struct": true, "enum": true, "union": true, "trait": true,
+	"use": true, "const": true, "static": true, "type": true, "extern": true, "let": true, "macro_rules": true,
+}
+
+// countRustTestLines counts the lines of items under #[cfg(test)], from the attribute to the end of the item:
+// a closing brace for modules, functions, and impls, a semicolon for use and mod declarations, or a comma for
+// struct fields and match arms. #![cfg(test)] makes the whole enclosing module count, or the whole file at the
+// top level. Also counts cfg(all(test, ...)), but not cfg(any(test, ...)) or cfg(not(test)).
+func countRustTestLines(content []byte) int {
+	l := newRustLexer(content)
+	var opens []int // Lines of the braces we're inside
+	testLines, counted := 0, 0
+	lines := lineCount(content)
+	count := func(from, to int) {
+		from, to = max(from, counted+1), min(to, lines) // An unterminated literal can run past the last line
+		if to >= from {
+			testLines += to - from + 1
+			counted = to
+		}
+	}
+
+	for {
+		tok := l.next()
+		switch {
+		case tok.kind == rustEOF:
+			return testLines
+		case tok.is('{'):
+			opens = append(opens, tok.line)
+		case tok.is('}'):
+			if len(opens) > 0 {
+				opens = opens[:len(opens)-1]
+			}
+		case tok.is('#'):
+			inner := l.peek().is('!')
+			if inner {
+				l.next()
+			}
+			if !l.peek().is('[') {
+				continue
+			}
+			l.next()
+			if !isCfgTest(readRustAttribute(l)) {
+				continue
+			}
+			switch {
+			case !inner:
+				count(tok.line, skipRustItem(l, tok.line))
+			case len(opens) == 0:
+				return lines
+			default:
+				count(opens[len(opens)-1], skipRustBlock(l))
+				opens = opens[:len(opens)-1]
+			}
+		}
+	}
+}
+
+// readRustAttribute reads the rest of an attribute whose #[ has been read, up to its closing bracket, and
+// returns it without spaces. Literals are replaced with "".
+func readRustAttribute(l *rustLexer) string {
+	var attr strings.Builder
+	depth := 0
+	for {
+		tok := l.next()
+		switch {
+		case tok.kind == rustEOF:
+			return attr.String()
+		case tok.is('[') || tok.is('(') || tok.is('{'):
+			depth++
+		case tok.is(']') || tok.is(')') || tok.is('}'):
+			if depth == 0 {
+				return attr.String()
+			}
+			depth--
+		}
+		if tok.kind == rustLiteral {
+			attr.WriteString(`""`)
+		} else {
+			attr.Write(tok.text)
+		}
+	}
+}
+
+// isCfgTest reports whether an attribute, as returned by readRustAttribute, only compiles its item for tests.
+func isCfgTest(attr string) bool {
+	pred, ok := strings.CutPrefix(attr, "cfg(")
+	if !ok {
+		return false
+	}
+	pred, ok = strings.CutSuffix(pred, ")")
+	return ok && cfgRequiresTest(pred)
+}
+
+func cfgRequiresTest(pred string) bool {
+	if pred == "test" {
+		return true
+	}
+	args, ok := strings.CutPrefix(pred, "all(")
+	if !ok {
+		return false
+	}
+	args, ok = strings.CutSuffix(args, ")")
+	if !ok {
+		return false
+	}
+	depth, start := 0, 0
+	for i := 0; i <= len(args); i++ {
+		switch {
+		case i == len(args) || args[i] == ',' && depth == 0:
+			if cfgRequiresTest(args[start:i]) {
+				return true
+			}
+			start = i + 1
+		case args[i] == '(':
+			depth++
+		case args[i] == ')':
+			depth--
+		}
+	}
+	return false
+}
+
+// skipRustItem reads the item an outer attribute on line start applies to, and returns the line it ends on. A
+// closing bracket of the enclosing block is left unread.
+func skipRustItem(l *rustLexer, start int) int {
+	last, depth := start, 0
+	isItem := false
+	for {
+		tok := l.peek()
+		if tok.kind == rustEOF {
+			return last
+		}
+		closing := tok.is('}') || tok.is(')') || tok.is(']')
+		if closing && depth == 0 {
+			return last // The item was the last thing in its block, like a field without a trailing comma
+		}
+		l.next()
+		last = tok.endLine
+		switch {
+		case tok.is('{') || tok.is('(') || tok.is('['):
+			depth++
+		case closing:
+			if depth--; depth == 0 && tok.is('}') {
+				return last
+			}
+		case depth > 0:
+			// Separators inside brackets belong to the item
+		case tok.is(';') || tok.is(',') && !isItem:
+			return last
+		case tok.kind == rustIdent && rustItemKeywords[string(tok.text)]:
+			isItem = true
+		}
+	}
+}
+
+// skipRustBlock reads up to and including the closing brace of the block we're in, and returns its line.
+func skipRustBlock(l *rustLexer) int {
+	depth := 0
+	for {
+		tok := l.next()
+		switch {
+		case tok.kind == rustEOF:
+			return tok.line
+		case tok.is('{'):
+			depth++
+		case tok.is('}'):
+			if depth == 0 {
+				return tok.line
+			}
+			depth--
+		}
+	}
+}
+
+// lineCount counts lines the way blobScanner does: a last line without a trailing newline still counts.
+func lineCount(content []byte) int {
+	n := bytes.Count(content, []byte("\n"))
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The files in testdata/rust are modeled on real crates, with braces, quotes, and #[cfg(test)] in the places
+// that used to throw off brace counting.
+func TestCountRustTestLines(t *testing.T) {
+	tests := []struct {
+		file string
+		want int
+	}{
+		{"braces_in_literals.rs", 13},
+		{"attribute_targets.rs", 25},
+		{"lifetimes.rs", 16},
+		{"comments.rs", 7},
+		{"inner_attribute.rs", 8},
+		{"inner_module_attribute.rs", 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			content, err := os.ReadFile(filepath.Join("testdata", "rust", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := countRustTestLines(content); got != tt.want {
+				t.Errorf("countRustTestLines() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountRustTestLines_Unterminated(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    int
+	}{
+		{"module", "fn a() {}\n#[cfg(test)]\nmod tests {\n    fn t() {}\n", 3},
+		{"string", "#[cfg(test)]\nconst S: &str = \"{\n", 2},
+		{"raw string", "#[cfg(test)]\nconst S: &str = r#\"}\"\n", 2},
+		{"block comment", "#[cfg(test)]\nfn t() {} /* {\n", 2},
+		{"attribute", "#[cfg(test", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countRustTestLines([]byte(tt.content)); got != tt.want {
+				t.Errorf("countRustTestLines() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// globPattern is a path glob split into components. *, ?, and [...] match within a component, and **
+// matches any number of components. A pattern matches a path if it matches the path or one of its parent
+// dirs, so "services/api" covers everything in that dir.
+type globPattern []string
+
+// parseGlob parses a slash-separated glob. Like in .gitignore, a pattern without a slash (other than a
+// trailing one) matches at any depth, and a leading slash is ignored.
+func parseGlob(pattern string) (globPattern, error) {
+	trimmed := strings.Trim(strings.TrimSpace(pattern), "/")
+	if trimmed == "" {
+		return nil, fmt.Errorf("empty glob %q", pattern)
+	}
+	if !strings.Contains(strings.TrimRight(strings.TrimSpace(pattern), "/"), "/") {
+		trimmed = "**/" + trimmed
+	}
+	var glob globPattern
+	for part := range strings.SplitSeq(trimmed, "/") {
+		if part == "" {
+			continue
+		}
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+		glob = append(glob, part)
+	}
+	return glob, nil
+}
+
+// matches reports whether the glob matches path or one of its parent dirs.
+func (g globPattern) matches(path string) bool {
+	return matchGlob(g, strings.Split(path, "/"))
+}
+
+// mayMatchUnder reports whether the glob could match dir or anything in it.
+func (g globPattern) mayMatchUnder(dir string) bool {
+	parts := strings.Split(dir, "/")
+	for i, p := range g {
+		if p == "**" || i == len(parts) {
+			return true
+		}
+		if ok, _ := path.Match(p, parts[i]); !ok {
+			return false
+		}
+	}
+	return true // dir itself matches
+}
+
+func matchGlob(glob globPattern, parts []string) bool {
+	if len(glob) == 0 {
+		return true // Everything in a matched dir matches
+	}
+	if glob[0] == "**" {
+		return matchGlob(glob[1:], parts) || (len(parts) > 0 && matchGlob(glob, parts[1:]))
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	ok, _ := path.Match(glob[0], parts[0])
+	return ok && matchGlob(glob[1:], parts[1:])
+}
+
+// pathScope limits counting to part of the repo, with --include and --exclude globs on top of the skip rules.
+// A nil scope includes everything but what the built-in skip rules leave out.
+type pathScope struct {
+	include []globPattern // If any, only paths matching one of them count
+	exclude []globPattern
+	desc    string // The patterns as given, for checkpoint keys
+
+	// The built-in skip rules with a repo's config applied, or nil for the built-in ones
+	skipPatterns []string
+	skipDirs     map[string]bool
+}
+
+// newPathScope parses the include and exclude globs, and applies config's skip rules. Returns nil if there are
+// no globs and no skip rules in config.
+func newPathScope(include, exclude []string, config *repoConfig) (*pathScope, error) {
+	hasSkipRules := config != nil && len(config.skip)+len(config.skipDirs)+len(config.unskip) > 0
+	if len(include) == 0 && len(exclude) == 0 && !hasSkipRules {
+		return nil, nil
+	}
+	s := &pathScope{desc: fmt.Sprintf("include=%q exclude=%q", include, exclude)}
+	if hasSkipRules {
+		s.skipPatterns = slices.DeleteFunc(slices.Concat(skipPatterns, config.skip), func(p string) bool {
+			return slices.Contains(config.unskip, p)
+		})
+		s.skipDirs = maps.Clone(skipDirs)
+		for _, dir := range config.skipDirs {
+			s.skipDirs[dir] = true
+		}
+		for _, dir := range config.unskip {
+			delete(s.skipDirs, dir)
+		}
+	}
+	for _, pattern := range include {
+		glob, err := parseGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		s.include = append(s.include, glob)
+	}
+	for _, pattern := range exclude {
+		glob, err := parseGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		s.exclude = append(s.exclude, glob)
+	}
+	return s, nil
+}
+
+// includes reports whether the file at path counts.
+func (s *pathScope) includes(path string) bool {
+	if s == nil {
+		return true
+	}
+	for _, glob := range s.exclude {
+		if glob.matches(path) {
+			return false
+		}
+	}
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, glob := range s.include {
+		if glob.matches(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// walksInto reports whether any file in dir could count, so tree walks can skip the rest without reading them.
+func (s *pathScope) walksInto(dir string) bool {
+	if s == nil {
+		return true
+	}
+	for _, glob := range s.exclude {
+		if glob.matches(dir) {
+			return false
+		}
+	}
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, glob := range s.include {
+		if glob.mayMatchUnder(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipsFile reports whether the file at path is left out by the skip rules.
+func (s *pathScope) skipsFile(path string) bool {
+	if s == nil || s.skipPatterns == nil {
+		return shouldSkip(path)
+	}
+	return matchesSkipPattern(path, s.skipPatterns)
+}
+
+// skipsDir reports whether dirs with this name are left out by the skip rules.
+func (s *pathScope) skipsDir(name string) bool {
+	if s == nil || s.skipDirs == nil {
+		return shouldSkipDir(name)
+	}
+	return s.skipDirs[name]
+}
+
+func (s *pathScope) String() string {
+	if s == nil {
+		return "all"
+	}
+	return s.desc
+}
+
+// otherComponent is the group of files that match none of the named components.
+const otherComponent = "other"
+
+// component is a named part of a repo, like a package in a monorepo.
+type component struct {
+	name string
+	glob globPattern
+}
+
+// breakdown groups files by their directory, down to depth levels, or by named components. A nil breakdown
+// doesn't group.
+type breakdown struct {
+	depth      int
+	components []component // If any, files are grouped by the first one they match, or otherComponent
+}
+
+// newBreakdown returns a breakdown by depth, or by components given as "NAME=GLOB". A name can be given
+// more than once to cover several globs. Returns nil if depth is 0 and there are no components.
+func newBreakdown(depth int, components []string) (*breakdown, error) {
+	if len(components) == 0 {
+		if depth <= 0 {
+			return nil, nil
+		}
+		return &breakdown{depth: depth}, nil
+	}
+	b := &breakdown{}
+	for _, spec := range components {
+		name, pattern, ok := strings.Cut(spec, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid component %q (expected NAME=GLOB)", spec)
+		}
+		glob, err := parseGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		b.components = append(b.components, component{name: name, glob: glob})
+	}
+	return b, nil
+}
+
+// group returns the group of the file at path: a component name, or its directory down to the breakdown's
+// depth ("." for files at the root).
+func (b *breakdown) group(path string) string {
+	if len(b.components) > 0 {
+		for _, c := range b.components {
+			if c.glob.matches(path) {
+				return c.name
+			}
+		}
+		return otherComponent
+	}
+	dirs := strings.Split(path, "/")
+	dirs = dirs[:len(dirs)-1]
+	if len(dirs) == 0 {
+		return "."
+	}
+	return strings.Join(dirs[:min(len(dirs), b.depth)], "/")
+}
+
+func (b *breakdown) String() string {
+	if b == nil {
+		return "none"
+	}
+	if len(b.components) == 0 {
+		return "depth=" + strconv.Itoa(b.depth)
+	}
+	var parts []string
+	for _, c := range b.components {
+		parts = append(parts, c.name+"="+strings.Join(c.glob, "/"))
+	}
+	return "components=" + strings.Join(parts, ",")
+}
@@ -0,0 +1,98 @@
+package main
+
+import "testing"
+
+func TestPathScope(t *testing.T) {
+	scope, err := newPathScope([]string{"services/api", "libs/**/*.go", "*.md"}, []string{"services/api/gen/", "*_test.go"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []struct {
+		path string
+		want bool
+	}{
+		{"services/api/main.go", true},
+		{"services/api/handlers/user.go", true},
+		{"services/api/gen/types.go", false},
+		{"services/api/main_test.go", false},
+		{"services/web/main.go", false},
+		{"libs/log.go", true},
+		{"libs/log/log.go", true},
+		{"libs/log/log.rs", false},
+		{"README.md", true},
+		{"docs/guide/intro.md", true},
+		{"main.go", false},
+	}
+	for _, tt := range files {
+		if got := scope.includes(tt.path); got != tt.want {
+			t.Errorf("includes(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	dirs := []struct {
+		path string
+		want bool
+	}{
+		{"services", true},
+		{"services/api", true},
+		{"services/api/gen", false},
+		{"services/web", true}, // Could hold .md files
+		{"libs/a/b", true},
+	}
+	for _, tt := range dirs {
+		if got := scope.walksInto(tt.path); got != tt.want {
+			t.Errorf("walksInto(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	anchored, err := newPathScope([]string{"/services/api"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anchored.walksInto("web") || anchored.includes("web/services/api/main.go") || !anchored.includes("services/api/main.go") {
+		t.Error("a pattern with a slash should only match from the root")
+	}
+
+	for _, bad := range []string{"", "/", "src/[a"} {
+		if _, err := newPathScope([]string{bad}, nil, nil); err == nil {
+			t.Errorf("newPathScope(%q) should fail", bad)
+		}
+	}
+}
+
+func TestBreakdownGroup(t *testing.T) {
+	tests := []struct {
+		name       string
+		depth      int
+		components []string
+		path       string
+		want       string
+	}{
+		{"top-level dir", 1, nil, "services/api/main.go", "services"},
+		{"two levels", 2, nil, "services/api/handlers/user.go", "services/api"},
+		{"shallower than depth", 2, nil, "services/main.go", "services"},
+		{"root file", 1, nil, "README.md", "."},
+		{"first matching component", 0, []string{"api=services/api", "go=*.go"}, "services/api/main.go", "api"},
+		{"later component", 0, []string{"api=services/api", "go=*.go"}, "tools/gen.go", "go"},
+		{"no component", 0, []string{"api=services/api"}, "web/app.ts", otherComponent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := newBreakdown(tt.depth, tt.components)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := b.group(tt.path); got != tt.want {
+				t.Errorf("group(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"api", "=services/api", "api="} {
+		if _, err := newBreakdown(1, []string{bad}); err == nil {
+			t.Errorf("newBreakdown(%q) should fail", bad)
+		}
+	}
+}
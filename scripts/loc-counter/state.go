@@ -0,0 +1,238 @@
+package main
+
+// fileState is what we know about one counted file at a commit.
+type fileState struct {
+	blob      string
+	lang      *languageDefinition
+	lines     int
+	testLines int
+	comments  int
+	blanks    int
+	group     string // Directory or component, with a breakdown
+}
+
+// treeState tracks every counted file at a commit, so the next commit only needs to re-count the files
+// that changed. Skipped, binary, and missing files are not tracked.
+type treeState struct {
+	files      map[string]fileState // Keyed by path
+	extensions map[string]int       // Number of tracked files per extension, for .h resolution
+	repo       repository
+	cache      *blobCache   // Shared across states
+	scope      *pathScope   // Which files count
+	languages  *languageMap // Which language each file is in
+	breakdown  *breakdown   // How to group files, nil for not at all
+	log        *logger      // Where warnings go, nil for nowhere
+
+	attributeBlobs map[string]string // Blob of each .gitattributes file, keyed by its directory
+	attributes     gitAttributes     // Parsed from attributeBlobs, nil until loaded
+}
+
+func newTreeState(repo repository, cache *blobCache) *treeState {
+	return &treeState{
+		files:          make(map[string]fileState),
+		extensions:     make(map[string]int),
+		attributeBlobs: make(map[string]string),
+		repo:           repo,
+		cache:          cache,
+	}
+}
+
+// clear forgets every file, so the state can be filled from a full tree again.
+func (t *treeState) clear() {
+	clear(t.files)
+	clear(t.extensions)
+	clear(t.attributeBlobs)
+	t.attributes = nil
+}
+
+// loadAttributes parses the tracked .gitattributes files, if they haven't been yet.
+func (t *treeState) loadAttributes() error {
+	if t.attributes != nil || len(t.attributeBlobs) == 0 {
+		return nil
+	}
+	attributes, err := loadGitattributes(t.repo, t.attributeBlobs)
+	if err != nil {
+		return err
+	}
+	t.attributes = attributes
+	return nil
+}
+
+// rules returns the rules for which files count in this tree, with extMap to pick their languages.
+func (t *treeState) rules(extMap map[string]*languageDefinition) fileRules {
+	return fileRules{scope: t.scope, extMap: extMap, attributes: t.attributes}
+}
+
+func (t *treeState) set(path string, state fileState) {
+	if _, ok := t.files[path]; !ok {
+		t.extensions[fileExtension(path)]++
+	}
+	if t.breakdown != nil && state.group == "" {
+		state.group = t.breakdown.group(path)
+	}
+	t.files[path] = state
+}
+
+func (t *treeState) remove(path string) {
+	if _, ok := t.files[path]; !ok {
+		return
+	}
+	delete(t.files, path)
+	ext := fileExtension(path)
+	t.extensions[ext]--
+	if t.extensions[ext] == 0 {
+		delete(t.extensions, ext)
+	}
+}
+
+// extensionMap returns the extension map for this tree, with .h files as C++ if there are C++ sources, and as C
+// otherwise.
+func (t *treeState) extensionMap() map[string]*languageDefinition {
+	present := make(map[string]bool, len(t.extensions))
+	for ext := range t.extensions {
+		present[ext] = true
+	}
+	return t.languages.forTree(present)
+}
+
+// stats aggregates the tracked files into per-language counts.
+func (t *treeState) stats(messages []string) *fileStats {
+	// .h files are resolved here rather than when counted, since their language depends on the rest of the tree
+	headerLang := t.extensionMap()[".h"]
+
+	stats := newFileStats(messages)
+	if t.breakdown != nil {
+		stats.groups = make(map[string]*fileStats)
+	}
+	for path, f := range t.files {
+		lang := f.lang
+		if fileExtension(path) == ".h" {
+			lang = headerLang
+		}
+		stats.add(lang, f)
+		if t.breakdown != nil {
+			group, ok := stats.groups[f.group]
+			if !ok {
+				group = newFileStats(nil)
+				stats.groups[f.group] = group
+			}
+			group.add(lang, f)
+		}
+	}
+	return stats
+}
+
+// countFiles counts the given files and stores them in the state. Blobs are only read if the cache
+// doesn't know them yet. Skipped files are ignored, and binary or missing files are dropped from the state.
+func (t *treeState) countFiles(files []fileEntry) error {
+	type pendingFile struct {
+		fileEntry
+		lang *languageDefinition
+	}
+	var toRead []pendingFile
+	var requests []blobRequest
+
+	rules := t.rules(t.languages.extensionMap())
+	for _, f := range files {
+		lang, skip := rules.classify(f.path)
+		if skip != "" {
+			logSkipped(t.log, f.path, skip)
+			continue
+		}
+		if info, ok := t.cache.get(f.blob, blobLanguageID(lang)); ok {
+			t.setFromBlobInfo(f, lang, info)
+			continue
+		}
+		toRead = append(toRead, pendingFile{f, lang})
+		requests = append(requests, blobRequest{blob: f.blob, lang: lang})
+	}
+
+	results, err := readBlobs(t.repo, requests)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range toRead {
+		info, ok := results[blobKey{f.blob, blobLanguageID(f.lang)}]
+		if !ok {
+			t.remove(f.path) // missing
+			continue
+		}
+		t.cache.put(f.blob, info)
+		t.setFromBlobInfo(f.fileEntry, f.lang, info)
+	}
+	return nil
+}
+
+func (t *treeState) setFromBlobInfo(f fileEntry, lang *languageDefinition, info blobInfo) {
+	if info.binary {
+		t.remove(f.path)
+		return
+	}
+	if info.tooLarge > 0 {
+		t.log.warnOncef(f.path+": too large", "%s is larger than --max-blob-buffer, not looking for inline tests in it",
+			f.path)
+	}
+	if info.generated.skips(lang) && !t.attributes.linguist(f.path).markedNotGenerated() {
+		logSkipped(t.log, f.path, info.generated.String())
+		t.remove(f.path)
+		return
+	}
+	t.set(f.path, fileState{
+		blob:      f.blob,
+		lang:      lang,
+		lines:     info.lines,
+		testLines: countTestLines(f.path, info.lines, info.inlineTestLines, lang, t.languages.extraTestDirs()),
+		comments:  info.comments,
+		blanks:    info.blanks,
+	})
+}
+
+// logSkipped prints why a file isn't counted, in verbose mode.
+func logSkipped(log *logger, path, reason string) {
+	log.detailf(path+": "+reason, "Skipping %s: %s", path, reason)
+}
+
+// blobLanguageID returns the language's id if a blob's counts depend on it, because it has comment syntax or
+// an inline test counter, and empty otherwise.
+func blobLanguageID(lang *languageDefinition) string {
+	if lang.comments == nil && lang.countInlineTestLines == nil {
+		return ""
+	}
+	return lang.id
+}
+
+// fileRules decides which files of a tree count, and which language they're in.
+type fileRules struct {
+	scope      *pathScope
+	extMap     map[string]*languageDefinition
+	attributes gitAttributes
+}
+
+// classify returns the language of the file at path, or why it doesn't count: it's outside scope, skipped by
+// the skip rules, or marked as generated or vendored in .gitattributes. Files marked as not generated or not
+// vendored aren't skipped, and files marked as documentation count as docs. Whether the content looks
+// generated is up to the caller, since it takes reading the blob.
+func (r fileRules) classify(path string) (lang *languageDefinition, skip string) {
+	if !r.scope.includes(path) {
+		return nil, "outside --include/--exclude" // A .gitattributes file, listed for the files in scope below it
+	}
+	attrs := r.attributes.linguist(path)
+	switch {
+	case attrs.generated == attrTrue:
+		return nil, "linguist-generated in .gitattributes"
+	case attrs.vendored == attrTrue:
+		return nil, "linguist-vendored in .gitattributes"
+	case r.scope.skipsFile(path) && !attrs.unexcluded():
+		return nil, "matches a skip pattern"
+	case attrs.documentation == attrTrue:
+		return languageByID("docs"), ""
+	}
+	return languageForFile(path, r.extMap), ""
+}
+
+// skipsGenerated reports whether the file at path should be skipped if its content looks generated, which is
+// unless it's marked as not generated in .gitattributes.
+func (r fileRules) skipsGenerated(path string) bool {
+	return !r.attributes.linguist(path).markedNotGenerated()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCountLinesForCommit_IncrementalMatchesFullCount(t *testing.T) {
+	repo := newTestRepo(t)
+	var hashes []string
+	commit := func(date, message string) {
+		repo.commit(date, message)
+		hashes = append(hashes, strings.TrimSpace(repo.git("rev-parse", "HEAD")))
+	}
+
+	repo.write("src/lib.c", "int a;\nint b;\n")
+	repo.write("src/lib.h", "int a;\n")
+	repo.write("README.md", "# Hi\n")
+	repo.write("vendor/dep.go", "package dep\n")
+	commit("2024-01-01T10:00:00Z", "Initial")
+
+	repo.write("src/lib.c", "int a;\nint b;\nint c;\n")
+	repo.write("src/more.cpp", "int d;\n") // Flips .h from C to C++
+	repo.write("image.bin", "\x00\x01\x02")
+	commit("2024-01-02T10:00:00Z", "Add C++")
+
+	repo.git("mv", "README.md", "docs.md")
+	repo.write("image.bin", "now text\n")
+	commit("2024-01-03T10:00:00Z", "Rename and unbinary")
+
+	repo.git("rm", "-q", "src/more.cpp")
+	repo.write("tests/lib_test.c", "int t;\n")
+	commit("2024-01-04T10:00:00Z", "Back to C")
+
+	r := repo.open(backendExec)
+
+	incremental := newTreeState(r, newBlobCache(""))
+	prev := ""
+	for _, hash := range hashes {
+		got, err := countLinesForCommit(incremental, prev, hash, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := countLinesForCommit(newTreeState(r, newBlobCache("")), "", hash, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.total != want.total || !reflect.DeepEqual(got.languages, want.languages) {
+			t.Errorf("commit %s: incremental = %d %v, full = %d %v", hash[:7], got.total, dump(got), want.total, dump(want))
+		}
+		prev = hash
+	}
+
+	final, _ := countLinesForCommit(newTreeState(r, newBlobCache("")), "", hashes[len(hashes)-1], nil)
+	if final.languages["c"] == nil || final.languages["c"].total != 5 || final.languages["c"].test != 1 {
+		t.Errorf("final C count = %v, want total 5 with 1 test line", dump(final))
+	}
+	if final.languages["cpp"] != nil {
+		t.Errorf("final stats should have no C++ after removing the .cpp file, got %v", dump(final))
+	}
+}
+
+func dump(s *fileStats) map[string]languageCount {
+	m := make(map[string]languageCount, len(s.languages))
+	for id, c := range s.languages {
+		m[id] = *c
+	}
+	return m
+}
+
+func TestCountLinesForCommit_GitAttributes(t *testing.T) {
+	repo := newTestRepo(t)
+	var hashes []string
+	commit := func(date, message string) {
+		repo.commit(date, message)
+		hashes = append(hashes, strings.TrimSpace(repo.git("rev-parse", "HEAD")))
+	}
+
+	repo.write("app/main.go", "package main\n")
+	repo.write("app/api.gen.go", "package main\n\nvar A = 1\n")
+	repo.write("app/guide/setup.go", "package guide\n")
+	repo.write("app/client.pb.go", "package main\n")
+	commit("2024-01-01T10:00:00Z", "Initial")
+
+	// The root file applies to app/ even though it's outside scope
+	repo.write(".gitattributes", "*.gen.go linguist-generated\nguide/** linguist-documentation\n")
+	repo.write("app/.gitattributes", "guide/** linguist-documentation\n*.pb.go linguist-generated=false\n")
+	commit("2024-01-02T10:00:00Z", "Mark generated code")
+
+	repo.write("app/.gitattributes", "*.gen.go -linguist-generated\n")
+	commit("2024-01-03T10:00:00Z", "Unmark generated code")
+
+	r := repo.open(backendExec)
+	scope, err := newPathScope([]string{"app"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(state *treeState, prev, hash string) *fileStats {
+		t.Helper()
+		state.scope = scope
+		stats, err := countLinesForCommit(state, prev, hash, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	want := []map[string]int{
+		{"go": 5},                        // client.pb.go is skipped by default
+		{"go": 2, "docs": 1, "other": 2}, // api.gen.go is generated, client.pb.go isn't
+		{"go": 5, "other": 1},            // app/.gitattributes overrides the root one
+	}
+	incremental := newTreeState(r, newBlobCache(""))
+	prev := ""
+	for i, hash := range hashes {
+		got := count(incremental, prev, hash)
+		full := count(newTreeState(r, newBlobCache("")), "", hash)
+		if !reflect.DeepEqual(got.languages, full.languages) {
+			t.Errorf("commit %d: incremental = %v, full = %v", i, dump(got), dump(full))
+		}
+		totals := make(map[string]int)
+		for id, n := range got.languages {
+			totals[id] = n.total
+		}
+		if !reflect.DeepEqual(totals, want[i]) {
+			t.Errorf("commit %d: totals = %v, want %v", i, totals, want[i])
+		}
+		prev = hash
+	}
+}
+
+func TestCountLinesForCommit_GeneratedContent(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("db/query.go", "package db\n")
+	repo.commit("2024-01-01T10:00:00Z", "Initial")
+	base := strings.TrimSpace(repo.git("rev-parse", "HEAD"))
+
+	repo.write("db/query.go", "package db\n\nfunc Q() {}\n")
+	repo.write("db/models.go", "// Code generated by sqlc. DO NOT EDIT.\n\npackage db\n\ntype User struct{}\n")
+	repo.write("mocks/api.go", "// Code generated by MockGen. DO NOT EDIT.\n\npackage mocks\n")
+	repo.write(".gitattributes", "mocks/** linguist-generated=false\n")
+	repo.commit("2024-01-02T10:00:00Z", "Add generated code")
+	hash := strings.TrimSpace(repo.git("rev-parse", "HEAD"))
+
+	r := repo.open(backendExec)
+	state := newTreeState(r, newBlobCache(""))
+	stats, err := countLinesForCommit(state, "", hash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// db/models.go is left out, and mocks/api.go is kept since .gitattributes says it isn't generated
+	if got := stats.languages["go"].total; got != 6 {
+		t.Errorf("go lines = %d, want 6 (%v)", got, dump(stats))
+	}
+
+	added, _, err := countChurn(r, state.rules(state.extensionMap()), base, hash, state.cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added["go"] != 5 {
+		t.Errorf("go lines added = %d, want 5 without db/models.go", added["go"])
+	}
+}
+
+func TestCountLinesForCommit_SameContentInTwoLanguages(t *testing.T) {
+	repo := newTestRepo(t)
+	content := "# Build settings\nx = 1\n"
+	repo.write("settings.py", content)
+	repo.write("settings.rb", content)
+	repo.write("settings.go", content)
+	repo.commit("2024-01-01T10:00:00Z", "Add settings")
+	hash := strings.TrimSpace(repo.git("rev-parse", "HEAD"))
+
+	state := newTreeState(repo.open(backendExec), newBlobCache(""))
+	stats, err := countLinesForCommit(state, "", hash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One blob, but # only starts a comment in Python and Ruby
+	for lang, want := range map[string]int{"python": 1, "ruby": 1, "go": 0} {
+		if got := stats.languages[lang].comment; got != want {
+			t.Errorf("%s comment lines = %d, want %d", lang, got, want)
+		}
+	}
+}
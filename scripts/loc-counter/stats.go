@@ -1,43 +1,114 @@
 package main
 
 import (
+	"bytes"
 	"path/filepath"
-	"strings"
+	"slices"
+	"sort"
 )
 
+// languageCount holds the line counts for one language. prod and test are only tracked for languages
+// with a prod/test split. code, comment, and blank also add up to total.
+type languageCount struct {
+	total   int
+	prod    int
+	test    int
+	code    int
+	comment int
+	blank   int
+}
+
 type fileStats struct {
-	total    int
-	rust     int
-	rustProd int
-	rustTest int
-	ts       int
-	tsProd   int
-	tsTest   int
-	svelte   int
-	astro    int
-	goTotal  int
-	css      int
-	docs     int
-	other    int
-	comments []string
+	total     int
+	languages map[string]*languageCount // Keyed by language id
+	comments  []string
+	authors   []string       // Unique authors of the day's commits, as "Name <email>"
+	added     map[string]int // Lines added per language id since the previous day
+	removed   map[string]int // Lines removed per language id since the previous day
+
+	contributorAdded   map[string]int // Lines added per author by the day's commits
+	contributorRemoved map[string]int // Lines removed per author by the day's commits
+	contributors       map[string]int // Net lines per author since the start of the history, set by carryForward
+
+	groups map[string]*fileStats // Line counts per directory or component, with a breakdown
+}
+
+func newFileStats(comments []string) *fileStats {
+	return &fileStats{languages: make(map[string]*languageCount), comments: comments}
 }
 
+// copyWithoutComments copies the line counts, including per group, but not the comments, authors, or churn.
 func (s *fileStats) copyWithoutComments() *fileStats {
-	return &fileStats{
-		total:    s.total,
-		rust:     s.rust,
-		rustProd: s.rustProd,
-		rustTest: s.rustTest,
-		ts:       s.ts,
-		tsProd:   s.tsProd,
-		tsTest:   s.tsTest,
-		svelte:   s.svelte,
-		astro:    s.astro,
-		goTotal:  s.goTotal,
-		css:      s.css,
-		docs:     s.docs,
-		other:    s.other,
+	c := newFileStats(nil)
+	c.total = s.total
+	for id, count := range s.languages {
+		copied := *count
+		c.languages[id] = &copied
+	}
+	if s.groups != nil {
+		c.groups = make(map[string]*fileStats, len(s.groups))
+		for name, group := range s.groups {
+			c.groups[name] = group.copyWithoutComments()
+		}
+	}
+	return c
+}
+
+// add counts a file's lines towards its language. Its test lines are ignored for languages without a
+// prod/test split.
+func (s *fileStats) add(lang *languageDefinition, f fileState) {
+	lines, testLines := f.lines, f.testLines
+	if lines == 0 {
+		return
+	}
+	count, ok := s.languages[lang.id]
+	if !ok {
+		count = &languageCount{}
+		s.languages[lang.id] = count
+	}
+	count.total += lines
+	if !lang.noTestSplit {
+		count.prod += lines - testLines
+		count.test += testLines
+	}
+	count.code += lines - f.comments - f.blanks
+	count.comment += f.comments
+	count.blank += f.blanks
+	s.total += lines
+}
+
+// detectLanguages returns the ids of all languages seen on any day, sorted by line count on the last day
+// (descending), then by id.
+func detectLanguages(days []*fileStats) []string {
+	seen := make(map[string]bool)
+	for _, day := range days {
+		for id := range day.languages {
+			seen[id] = true
+		}
 	}
+
+	finalLines := func(id string) int {
+		if len(days) == 0 {
+			return 0
+		}
+		if count, ok := days[len(days)-1].languages[id]; ok {
+			return count.total
+		}
+		return 0
+	}
+
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		li, lj := finalLines(ids[i]), finalLines(ids[j])
+		if li != lj {
+			return li > lj
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
 }
 
 // skipPatterns lists filenames and globs to exclude from counting.
@@ -109,8 +180,13 @@ func shouldSkipDir(name string) bool {
 }
 
 func shouldSkip(file string) bool {
+	return matchesSkipPattern(file, skipPatterns)
+}
+
+// matchesSkipPattern reports whether the file's name matches one of the patterns.
+func matchesSkipPattern(file string, patterns []string) bool {
 	base := filepath.Base(file)
-	for _, pattern := range skipPatterns {
+	for _, pattern := range patterns {
 		if matched, _ := filepath.Match(pattern, base); matched {
 			return true
 		}
@@ -118,105 +194,55 @@ func shouldSkip(file string) bool {
 	return false
 }
 
-type category int
-
-const (
-	catRustProd category = iota
-	catRustTest
-	catTSProd
-	catTSTest
-	catSvelte
-	catAstro
-	catGo
-	catCSS
-	catDocs
-	catOther
-)
-
-// simpleExtToCategory maps extensions that don't need test/prod distinction.
-var simpleExtToCategory = map[string]category{
-	".svelte": catSvelte,
-	".astro":  catAstro,
-	".go":     catGo,
-	".css":    catCSS,
-	".scss":   catCSS,
-	".md":     catDocs,
-}
-
-// tsExtensions is the set of TypeScript/JavaScript file extensions.
-var tsExtensions = map[string]bool{
-	".ts": true, ".tsx": true, ".js": true, ".jsx": true, ".mjs": true, ".cjs": true,
-}
-
-func categorizeFile(file string) category {
-	ext := strings.ToLower(filepath.Ext(file))
-	base := filepath.Base(file)
-
-	if cat, ok := simpleExtToCategory[ext]; ok {
-		return cat
-	}
-	if base == "LICENSE" {
-		return catDocs
-	}
-	if ext == ".rs" {
-		return categorizeRust(file)
+// languageForFile picks the language for a path using the given extension map.
+// Unrecognized files fall back to otherLanguage.
+func languageForFile(file string, extMap map[string]*languageDefinition) *languageDefinition {
+	if filepath.Base(file) == "LICENSE" {
+		return languageByID("docs")
 	}
-	if tsExtensions[ext] {
-		return categorizeTypeScript(file, base)
+	if lang, ok := extMap[fileExtension(file)]; ok {
+		return lang
 	}
-	return catOther
+	return otherLanguage
 }
 
-func categorizeRust(file string) category {
-	if isTestPath(file) {
-		return catRustTest
+// countTestLines returns how many of a file's lines are test code, checking test directories (the language's,
+// and extraTestDirs) first, then test filename patterns, then falling back to what the language's inline test
+// detector found.
+func countTestLines(file string, lines, inlineTestLines int, lang *languageDefinition, extraTestDirs []string) int {
+	if lang.noTestSplit {
+		return 0
 	}
-	return catRustProd
-}
-
-func categorizeTypeScript(file, base string) category {
-	if isTestFilename(base) || isTestPath(file) {
-		return catTSTest
+	if isInTestDir(file, lang.testDirPatterns) || (extraTestDirs != nil && isInTestDir(file, extraTestDirs)) {
+		return lines
 	}
-	return catTSProd
-}
-
-func isTestFilename(base string) bool {
-	return strings.HasSuffix(base, ".test.ts") || strings.HasSuffix(base, ".test.tsx") ||
-		strings.HasSuffix(base, ".spec.ts") || strings.HasSuffix(base, ".spec.tsx")
-}
-
-// isTestPath checks if a file lives under a test/tests/e2e directory.
-func isTestPath(file string) bool {
-	for part := range strings.SplitSeq(file, "/") {
-		switch part {
-		case "test", "tests", "__tests__", "e2e", "testutil", "testdata":
-			return true
-		}
+	if isTestFilename(filepath.Base(file), lang.testFilePatterns) {
+		return lines
 	}
-	return false
+	return inlineTestLines
 }
 
-// countRustTestLines counts lines inside #[cfg(test)] blocks using brace-depth tracking.
-func countRustTestLines(content string) int {
+var openBrace, closeBrace = []byte("{"), []byte("}")
+
+// countZigTestLines counts lines inside `test "..." { ... }` blocks using brace-depth tracking.
+func countZigTestLines(content []byte) int {
 	testLines := 0
 	depth := 0
 	inTestBlock := false
 
-	for line := range strings.SplitSeq(content, "\n") {
-		trimmed := strings.TrimSpace(line)
+	for line := range bytes.SplitSeq(content, []byte("\n")) {
+		trimmed := bytes.TrimSpace(line)
 
-		if !inTestBlock && strings.Contains(trimmed, "#[cfg(test)]") {
+		if !inTestBlock && (bytes.HasPrefix(trimmed, []byte(`test "`)) || bytes.HasPrefix(trimmed, []byte("test {"))) {
 			inTestBlock = true
 			testLines++
-			// The opening brace may be on this same line
-			depth += strings.Count(line, "{") - strings.Count(line, "}")
+			depth += bytes.Count(line, openBrace) - bytes.Count(line, closeBrace)
 			continue
 		}
 
 		if inTestBlock {
 			testLines++
-			depth += strings.Count(line, "{") - strings.Count(line, "}")
+			depth += bytes.Count(line, openBrace) - bytes.Count(line, closeBrace)
 			if depth <= 0 {
 				inTestBlock = false
 				depth = 0
@@ -227,78 +253,62 @@ func countRustTestLines(content string) int {
 	return testLines
 }
 
-func countLinesForCommit(commitHash string, messages []string) (*fileStats, error) {
-	stats := &fileStats{comments: messages}
+// countLinesForCommit brings state up to date with commitHash and returns the stats for it.
+// If prevHash is empty, state must be empty and the whole tree is read. Otherwise state must hold the
+// files at prevHash, and only the files that changed between the two commits are re-counted. If a
+// .gitattributes file changed, the whole tree is read again, since it may change how any file below it counts.
+func countLinesForCommit(state *treeState, prevHash, commitHash string, messages []string) (*fileStats, error) {
+	if prevHash == "" {
+		return countAllFiles(state, commitHash, messages)
+	}
 
-	files, err := getFilesAtCommit(commitHash)
+	changes, err := getChangedFiles(state.repo, state.scope, prevHash, commitHash)
 	if err != nil {
 		return nil, err
 	}
+	attributesChanged := slices.ContainsFunc(changes, func(c fileChange) bool {
+		_, ok := attributesDir(c.path)
+		return ok
+	})
+	if attributesChanged {
+		state.clear()
+		return countAllFiles(state, commitHash, messages)
+	}
+	if err := state.loadAttributes(); err != nil {
+		return nil, err
+	}
 
-	// Collect blobs for non-skipped files
-	var wanted []fileEntry
-	for _, f := range files {
-		if !shouldSkip(f.path) {
-			wanted = append(wanted, f)
+	var changed []fileEntry
+	for _, c := range changes {
+		if c.deleted {
+			state.remove(c.path)
+		} else {
+			changed = append(changed, fileEntry{path: c.path, blob: c.blob})
 		}
 	}
-
-	blobs := make([]string, len(wanted))
-	for i, f := range wanted {
-		blobs[i] = f.blob
+	if err := state.countFiles(changed); err != nil {
+		return nil, err
 	}
+	return state.stats(messages), nil
+}
 
-	contents, err := batchGetFileContents(blobs)
+// countAllFiles fills the empty state with every file at commitHash, and returns the stats for it.
+func countAllFiles(state *treeState, commitHash string, messages []string) (*fileStats, error) {
+	files, err := getFilesAtCommit(state.repo, state.scope, commitHash)
 	if err != nil {
 		return nil, err
 	}
-
-	for _, f := range wanted {
-		content, ok := contents[f.blob]
-		if !ok {
-			continue // binary or missing
+	// The attributes apply to every file, so they're loaded before counting any
+	for _, f := range files {
+		if dir, ok := attributesDir(f.path); ok {
+			state.attributeBlobs[dir] = f.blob
 		}
-		accumulateFileStats(stats, f.path, content)
 	}
-
-	return stats, nil
-}
-
-func accumulateFileStats(stats *fileStats, path, content string) {
-	lines := countLines(content)
-	stats.total += lines
-	cat := categorizeFile(path)
-
-	// For Rust prod files, split inline #[cfg(test)] lines from prod lines.
-	if cat == catRustProd {
-		testLines := countRustTestLines(content)
-		stats.rustProd += lines - testLines
-		stats.rustTest += testLines
-		stats.rust += lines
-		return
+	if err := state.loadAttributes(); err != nil {
+		return nil, err
 	}
-
-	switch cat {
-	case catRustTest:
-		stats.rustTest += lines
-		stats.rust += lines
-	case catTSProd:
-		stats.tsProd += lines
-		stats.ts += lines
-	case catTSTest:
-		stats.tsTest += lines
-		stats.ts += lines
-	case catSvelte:
-		stats.svelte += lines
-	case catAstro:
-		stats.astro += lines
-	case catGo:
-		stats.goTotal += lines
-	case catCSS:
-		stats.css += lines
-	case catDocs:
-		stats.docs += lines
-	case catOther:
-		stats.other += lines
+	if err := state.countFiles(files); err != nil {
+		return nil, err
 	}
+	return state.stats(messages), nil
 }
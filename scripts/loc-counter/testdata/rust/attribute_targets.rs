@@ -0,0 +1,65 @@
+use std::collections::HashMap;
+
+#[cfg(test)]
+use std::cell::RefCell;
+
+#[cfg(test)]
+mod fixtures;
+
+#[cfg(not(test))]
+fn clock() -> u64 {
+    42
+}
+
+#[cfg(test)]
+fn clock() -> u64 {
+    0
+}
+
+#[cfg_attr(test, derive(Debug))]
+pub struct Config {
+    pub name: String,
+    #[cfg(test)]
+    pub calls: RefCell<Vec<String>>,
+    pub retries: u32,
+}
+
+#[cfg(any(test, feature = "mock"))]
+pub fn mock() -> Config {
+    todo!()
+}
+
+#[cfg(all(test, feature = "slow"))]
+impl<K, V> Drop for Cache<K, V> {
+    fn drop(&mut self) {}
+}
+
+pub struct Cache<K, V> {
+    map: HashMap<K, V>,
+}
+
+pub fn describe(n: u32) -> &'static str {
+    match n {
+        0 => "zero",
+        #[cfg(test)]
+        1 => "one",
+        _ => "many",
+    }
+}
+
+// #[cfg(test)]
+pub fn not_a_test() {}
+
+pub const DOC: &str = "#[cfg(test)] mod tests {";
+
+#[cfg(test)]
+#[path = "support/helpers.rs"]
+mod helpers;
+
+#[cfg(test)] const LIMIT: usize = 3;
+
+#[cfg(test)]
+static NAMES: [&str; 2] = [
+    "a",
+    "b",
+];
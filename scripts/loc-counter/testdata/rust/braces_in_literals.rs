@@ -0,0 +1,53 @@
+//! A tiny JSON-ish tokenizer, with braces in every kind of literal.
+
+use std::fmt;
+
+pub enum Token {
+    Open,
+    Close,
+    Text(String),
+}
+
+impl fmt::Display for Token {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            Token::Open => write!(f, "{{"),
+            Token::Close => write!(f, "}}"),
+            Token::Text(s) => write!(f, "{s}"),
+        }
+    }
+}
+
+pub fn tokenize(input: &str) -> Vec<Token> {
+    let mut tokens = Vec::new();
+    for c in input.chars() {
+        match c {
+            '{' => tokens.push(Token::Open),
+            '}' => tokens.push(Token::Close),
+            _ => tokens.push(Token::Text(c.to_string())),
+        }
+    }
+    tokens
+}
+
+pub const TEMPLATE: &str = r#"{"name": "}", "nested": {"#;
+pub const BYTES: &[u8] = b"}}}";
+pub const RAW_BYTES: &[u8] = br##"{"#}"##;
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn closes() {
+        let tokens = tokenize("}");
+        assert_eq!(tokens.len(), 1);
+        assert_eq!(format!("{}", tokens[0]), "}}");
+        let c = '}';
+        assert_eq!(c, '}');
+    }
+}
+
+pub fn after_tests() -> &'static str {
+    "{"
+}
@@ -0,0 +1,21 @@
+/* A block comment with an unbalanced brace: {
+   /* and a nested one: } { */
+   still a comment { */
+
+/// Examples:
+///
+/// ```
+/// #[cfg(test)]
+/// mod tests {
+/// ```
+pub fn documented() {} // {
+
+#[cfg(test)] // { a trailing comment
+mod tests {
+    // }
+    /* } */
+    #[test]
+    fn works() {}
+}
+
+pub fn after() {}
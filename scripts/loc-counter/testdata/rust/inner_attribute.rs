@@ -0,0 +1,8 @@
+//! Integration helpers that only build for tests.
+#![cfg(test)]
+
+use std::path::PathBuf;
+
+pub fn fixture(name: &str) -> PathBuf {
+    PathBuf::from("tests/fixtures").join(name)
+}
@@ -0,0 +1,17 @@
+pub fn add(a: i32, b: i32) -> i32 {
+    a + b
+}
+
+mod tests {
+    #![cfg(test)]
+    use super::add;
+
+    #[test]
+    fn adds() {
+        assert_eq!(add(1, 2), 3);
+    }
+}
+
+pub fn sub(a: i32, b: i32) -> i32 {
+    a - b
+}
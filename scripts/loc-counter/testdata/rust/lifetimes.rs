@@ -0,0 +1,42 @@
+pub struct Parser<'a> {
+    input: &'a str,
+}
+
+impl<'a> Parser<'a> {
+    pub fn new(input: &'a str) -> Self {
+        Parser { input }
+    }
+
+    pub fn quoted(&self) -> Option<&'a str> {
+        let mut start = None;
+        'outer: for (i, c) in self.input.char_indices() {
+            match c {
+                '\'' | '"' => {
+                    start = Some(i);
+                    break 'outer;
+                }
+                '\\' => continue 'outer,
+                '\u{7B}' => {}
+                _ => {}
+            }
+        }
+        start.map(|i| &self.input[i..])
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::Parser;
+
+    fn parse<'b>(s: &'b str) -> Parser<'b> {
+        Parser::new(s)
+    }
+
+    #[test]
+    fn finds_quotes() {
+        assert_eq!(parse("a'b").quoted(), Some("'b"));
+        assert_eq!(b'{', 123);
+        let brace = '{';
+        assert!(brace != '}');
+    }
+}
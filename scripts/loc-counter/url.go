@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// supportedHosts are the forges whose URLs the web app accepts (see src/lib/url.ts).
+var supportedHosts = []string{"github.com", "gitlab.com", "bitbucket.org"}
+
+// shorthandRe matches the owner/repo shorthand for GitHub repos.
+var shorthandRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+/[a-zA-Z0-9_.-]+$`)
+
+// scpLikeURLRe matches SSH remotes in scp syntax, like git@github.com:owner/repo.git.
+var scpLikeURLRe = regexp.MustCompile(`^[^@/]+@([^:/]+):(.+)$`)
+
+// normalizeRemoteURL turns a git remote URL into the form the web app uses as a cache key,
+// like https://github.com/owner/repo. SSH remotes on supported hosts are rewritten to HTTPS.
+// Other URLs are returned with only the .git suffix and trailing slashes removed.
+func normalizeRemoteURL(remote string) string {
+	remote = strings.TrimSpace(remote)
+	host, path := "", ""
+
+	if m := scpLikeURLRe.FindStringSubmatch(remote); m != nil {
+		host, path = m[1], m[2]
+	} else if u, err := url.Parse(remote); err == nil && u.Host != "" {
+		host, path = u.Hostname(), u.Path
+	}
+
+	host = strings.ToLower(host)
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	segments := strings.Split(path, "/")
+	for _, supported := range supportedHosts {
+		if host == supported && len(segments) >= 2 {
+			return "https://" + host + "/" + strings.ToLower(segments[0]) + "/" + strings.ToLower(segments[1])
+		}
+	}
+
+	return strings.TrimSuffix(strings.TrimRight(remote, "/"), ".git")
+}
+
+// detectRepoURL returns the normalized URL of the origin remote, or a file:// URL for repos without one.
+func detectRepoURL(repo repository) string {
+	if remote, ok, err := repo.remoteURL("origin"); err == nil && ok {
+		return normalizeRemoteURL(remote)
+	}
+	return "file://" + filepath.ToSlash(repo.dir())
+}
+
+// remoteRepo is a repo to clone, as parsed by parseRepoURL.
+type remoteRepo struct {
+	url   string // Normalized, like https://github.com/owner/repo, or a file:// URL
+	host  string // Empty for file:// URLs
+	owner string
+	name  string
+}
+
+// parseRepoURL parses the repo URLs the web app accepts (see parseRepoUrl in src/lib/url.ts): HTTPS URLs on
+// supported hosts with or without .git, and owner/repo shorthand for GitHub. file:// URLs are accepted too.
+func parseRepoURL(input string) (remoteRepo, error) {
+	trimmed := strings.TrimSpace(input)
+	if trimmed == "" {
+		return remoteRepo{}, fmt.Errorf("repository URL is empty")
+	}
+
+	if isShorthand(trimmed) {
+		owner, name, _ := strings.Cut(strings.ToLower(trimmed), "/")
+		return remoteRepo{url: "https://github.com/" + owner + "/" + name, host: "github.com", owner: owner, name: name}, nil
+	}
+
+	u, err := url.Parse(trimmed)
+	if err != nil || u.Scheme == "" {
+		return remoteRepo{}, fmt.Errorf("invalid repository URL: %s", trimmed)
+	}
+	if u.Scheme == "file" {
+		if u.Host != "" && u.Host != "localhost" {
+			return remoteRepo{}, fmt.Errorf("file URLs can't have a host: %s", trimmed)
+		}
+		path := strings.TrimRight(u.Path, "/")
+		if path == "" {
+			return remoteRepo{}, fmt.Errorf("file URL has no path: %s", trimmed)
+		}
+		return remoteRepo{url: "file://" + path, name: strings.TrimSuffix(filepath.Base(filepath.FromSlash(path)), ".git")}, nil
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return remoteRepo{}, fmt.Errorf("unsupported protocol: %s", u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if !slices.Contains(supportedHosts, host) {
+		return remoteRepo{}, fmt.Errorf("unsupported host: %s (supported: %s)", host, strings.Join(supportedHosts, ", "))
+	}
+
+	path := strings.TrimLeft(strings.TrimRight(strings.TrimSuffix(u.Path, ".git"), "/"), "/")
+	segments := slices.DeleteFunc(strings.Split(path, "/"), func(s string) bool { return s == "" })
+	if len(segments) < 2 {
+		return remoteRepo{}, fmt.Errorf("URL must contain owner and repository name")
+	}
+	owner, name := strings.ToLower(segments[0]), strings.ToLower(segments[1])
+	return remoteRepo{url: "https://" + host + "/" + owner + "/" + name, host: host, owner: owner, name: name}, nil
+}
+
+// isShorthand reports whether s is owner/repo shorthand for a GitHub repo. GitHub doesn't allow . or .. as names,
+// so ./repo and ../repo are always paths.
+func isShorthand(s string) bool {
+	owner, name, _ := strings.Cut(s, "/")
+	return shorthandRe.MatchString(s) && owner != "." && owner != ".." && name != "." && name != ".."
+}
+
+// isRepoURL reports whether a --repo argument should be cloned rather than opened: it's a URL, or owner/repo
+// shorthand that isn't also an existing directory. Shorthand whose owner is a local directory, like src/foo when
+// only src exists, is more likely a mistyped path, so it's an error rather than a guess.
+func isRepoURL(arg string) (bool, error) {
+	if strings.Contains(arg, "://") {
+		return true, nil
+	}
+	if _, err := os.Stat(arg); err == nil {
+		return false, nil
+	}
+	trimmed := strings.TrimSpace(arg)
+	if !isShorthand(trimmed) {
+		return false, nil
+	}
+	owner, _, _ := strings.Cut(trimmed, "/")
+	if info, err := os.Stat(owner); err == nil && info.IsDir() {
+		return false, fmt.Errorf("%s could be a local path or the GitHub repo https://github.com/%s, but there's no "+
+			"directory %s (though there is %s/); fix the path, or pass the URL to clone the repo", trimmed,
+			strings.ToLower(trimmed), trimmed, owner)
+	}
+	return true, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeRemoteURL(t *testing.T) {
+	tests := []struct {
+		remote   string
+		expected string
+	}{
+		{"https://github.com/Owner/Repo.git", "https://github.com/owner/repo"},
+		{"https://github.com/owner/repo/", "https://github.com/owner/repo"},
+		{"git@github.com:owner/repo.git", "https://github.com/owner/repo"},
+		{"ssh://git@gitlab.com/group/project.git", "https://gitlab.com/group/project"},
+		{"https://bitbucket.org/team/repo", "https://bitbucket.org/team/repo"},
+		{"https://git.example.com/team/repo.git", "https://git.example.com/team/repo"},
+		{"/srv/git/repo.git", "/srv/git/repo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remote, func(t *testing.T) {
+			if got := normalizeRemoteURL(tt.remote); got != tt.expected {
+				t.Errorf("normalizeRemoteURL(%q) = %q, want %q", tt.remote, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseRepoURL(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    remoteRepo
+		wantErr bool
+	}{
+		{"https://github.com/Owner/Repo", remoteRepo{"https://github.com/owner/repo", "github.com", "owner", "repo"}, false},
+		{"https://github.com/owner/repo.git", remoteRepo{"https://github.com/owner/repo", "github.com", "owner", "repo"}, false},
+		{"  https://gitlab.com/group/project/-/tree/main/ ", remoteRepo{"https://gitlab.com/group/project", "gitlab.com", "group", "project"}, false},
+		{"http://bitbucket.org/team/repo", remoteRepo{"https://bitbucket.org/team/repo", "bitbucket.org", "team", "repo"}, false},
+		{"Owner/my.repo", remoteRepo{"https://github.com/owner/my.repo", "github.com", "owner", "my.repo"}, false},
+		{"../repo", remoteRepo{}, true},
+		{"owner/.", remoteRepo{}, true},
+		{"file:///srv/git/repo.git/", remoteRepo{"file:///srv/git/repo.git", "", "", "repo"}, false},
+		{"", remoteRepo{}, true},
+		{"not a url", remoteRepo{}, true},
+		{"ftp://github.com/owner/repo", remoteRepo{}, true},
+		{"https://example.com/owner/repo", remoteRepo{}, true},
+		{"https://github.com/owner", remoteRepo{}, true},
+		{"file://host/srv/repo", remoteRepo{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseRepoURL(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRepoURL(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseRepoURL(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRepoURL(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"src", "checkouts/api"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+
+	tests := []struct {
+		arg     string
+		want    bool
+		wantErr bool
+	}{
+		{"https://github.com/owner/repo", true, false},
+		{"owner/repo", true, false},
+		{"checkouts/api", false, false}, // An existing directory wins over shorthand
+		{"../repo", false, false},
+		{"./repo", false, false},
+		{"/srv/git/repo", false, false},
+		{"src/foo", false, true}, // Either a mistyped path under src, or the GitHub repo src/foo
+	}
+	for _, tt := range tests {
+		got, err := isRepoURL(tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("isRepoURL(%q) error = %v, wantErr %v", tt.arg, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("isRepoURL(%q) = %v, want %v", tt.arg, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,10 @@ export interface LanguageCount {
     total: number
     prod?: number
     test?: number
+    /** Code, comment, and blank lines, adding up to total. Only set by the LoC counter script. */
+    code?: number
+    comment?: number
+    blank?: number
 }
 
 /** One row per calendar date */